@@ -0,0 +1,91 @@
+package binaries
+
+import (
+	"encoding/binary"
+
+	"golang.org/x/arch/arm64/arm64asm"
+)
+
+// arm64Ops maps ARMv8.0 instructions to CPU database features
+var arm64Ops = map[arm64asm.Op]string{
+	arm64asm.CRC32B:  "crc32",
+	arm64asm.CRC32H:  "crc32",
+	arm64asm.CRC32W:  "crc32",
+	arm64asm.CRC32X:  "crc32",
+	arm64asm.CRC32CB: "crc32",
+	arm64asm.CRC32CH: "crc32",
+	arm64asm.CRC32CW: "crc32",
+	arm64asm.CRC32CX: "crc32",
+
+	arm64asm.AESD:   "aes",
+	arm64asm.AESE:   "aes",
+	arm64asm.AESIMC: "aes",
+	arm64asm.AESMC:  "aes",
+
+	arm64asm.SHA1C:   "sha1",
+	arm64asm.SHA1H:   "sha1",
+	arm64asm.SHA1M:   "sha1",
+	arm64asm.SHA1P:   "sha1",
+	arm64asm.SHA1SU0: "sha1",
+	arm64asm.SHA1SU1: "sha1",
+
+	arm64asm.SHA256H:   "sha2",
+	arm64asm.SHA256H2:  "sha2",
+	arm64asm.SHA256SU0: "sha2",
+	arm64asm.SHA256SU1: "sha2",
+}
+
+// arm64Pattern matches an instruction arm64asm doesn't know (it only
+// decodes ARMv8.0) by its fixed bits
+type arm64Pattern struct {
+	mask, value uint32
+	feature     string
+}
+
+var arm64Patterns = []arm64Pattern{
+	// LSE atomic memory operations (ldadd, swp...), and compare and swap
+	{0x3f200c00, 0x38200000, "atomics"},
+	{0x3fa07c00, 0x08a07c00, "atomics"},
+
+	// sdot and udot (vector)
+	{0x9fe0fc00, 0x0e809400, "asimddp"},
+
+	// SVE ptrue and the while loop predicates, which vectorized loops use
+	{0xff3ffc10, 0x2518e000, "sve"},
+	{0xff20e400, 0x25200400, "sve"},
+}
+
+// minPatternHits is how many times a pattern must match in a file. Literal
+// pools in text sections can look like anything, so one match isn't enough.
+const minPatternHits = 2
+
+// arm64Features decodes aarch64 instructions, which are always 4 bytes and
+// little endian, and records the features they need
+func arm64Features(text []byte, features map[string]bool) {
+	hits := map[string]int{}
+	for i := 0; i+4 <= len(text); i += 4 {
+		word := binary.LittleEndian.Uint32(text[i:])
+		if inst, err := arm64asm.Decode(text[i : i+4]); err == nil {
+			if feature, ok := arm64Ops[inst.Op]; ok {
+				features[feature] = true
+			}
+
+			// pmull on 64-bit elements is part of the crypto extension
+			if (inst.Op == arm64asm.PMULL || inst.Op == arm64asm.PMULL2) && word>>22&0x3 == 0x3 {
+				features["pmull"] = true
+			}
+			continue
+		}
+		for _, pattern := range arm64Patterns {
+			if word&pattern.mask == pattern.value {
+				hits[pattern.feature]++
+				break
+			}
+		}
+	}
+	for feature, count := range hits {
+		if count >= minPatternHits {
+			features[feature] = true
+		}
+	}
+}
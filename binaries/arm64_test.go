@@ -0,0 +1,46 @@
+package binaries
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// arm64Code encodes instructions as little endian words
+func arm64Code(words ...uint32) []byte {
+	code := make([]byte, 4*len(words))
+	for i, word := range words {
+		binary.LittleEndian.PutUint32(code[4*i:], word)
+	}
+	return code
+}
+
+func TestArm64Features(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     []byte
+		features []string
+	}{
+		{name: "crc32b", code: arm64Code(0x1ac24020), features: []string{"crc32"}},
+		{name: "aese", code: arm64Code(0x4e284820), features: []string{"aes"}},
+		{name: "sha1c", code: arm64Code(0x5e020020), features: []string{"sha1"}},
+		{name: "sha256h", code: arm64Code(0x5e024020), features: []string{"sha2"}},
+		{name: "pmull of 64-bit elements", code: arm64Code(0x0ee2e020), features: []string{"pmull"}},
+		{name: "pmull of 8-bit elements", code: arm64Code(0x0e22e020), features: []string{}},
+
+		// Instructions after ARMv8.0 need two matches
+		{name: "one ldadd", code: arm64Code(0xb8210040), features: []string{}},
+		{name: "ldadd", code: arm64Code(0xb8210040, 0xb8210040), features: []string{"atomics"}},
+		{name: "casal and ldadd", code: arm64Code(0x88e0fc41, 0xb8210040), features: []string{"atomics"}},
+		{name: "sdot", code: arm64Code(0x4e829420, 0x4e829420), features: []string{"asimddp"}},
+		{name: "ptrue and whilelo", code: arm64Code(0x2598e3e0, 0x25a10c00), features: []string{"sve"}},
+		{name: "trailing bytes", code: append(arm64Code(0x1ac24020), 0x20, 0x40), features: []string{"crc32"}},
+	}
+	for _, test := range tests {
+		features := map[string]bool{}
+		arm64Features(test.code, features)
+		if got := sortedKeys(features); !reflect.DeepEqual(got, test.features) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.features, got)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package binaries
+
+import (
+	"bytes"
+	"debug/buildinfo"
+	"debug/elf"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/vsoch/containerspec/rootfs"
+	"github.com/vsoch/containerspec/spec"
+)
+
+// A container's label can lie, its binaries can't. We disassemble the text
+// sections of ELF files to find the instruction set extensions they use.
+
+// ErrNotELF is returned for files that aren't ELF executables or libraries
+var ErrNotELF = errors.New("not an ELF file")
+
+// File is what we learn from one ELF executable or shared object
+type File struct {
+	Path     string   `json:"path"`
+	Family   string   `json:"family"`
+	Features []string `json:"features,omitempty"`
+
+	// ISALevel is the x86 ISA level (e.g., x86_64_v3) from the GNU property
+	// note, for files built with -march=x86-64-vN by a recent toolchain
+	ISALevel string `json:"isa_level,omitempty"`
+
+	// Versions are the highest GLIBC, GLIBCXX and CXXABI symbol versions the
+	// file needs, e.g., {"GLIBC": "2.34"}
+	Versions map[string]string `json:"versions,omitempty"`
+
+	// Dispatch is true when the file picks code for the processor at runtime
+	// (e.g., glibc string functions, or code that runs cpuid), or the loader
+	// picks the file (glibc-hwcaps), so its features aren't required
+	Dispatch bool `json:"dispatch,omitempty"`
+}
+
+// Analysis summarizes the ELF files of a root file system
+type Analysis struct {
+	Family   string   `json:"family,omitempty"`
+	Features []string `json:"features,omitempty"`
+	Target   string   `json:"target,omitempty"`
+
+	// Versions are the highest symbol versions any file needs, so the GLIBC
+	// version is a verified glibc requirement for the host
+	Versions map[string]string `json:"versions,omitempty"`
+
+	// HWCaps are the targets of optimized libraries in glibc-hwcaps
+	// subdirectories, which hosts that support them will use
+	HWCaps []string `json:"hwcaps,omitempty"`
+	Files  []File   `json:"files,omitempty"`
+}
+
+// Options for the analysis
+type Options struct {
+	// Quick only reads ISA level notes and glibc-hwcaps, which is cheap and
+	// accurate for recent toolchains, but misses files without notes
+	Quick bool
+}
+
+// Analyze disassembles every ELF file in a root file system. The required
+// features are those used by files of the main architecture family that
+// don't dispatch at runtime, with those of their ISA levels, and the target
+// is the lowest microarchitecture in the CPU database that supports all of
+// them.
+func Analyze(fsys fs.FS, opts Options) (*Analysis, error) {
+	analysis := Analysis{Versions: map[string]string{}, Files: []File{}}
+	families := map[string]int{}
+	hwcaps := map[string]bool{}
+	err := rootfs.WalkFiles(fsys, func(name string, d fs.DirEntry) error {
+		file, err := AnalyzeFile(fsys, name, opts)
+		if err != nil {
+			return nil
+		}
+		if dir, ok := hwcapsDir(name); ok {
+			if target, ok := hwcapsTargets[dir]; ok {
+				hwcaps[target] = true
+			}
+		}
+		families[file.Family]++
+		for runtime, version := range file.Versions {
+			mergeVersion(analysis.Versions, runtime, version)
+		}
+		analysis.Files = append(analysis.Files, *file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	count := 0
+	for family, n := range families {
+		if n > count || (n == count && family < analysis.Family) {
+			analysis.Family, count = family, n
+		}
+	}
+	features := map[string]bool{}
+	for _, file := range analysis.Files {
+		if file.Family != analysis.Family || file.Dispatch {
+			continue
+		}
+		for _, feature := range file.Features {
+			features[feature] = true
+		}
+		if arch, ok := spec.LookupMicroarchitecture(file.ISALevel); ok {
+			for _, feature := range arch.Features {
+				features[feature] = true
+			}
+		}
+	}
+	analysis.Features = sortedKeys(features)
+	analysis.HWCaps = sortedKeys(hwcaps)
+
+	// No target when no entry in the database has everything used
+	if arch, ok := spec.LowestMicroarchitecture(analysis.Family, analysis.Features); ok {
+		analysis.Target = arch.Name
+	}
+	return &analysis, nil
+}
+
+// AnalyzeFile reads the ISA level note and disassembles the executable
+// sections of one ELF file
+func AnalyzeFile(fsys fs.FS, name string, opts Options) (*File, error) {
+	file, err := OpenELF(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	result := File{
+		Path:     name,
+		Family:   spec.MachineFamily(file.Machine, file.ByteOrder),
+		ISALevel: isaLevel(file.File),
+		Versions: requiredVersions(file.File),
+	}
+	_, result.Dispatch = hwcapsDir(name)
+	if opts.Quick {
+		return &result, nil
+	}
+
+	// The Go runtime picks code for the processor itself, but the build
+	// records the level the compiler could assume
+	if info, err := buildinfo.Read(file.reader); err == nil {
+		result.Features = goFeatures(info)
+		return &result, nil
+	}
+
+	result.Dispatch = result.Dispatch || dispatches(name, file.File)
+	features := map[string]bool{}
+	for _, section := range file.Sections {
+		if section.Type != elf.SHT_PROGBITS || section.Flags&elf.SHF_EXECINSTR == 0 {
+			continue
+		}
+		text, err := section.Data()
+		if err != nil {
+			return nil, err
+		}
+		switch file.Machine {
+		case elf.EM_X86_64:
+			if x86Features(text, features) {
+				result.Dispatch = true
+			}
+		case elf.EM_AARCH64:
+			arm64Features(text, features)
+		}
+	}
+	result.Features = sortedKeys(features)
+	return &result, nil
+}
+
+// ELF is an ELF file open in a root file system
+type ELF struct {
+	*elf.File
+	reader io.ReaderAt
+	closer io.Closer
+}
+
+// Close closes the underlying file
+func (e *ELF) Close() error {
+	return e.closer.Close()
+}
+
+// OpenELF opens a file in a root file system as ELF, resolving symbolic
+// links within the root. Files from rootfs.Dir and squashfs can be read at
+// an offset, others (e.g., from a rootfs.LayerFS) are read into memory.
+func OpenELF(fsys fs.FS, name string) (*ELF, error) {
+	file, err := rootfs.Open(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	magic := make([]byte, len(elf.ELFMAG))
+	if _, err := io.ReadFull(file, magic); err != nil || string(magic) != elf.ELFMAG {
+		file.Close()
+		return nil, ErrNotELF
+	}
+
+	reader, ok := file.(io.ReaderAt)
+	if !ok {
+		content, err := io.ReadAll(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		reader = bytes.NewReader(append(magic, content...))
+	}
+	parsed, err := elf.NewFile(reader)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &ELF{File: parsed, reader: reader, closer: file}, nil
+}
+
+// goFeatures returns the features a Go binary was allowed to use, from the
+// GOAMD64 level or GOARM64 version and options (e.g., v8.0,lse,crypto)
+func goFeatures(info *buildinfo.BuildInfo) []string {
+	features := map[string]bool{}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "GOAMD64":
+			level := strings.Replace(setting.Value, "v", "x86_64_v", 1)
+			if arch, ok := spec.LookupMicroarchitecture(level); ok {
+				for _, feature := range arch.Features {
+					features[feature] = true
+				}
+			}
+		case "GOARM64":
+			parts := strings.Split(setting.Value, ",")
+			if parts[0] != "v8.0" {
+				features["atomics"] = true
+			}
+			for _, option := range parts[1:] {
+				switch option {
+				case "lse":
+					features["atomics"] = true
+				case "crypto":
+					for _, feature := range []string{"aes", "pmull", "sha1", "sha2"} {
+						features[feature] = true
+					}
+				}
+			}
+		}
+	}
+	return sortedKeys(features)
+}
+
+// dispatches determines if a file selects code for the processor at runtime.
+// Functions resolved by GNU indirect functions (STT_GNU_IFUNC, which debug/elf
+// calls STT_LOOS) have variants for many processors, and the dynamic loader
+// saves vector registers based on what the processor has.
+func dispatches(name string, file *elf.File) bool {
+	base := path.Base(name)
+	if strings.HasPrefix(base, "ld-linux") || strings.HasPrefix(base, "ld64.so") {
+		return true
+	}
+	for _, load := range []func() ([]elf.Symbol, error){file.DynamicSymbols, file.Symbols} {
+		symbols, _ := load()
+		for _, symbol := range symbols {
+			if elf.ST_TYPE(symbol.Info) == elf.STT_LOOS && symbol.Section != elf.SHN_UNDEF {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := []string{}
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,73 @@
+package binaries
+
+import (
+	"debug/elf"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/vsoch/containerspec/internal/elftest"
+)
+
+// elfFile is an ELF file for a root file system
+func elfFile(file elftest.File) *fstest.MapFile {
+	return &fstest.MapFile{Data: file.Bytes(), Mode: 0755}
+}
+
+var (
+	// vpaddd ymm and vfmadd231ps, then popcnt
+	haswellCode = []byte{0xc5, 0xf5, 0xfe, 0xc2, 0xc4, 0xe2, 0x75, 0xb8, 0xc2, 0xf3, 0x0f, 0xb8, 0xc1}
+
+	// vaddps zmm
+	avx512Code = []byte{0x62, 0xf1, 0x74, 0x48, 0x58, 0xc2}
+)
+
+func TestAnalyze(t *testing.T) {
+	fsys := fstest.MapFS{
+		"usr/bin/app":  elfFile(elftest.File{Text: haswellCode}),
+		"usr/bin/tool": elfFile(elftest.File{Text: []byte{0xf3, 0x0f, 0xb8, 0xc1}}),
+
+		// Files that pick code for the processor don't require it
+		"usr/lib/libc.so.6":          elfFile(elftest.File{Text: avx512Code, IFuncs: []string{"memcpy"}}),
+		"usr/lib/libcrypto.so.3":     elfFile(elftest.File{Text: append([]byte{0x0f, 0xa2}, avx512Code...)}),
+		"lib64/ld-linux-x86-64.so.2": elfFile(elftest.File{Text: avx512Code}),
+
+		// Other families and files that aren't ELF are counted apart
+		"opt/arm/bin/app": elfFile(elftest.File{Machine: elf.EM_AARCH64, Text: arm64Code(0x1ac24020)}),
+		"etc/hostname":    &fstest.MapFile{Data: []byte("container\n")},
+	}
+	analysis, err := Analyze(fsys, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if analysis.Family != "x86_64" || len(analysis.Files) != 6 {
+		t.Errorf("expected 6 files, mostly x86_64, got %d of %s", len(analysis.Files), analysis.Family)
+	}
+	want := "avx,avx2,fma,popcnt"
+	if got := strings.Join(analysis.Features, ","); got != want {
+		t.Errorf("expected features %s, got %s", want, got)
+	}
+	if analysis.Target != "x86_64_v3" {
+		t.Errorf("expected target x86_64_v3, got %s", analysis.Target)
+	}
+	for _, file := range analysis.Files {
+		dispatch := file.Path == "usr/lib/libc.so.6" || file.Path == "usr/lib/libcrypto.so.3" || file.Path == "lib64/ld-linux-x86-64.so.2"
+		if file.Dispatch != dispatch {
+			t.Errorf("%s: expected dispatch %v, got %v", file.Path, dispatch, file.Dispatch)
+		}
+	}
+
+	file, err := AnalyzeFile(fsys, "opt/arm/bin/app", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Family != "aarch64" || strings.Join(file.Features, ",") != "crc32" {
+		t.Errorf("expected aarch64 with crc32, got %s with %v", file.Family, file.Features)
+	}
+	if file, err := AnalyzeFile(fsys, "usr/bin/app", Options{Quick: true}); err != nil || len(file.Features) != 0 {
+		t.Errorf("expected a quick analysis not to disassemble, got %v (%v)", file, err)
+	}
+	if _, err := AnalyzeFile(fsys, "etc/hostname", Options{}); err != ErrNotELF {
+		t.Errorf("expected ErrNotELF, got %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package binaries
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"io"
+	"path"
+	"strings"
+)
+
+// GNU property note types and the x86 ISA level property, which toolchains
+// (gcc -mneeded, binutils 2.36+) record for -march=x86-64-vN
+const (
+	ntGNUPropertyType0      = 5
+	gnuPropertyX86ISANeeded = 0xc0008002
+)
+
+// x86ISALevels are the bits of the x86 ISA needed property, highest first
+var x86ISALevels = []struct {
+	bit    uint32
+	target string
+}{
+	{1 << 3, "x86_64_v4"},
+	{1 << 2, "x86_64_v3"},
+	{1 << 1, "x86_64_v2"},
+	{1 << 0, "x86_64"},
+}
+
+// hwcapsTargets maps glibc-hwcaps subdirectories to CPU database entries
+var hwcapsTargets = map[string]string{
+	"x86-64-v2": "x86_64_v2",
+	"x86-64-v3": "x86_64_v3",
+	"x86-64-v4": "x86_64_v4",
+	"power9":    "power9le",
+}
+
+// isaLevel returns the x86 ISA level an ELF file needs, from its GNU
+// property note, or an empty string if it doesn't have one
+func isaLevel(file *elf.File) string {
+	if file.Machine != elf.EM_X86_64 {
+		return ""
+	}
+	notes := []io.Reader{}
+	for _, prog := range file.Progs {
+		if prog.Type == elf.PT_GNU_PROPERTY {
+			notes = append(notes, prog.Open())
+		}
+	}
+	if len(notes) == 0 {
+		if section := file.Section(".note.gnu.property"); section != nil {
+			notes = append(notes, section.Open())
+		}
+	}
+	for _, reader := range notes {
+		content, err := io.ReadAll(reader)
+		if err != nil {
+			continue
+		}
+		if needed, ok := x86ISANeeded(content, file.ByteOrder); ok {
+			for _, level := range x86ISALevels {
+				if needed&level.bit != 0 {
+					return level.target
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// x86ISANeeded finds the x86 ISA needed bits in GNU property notes. Notes
+// and properties are 8 byte aligned in 64-bit files.
+func x86ISANeeded(content []byte, order binary.ByteOrder) (uint32, bool) {
+	for len(content) >= 12 {
+		nameSize := align(order.Uint32(content[0:]), 4)
+		descSize := order.Uint32(content[4:])
+		noteType := order.Uint32(content[8:])
+		if uint64(12)+uint64(nameSize)+uint64(descSize) > uint64(len(content)) {
+			return 0, false
+		}
+		name := string(content[12 : 12+nameSize])
+		desc := content[12+nameSize : 12+nameSize+descSize]
+		content = content[min(len(content), int(12+nameSize+align(descSize, 8))):]
+		if noteType != ntGNUPropertyType0 || strings.TrimRight(name, "\x00") != "GNU" {
+			continue
+		}
+
+		for len(desc) >= 8 {
+			propertyType := order.Uint32(desc[0:])
+			size := order.Uint32(desc[4:])
+			if uint64(8)+uint64(size) > uint64(len(desc)) {
+				break
+			}
+			if propertyType == gnuPropertyX86ISANeeded && size >= 4 {
+				return order.Uint32(desc[8:]), true
+			}
+			desc = desc[min(len(desc), int(8+align(size, 8))):]
+		}
+	}
+	return 0, false
+}
+
+func align(n, to uint32) uint32 {
+	return (n + to - 1) &^ (to - 1)
+}
+
+// hwcapsDir returns the glibc-hwcaps subdirectory (e.g., x86-64-v3) a
+// library is in. The dynamic loader only picks it if the processor supports
+// that level, so it isn't a requirement.
+func hwcapsDir(name string) (string, bool) {
+	dir := path.Dir(name)
+	if path.Base(path.Dir(dir)) != "glibc-hwcaps" {
+		return "", false
+	}
+	return path.Base(dir), true
+}
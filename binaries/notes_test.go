@@ -0,0 +1,130 @@
+package binaries
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/vsoch/containerspec/internal/elftest"
+)
+
+// note is an ELF note with its descriptor padded to 8 bytes
+func note(name string, noteType uint32, desc []byte) []byte {
+	var content bytes.Buffer
+	binary.Write(&content, binary.LittleEndian, []uint32{uint32(len(name) + 1), uint32(len(desc)), noteType})
+	content.WriteString(name + "\x00")
+	content.Write(make([]byte, int(align(uint32(len(name)+1), 4))-len(name)-1))
+	content.Write(desc)
+	content.Write(make([]byte, int(align(uint32(len(desc)), 8))-len(desc)))
+	return content.Bytes()
+}
+
+// property is a GNU property with a 4 byte value, padded to 8 bytes
+func property(propertyType, value uint32) []byte {
+	content := make([]byte, 16)
+	binary.LittleEndian.PutUint32(content[0:], propertyType)
+	binary.LittleEndian.PutUint32(content[4:], 4)
+	binary.LittleEndian.PutUint32(content[8:], value)
+	return content
+}
+
+func TestX86ISANeeded(t *testing.T) {
+	// The x86 feature property (IBT, SHSTK) often comes first
+	features := property(0xc0000002, 3)
+	tests := []struct {
+		name    string
+		content []byte
+		needed  uint32
+		ok      bool
+	}{
+		{name: "level", content: note("GNU", ntGNUPropertyType0, property(gnuPropertyX86ISANeeded, 1<<2)), needed: 1 << 2, ok: true},
+		{name: "after another property", content: note("GNU", ntGNUPropertyType0, append(features, property(gnuPropertyX86ISANeeded, 1<<1)...)), needed: 1 << 1, ok: true},
+		{
+			name:    "after another note",
+			content: append(note("GNU", 3, []byte("build-id")), note("GNU", ntGNUPropertyType0, property(gnuPropertyX86ISANeeded, 1<<3))...),
+			needed:  1 << 3,
+			ok:      true,
+		},
+		{name: "other owner", content: note("Go", ntGNUPropertyType0, property(gnuPropertyX86ISANeeded, 1<<2))},
+		{name: "no level", content: note("GNU", ntGNUPropertyType0, features)},
+		{name: "truncated note", content: note("GNU", ntGNUPropertyType0, property(gnuPropertyX86ISANeeded, 1<<2))[:20]},
+		{name: "property larger than the note", content: note("GNU", ntGNUPropertyType0, property(gnuPropertyX86ISANeeded, 1<<2)[:8])},
+		{name: "empty", content: []byte{}},
+	}
+	for _, test := range tests {
+		needed, ok := x86ISANeeded(test.content, binary.LittleEndian)
+		if needed != test.needed || ok != test.ok {
+			t.Errorf("%s: expected %b (%v), got %b (%v)", test.name, test.needed, test.ok, needed, ok)
+		}
+	}
+}
+
+func TestISALevel(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  elftest.File
+		level string
+	}{
+		{name: "baseline", file: elftest.File{Property: elftest.X86ISANeeded(1)}, level: "x86_64"},
+		{name: "v2", file: elftest.File{Property: elftest.X86ISANeeded(1<<1 | 1)}, level: "x86_64_v2"},
+		{name: "v3", file: elftest.File{Property: elftest.X86ISANeeded(1 << 2)}, level: "x86_64_v3"},
+		{name: "v4", file: elftest.File{Property: elftest.X86ISANeeded(1<<3 | 1<<2)}, level: "x86_64_v4"},
+		{name: "no note", file: elftest.File{}, level: ""},
+		{name: "aarch64", file: elftest.File{Machine: elf.EM_AARCH64, Property: elftest.X86ISANeeded(1 << 2)}, level: ""},
+	}
+	for _, test := range tests {
+		file, err := elf.NewFile(bytes.NewReader(test.file.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if level := isaLevel(file); level != test.level {
+			t.Errorf("%s: expected %q, got %q", test.name, test.level, level)
+		}
+
+		// Without the segment, the level is in the section
+		file.Progs = nil
+		if level := isaLevel(file); level != test.level {
+			t.Errorf("%s in the section: expected %q, got %q", test.name, test.level, level)
+		}
+	}
+}
+
+func TestHWCaps(t *testing.T) {
+	tests := []struct {
+		name string
+		dir  string
+		ok   bool
+	}{
+		{name: "usr/lib64/glibc-hwcaps/x86-64-v3/libz.so.1", dir: "x86-64-v3", ok: true},
+		{name: "usr/lib/powerpc64le-linux-gnu/glibc-hwcaps/power9/libc.so.6", dir: "power9", ok: true},
+		{name: "usr/lib64/glibc-hwcaps/libz.so.1"},
+		{name: "usr/lib64/libz.so.1"},
+	}
+	for _, test := range tests {
+		if dir, ok := hwcapsDir(test.name); dir != test.dir || ok != test.ok {
+			t.Errorf("%s: expected %q (%v), got %q (%v)", test.name, test.dir, test.ok, dir, ok)
+		}
+	}
+
+	// Optimized libraries are targets, not requirements, and a level note
+	// requires the features of the level
+	analysis, err := Analyze(fstest.MapFS{
+		"usr/bin/app":         elfFile(elftest.File{Property: elftest.X86ISANeeded(1 << 1)}),
+		"usr/lib64/libz.so.1": elfFile(elftest.File{}),
+		"usr/lib64/glibc-hwcaps/x86-64-v3/libz.so.1": elfFile(elftest.File{Property: elftest.X86ISANeeded(1 << 2), Text: haswellCode}),
+		"usr/lib64/glibc-hwcaps/x86-64-v4/libz.so.1": elfFile(elftest.File{Property: elftest.X86ISANeeded(1 << 3), Text: avx512Code}),
+		"usr/lib64/glibc-hwcaps/unknown/libz.so.1":   elfFile(elftest.File{}),
+	}, Options{Quick: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(analysis.HWCaps, ","); got != "x86_64_v3,x86_64_v4" {
+		t.Errorf("expected hwcaps x86_64_v3,x86_64_v4, got %s", got)
+	}
+	if analysis.Target != "x86_64_v2" {
+		t.Errorf("expected target x86_64_v2 from the level of the app, got %s (%v)", analysis.Target, analysis.Features)
+	}
+}
@@ -0,0 +1,39 @@
+package binaries
+
+import (
+	"debug/elf"
+	"regexp"
+
+	"github.com/vsoch/containerspec/utils"
+)
+
+// Symbol versions (e.g., GLIBC_2.34) in .gnu.version_r are the oldest
+// library that provides each symbol, so the highest one of each library is
+// what the file needs. We track the C and C++ runtimes.
+var versionRegex = regexp.MustCompile(`^(GLIBC|GLIBCXX|CXXABI)_([0-9]+(\.[0-9]+)*)$`)
+
+// requiredVersions returns the highest version needed of each runtime
+func requiredVersions(file *elf.File) map[string]string {
+	versions := map[string]string{}
+	needs, err := file.DynamicVersionNeeds()
+	if err != nil {
+		return versions
+	}
+	for _, need := range needs {
+		for _, dep := range need.Needs {
+			match := versionRegex.FindStringSubmatch(dep.Dep)
+			if match == nil {
+				continue
+			}
+			mergeVersion(versions, match[1], match[2])
+		}
+	}
+	return versions
+}
+
+// mergeVersion keeps the higher version of a runtime
+func mergeVersion(versions map[string]string, name, version string) {
+	if current, ok := versions[name]; !ok || utils.CompareVersions(version, current) > 0 {
+		versions[name] = version
+	}
+}
@@ -0,0 +1,85 @@
+package binaries
+
+import (
+	"bytes"
+	"debug/elf"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/vsoch/containerspec/internal/elftest"
+)
+
+func TestRequiredVersions(t *testing.T) {
+	tests := []struct {
+		name     string
+		needs    []elftest.Need
+		versions map[string]string
+	}{
+		{
+			name: "c and c++ runtimes",
+			needs: []elftest.Need{
+				{Library: "libstdc++.so.6", Versions: []string{"GLIBCXX_3.4.9", "CXXABI_1.3", "GLIBCXX_3.4.29", "CXXABI_1.3.13", "GLIBCXX_3.4.30"}},
+				{Library: "libm.so.6", Versions: []string{"GLIBC_2.29"}},
+				{Library: "libc.so.6", Versions: []string{"GLIBC_2.2.5", "GLIBC_2.34", "GLIBC_2.4", "GLIBC_PRIVATE"}},
+			},
+			versions: map[string]string{"GLIBC": "2.34", "GLIBCXX": "3.4.30", "CXXABI": "1.3.13"},
+		},
+		{
+			name: "other libraries",
+			needs: []elftest.Need{
+				{Library: "libgcc_s.so.1", Versions: []string{"GCC_3.0", "GCC_4.2.0"}},
+				{Library: "libmpi.so.40", Versions: []string{"OMPI_4.0"}},
+			},
+			versions: map[string]string{},
+		},
+		{name: "no versions", versions: map[string]string{}},
+	}
+	for _, test := range tests {
+		file, err := elf.NewFile(bytes.NewReader(elftest.File{Needs: test.needs}.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if versions := requiredVersions(file); !reflect.DeepEqual(versions, test.versions) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.versions, versions)
+		}
+	}
+}
+
+func TestMergeVersion(t *testing.T) {
+	versions := map[string]string{}
+	for _, version := range []string{"2.17", "2.34", "2.4", "2.28"} {
+		mergeVersion(versions, "GLIBC", version)
+	}
+	mergeVersion(versions, "GLIBCXX", "3.4.9")
+	mergeVersion(versions, "GLIBCXX", "3.4.30")
+	want := map[string]string{"GLIBC": "2.34", "GLIBCXX": "3.4.30"}
+	if !reflect.DeepEqual(versions, want) {
+		t.Errorf("expected %v, got %v", want, versions)
+	}
+}
+
+func TestAnalyzeVersions(t *testing.T) {
+	analysis, err := Analyze(fstest.MapFS{
+		"usr/bin/app": elfFile(elftest.File{Needs: []elftest.Need{
+			{Library: "libstdc++.so.6", Versions: []string{"GLIBCXX_3.4.21", "CXXABI_1.3.9"}},
+			{Library: "libc.so.6", Versions: []string{"GLIBC_2.17"}},
+		}}),
+		"usr/bin/tool": elfFile(elftest.File{Needs: []elftest.Need{{Library: "libc.so.6", Versions: []string{"GLIBC_2.28", "GLIBC_2.3"}}}}),
+		"usr/lib/libfoo.so.1": elfFile(elftest.File{Needs: []elftest.Need{
+			{Library: "libstdc++.so.6", Versions: []string{"GLIBCXX_3.4.26"}},
+		}}),
+	}, Options{Quick: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"GLIBC": "2.28", "GLIBCXX": "3.4.26", "CXXABI": "1.3.9"}
+	if !reflect.DeepEqual(analysis.Versions, want) {
+		t.Errorf("expected versions %v, got %v", want, analysis.Versions)
+	}
+	for _, file := range analysis.Files {
+		if file.Path == "usr/bin/tool" && !reflect.DeepEqual(file.Versions, map[string]string{"GLIBC": "2.28"}) {
+			t.Errorf("expected the tool to need GLIBC 2.28, got %v", file.Versions)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package binaries
+
+import (
+	"golang.org/x/arch/x86/x86asm"
+)
+
+// x86Ops maps legacy encoded instructions to CPU database features. The
+// x86_64 baseline (mmx, sse, sse2) isn't listed, and neither are
+// instructions that older processors run as something else (tzcnt is bsf)
+// or that only detect or save state (xgetbv, xsave).
+var x86Ops = map[x86asm.Op]string{
+	x86asm.ADDSUBPD: "sse3",
+	x86asm.ADDSUBPS: "sse3",
+	x86asm.HADDPD:   "sse3",
+	x86asm.HADDPS:   "sse3",
+	x86asm.HSUBPD:   "sse3",
+	x86asm.HSUBPS:   "sse3",
+	x86asm.LDDQU:    "sse3",
+	x86asm.MOVDDUP:  "sse3",
+	x86asm.MOVSHDUP: "sse3",
+	x86asm.MOVSLDUP: "sse3",
+
+	x86asm.PABSB:     "ssse3",
+	x86asm.PABSD:     "ssse3",
+	x86asm.PABSW:     "ssse3",
+	x86asm.PALIGNR:   "ssse3",
+	x86asm.PHADDD:    "ssse3",
+	x86asm.PHADDSW:   "ssse3",
+	x86asm.PHADDW:    "ssse3",
+	x86asm.PHSUBD:    "ssse3",
+	x86asm.PHSUBSW:   "ssse3",
+	x86asm.PHSUBW:    "ssse3",
+	x86asm.PMADDUBSW: "ssse3",
+	x86asm.PMULHRSW:  "ssse3",
+	x86asm.PSHUFB:    "ssse3",
+	x86asm.PSIGNB:    "ssse3",
+	x86asm.PSIGND:    "ssse3",
+	x86asm.PSIGNW:    "ssse3",
+
+	x86asm.BLENDPD:    "sse4_1",
+	x86asm.BLENDPS:    "sse4_1",
+	x86asm.BLENDVPD:   "sse4_1",
+	x86asm.BLENDVPS:   "sse4_1",
+	x86asm.DPPD:       "sse4_1",
+	x86asm.DPPS:       "sse4_1",
+	x86asm.EXTRACTPS:  "sse4_1",
+	x86asm.INSERTPS:   "sse4_1",
+	x86asm.MOVNTDQA:   "sse4_1",
+	x86asm.MPSADBW:    "sse4_1",
+	x86asm.PACKUSDW:   "sse4_1",
+	x86asm.PBLENDVB:   "sse4_1",
+	x86asm.PBLENDW:    "sse4_1",
+	x86asm.PCMPEQQ:    "sse4_1",
+	x86asm.PEXTRB:     "sse4_1",
+	x86asm.PEXTRD:     "sse4_1",
+	x86asm.PEXTRQ:     "sse4_1",
+	x86asm.PHMINPOSUW: "sse4_1",
+	x86asm.PINSRB:     "sse4_1",
+	x86asm.PINSRD:     "sse4_1",
+	x86asm.PINSRQ:     "sse4_1",
+	x86asm.PMAXSB:     "sse4_1",
+	x86asm.PMAXSD:     "sse4_1",
+	x86asm.PMAXUD:     "sse4_1",
+	x86asm.PMAXUW:     "sse4_1",
+	x86asm.PMINSB:     "sse4_1",
+	x86asm.PMINSD:     "sse4_1",
+	x86asm.PMINUD:     "sse4_1",
+	x86asm.PMINUW:     "sse4_1",
+	x86asm.PMOVSXBD:   "sse4_1",
+	x86asm.PMOVSXBQ:   "sse4_1",
+	x86asm.PMOVSXBW:   "sse4_1",
+	x86asm.PMOVSXDQ:   "sse4_1",
+	x86asm.PMOVSXWD:   "sse4_1",
+	x86asm.PMOVSXWQ:   "sse4_1",
+	x86asm.PMOVZXBD:   "sse4_1",
+	x86asm.PMOVZXBQ:   "sse4_1",
+	x86asm.PMOVZXBW:   "sse4_1",
+	x86asm.PMOVZXDQ:   "sse4_1",
+	x86asm.PMOVZXWD:   "sse4_1",
+	x86asm.PMOVZXWQ:   "sse4_1",
+	x86asm.PMULDQ:     "sse4_1",
+	x86asm.PMULLD:     "sse4_1",
+	x86asm.PTEST:      "sse4_1",
+	x86asm.ROUNDPD:    "sse4_1",
+	x86asm.ROUNDPS:    "sse4_1",
+	x86asm.ROUNDSD:    "sse4_1",
+	x86asm.ROUNDSS:    "sse4_1",
+
+	x86asm.CRC32:     "sse4_2",
+	x86asm.PCMPESTRI: "sse4_2",
+	x86asm.PCMPESTRM: "sse4_2",
+	x86asm.PCMPGTQ:   "sse4_2",
+	x86asm.PCMPISTRI: "sse4_2",
+	x86asm.PCMPISTRM: "sse4_2",
+
+	x86asm.POPCNT:     "popcnt",
+	x86asm.LZCNT:      "abm",
+	x86asm.MOVBE:      "movbe",
+	x86asm.CMPXCHG16B: "cx16",
+	x86asm.LAHF:       "lahf_lm",
+	x86asm.SAHF:       "lahf_lm",
+	x86asm.RDRAND:     "rdrand",
+	x86asm.PCLMULQDQ:  "pclmulqdq",
+
+	x86asm.AESDEC:          "aes",
+	x86asm.AESDECLAST:      "aes",
+	x86asm.AESENC:          "aes",
+	x86asm.AESENCLAST:      "aes",
+	x86asm.AESIMC:          "aes",
+	x86asm.AESKEYGENASSIST: "aes",
+}
+
+// x86Features decodes x86-64 instructions in order and records the features
+// they need. Padding and data in text sections don't decode, so we skip a
+// byte and try again. It returns true if the code runs cpuid, which means it
+// (e.g., OpenSSL) picks instructions for the processor at runtime.
+func x86Features(text []byte, features map[string]bool) bool {
+	detects := false
+	for i := 0; i < len(text); {
+		if n := vexFeatures(text[i:], features); n > 0 {
+			i += n
+			continue
+		}
+		inst, err := x86asm.Decode(text[i:], 64)
+		if err != nil || inst.Len == 0 {
+			i++
+			continue
+		}
+		if feature, ok := x86Ops[inst.Op]; ok {
+			features[feature] = true
+		}
+		if inst.Op == x86asm.CPUID {
+			detects = true
+		}
+		i += inst.Len
+	}
+	return detects
+}
+
+// vexFeatures decodes a VEX (AVX) or EVEX (AVX-512) encoded instruction,
+// which x86asm doesn't support, and returns its length or 0 if there isn't
+// one at the start of code. In 64-bit mode 0xc4, 0xc5 and 0x62 are always
+// these prefixes.
+func vexFeatures(code []byte, features map[string]bool) int {
+	if len(code) < 3 {
+		return 0
+	}
+	var length, opmap int
+	var wide bool
+	switch code[0] {
+	case 0xc5:
+		length, opmap, wide = 2, 1, code[1]&0x04 != 0
+	case 0xc4:
+		length, opmap, wide = 3, int(code[1]&0x1f), code[2]&0x04 != 0
+	case 0x62:
+		if len(code) < 4 || code[2]&0x04 == 0 {
+			return 0
+		}
+		length, opmap = 4, int(code[1]&0x07)
+	default:
+		return 0
+	}
+	if opmap < 1 || opmap > 3 || len(code) <= length {
+		return 0
+	}
+	opcode := code[length]
+	length++
+
+	// vzeroupper and vzeroall are the only ones without a ModRM byte
+	if opmap == 1 && opcode == 0x77 {
+		features["avx"] = true
+		return length
+	}
+	n := modrmLength(code[length:])
+	if n == 0 {
+		return 0
+	}
+	length += n
+	if opmap == 3 || (opmap == 1 && (opcode >= 0x70 && opcode <= 0x73 || opcode == 0xc2 || opcode >= 0xc4 && opcode <= 0xc6)) {
+		length++
+	}
+	if length > len(code) {
+		return 0
+	}
+
+	if code[0] == 0x62 {
+		features["avx512f"] = true
+		return length
+	}
+	features["avx"] = true
+	if feature := vexFeature(opmap, opcode, wide); feature != "" {
+		features[feature] = true
+	}
+	return length
+}
+
+// vexFeature classifies VEX instructions beyond AVX. 256-bit integer
+// instructions need AVX2, AVX only has 256-bit floating point.
+func vexFeature(opmap int, opcode byte, wide bool) string {
+	switch opmap {
+	case 1:
+		integer := opcode >= 0x60 && opcode <= 0x6d || opcode >= 0x70 && opcode <= 0x76 ||
+			opcode >= 0xd1 && opcode <= 0xd5 || opcode >= 0xd7 && opcode <= 0xe5 ||
+			opcode >= 0xe8 && opcode <= 0xef || opcode >= 0xf1 && opcode <= 0xfe
+		if wide && integer {
+			return "avx2"
+		}
+	case 2:
+		switch {
+		case opcode >= 0x96 && opcode <= 0x9f, opcode >= 0xa6 && opcode <= 0xaf, opcode >= 0xb6 && opcode <= 0xbf:
+			return "fma"
+		case opcode == 0xf2 || opcode == 0xf3:
+			return "bmi1"
+		case opcode == 0xf5 || opcode == 0xf6 || opcode == 0xf7:
+			// bextr (bmi1) shares 0xf7 with the bmi2 shifts, call it bmi2
+			return "bmi2"
+		case opcode == 0x13:
+			return "f16c"
+		case opcode >= 0xdb && opcode <= 0xdf:
+			return "aes"
+		case opcode == 0x16 || opcode == 0x36 || opcode >= 0x45 && opcode <= 0x47 ||
+			opcode >= 0x58 && opcode <= 0x5a || opcode == 0x78 || opcode == 0x79 ||
+			opcode == 0x8c || opcode == 0x8e || opcode >= 0x90 && opcode <= 0x93:
+			return "avx2"
+		case wide && !(opcode >= 0x0c && opcode <= 0x0f || opcode >= 0x18 && opcode <= 0x1a || opcode >= 0x2c && opcode <= 0x2f):
+			return "avx2"
+		}
+	case 3:
+		switch {
+		case opcode == 0x1d:
+			return "f16c"
+		case opcode == 0xf0:
+			return "bmi2"
+		case opcode == 0x44:
+			return "pclmulqdq"
+		case opcode == 0xdf:
+			return "aes"
+		case opcode <= 0x02 || opcode == 0x38 || opcode == 0x39 || opcode == 0x46:
+			return "avx2"
+		case wide && (opcode == 0x0e || opcode == 0x0f || opcode == 0x42 || opcode == 0x4c):
+			return "avx2"
+		}
+	}
+	return ""
+}
+
+// modrmLength returns the length of a ModRM byte with its SIB byte and
+// displacement, or 0 if the code is too short
+func modrmLength(code []byte) int {
+	if len(code) == 0 {
+		return 0
+	}
+	mod, rm := code[0]>>6, code[0]&0x07
+	length := 1
+	if mod != 3 && rm == 4 {
+		if len(code) < 2 {
+			return 0
+		}
+		length++
+		if mod == 0 && code[1]&0x07 == 5 {
+			length += 4
+		}
+	}
+	switch {
+	case mod == 0 && rm == 5:
+		length += 4
+	case mod == 1:
+		length++
+	case mod == 2:
+		length += 4
+	}
+	if length > len(code) {
+		return 0
+	}
+	return length
+}
@@ -0,0 +1,51 @@
+package binaries
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestX86Features(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     []byte
+		features []string
+		detects  bool
+	}{
+		{name: "baseline sse2 paddd", code: []byte{0x66, 0x0f, 0xfe, 0xc1}, features: []string{}},
+		{name: "popcnt", code: []byte{0xf3, 0x0f, 0xb8, 0xc1}, features: []string{"popcnt"}},
+		{name: "pshufb", code: []byte{0x66, 0x0f, 0x38, 0x00, 0xc1}, features: []string{"ssse3"}},
+		{name: "pmulld", code: []byte{0x66, 0x0f, 0x38, 0x40, 0xc1}, features: []string{"sse4_1"}},
+		{name: "crc32", code: []byte{0xf2, 0x0f, 0x38, 0xf0, 0xc1}, features: []string{"sse4_2"}},
+		{name: "aesenc", code: []byte{0x66, 0x0f, 0x38, 0xdc, 0xc1}, features: []string{"aes"}},
+		{name: "cpuid", code: []byte{0x0f, 0xa2}, features: []string{}, detects: true},
+
+		// VEX with two and three byte prefixes
+		{name: "vaddps ymm", code: []byte{0xc5, 0xf4, 0x58, 0xc2}, features: []string{"avx"}},
+		{name: "vpaddd xmm", code: []byte{0xc5, 0xf1, 0xfe, 0xc2}, features: []string{"avx"}},
+		{name: "vpaddd ymm", code: []byte{0xc5, 0xf5, 0xfe, 0xc2}, features: []string{"avx", "avx2"}},
+		{name: "vzeroupper", code: []byte{0xc5, 0xf8, 0x77}, features: []string{"avx"}},
+		{name: "vfmadd231ps", code: []byte{0xc4, 0xe2, 0x75, 0xb8, 0xc2}, features: []string{"avx", "fma"}},
+		{name: "andn", code: []byte{0xc4, 0xe2, 0x70, 0xf2, 0xc2}, features: []string{"avx", "bmi1"}},
+		{name: "shlx", code: []byte{0xc4, 0xe2, 0x71, 0xf7, 0xc2}, features: []string{"avx", "bmi2"}},
+		{name: "vpermq with an immediate", code: []byte{0xc4, 0xe3, 0xfd, 0x00, 0xc1, 0x4e}, features: []string{"avx", "avx2"}},
+		{name: "vcvtph2ps", code: []byte{0xc4, 0xe2, 0x79, 0x13, 0xc1}, features: []string{"avx", "f16c"}},
+		{name: "vaesenc", code: []byte{0xc4, 0xe2, 0x71, 0xdc, 0xc2}, features: []string{"aes", "avx"}},
+		{name: "vpclmulqdq", code: []byte{0xc4, 0xe3, 0x71, 0x44, 0xc2, 0x00}, features: []string{"avx", "pclmulqdq"}},
+
+		// EVEX
+		{name: "vaddps zmm", code: []byte{0x62, 0xf1, 0x74, 0x48, 0x58, 0xc2}, features: []string{"avx512f"}},
+
+		// The length of a VEX instruction with SIB and displacement, so the
+		// next one decodes
+		{name: "vmovdqu from memory then popcnt", code: []byte{0xc5, 0xfe, 0x6f, 0x44, 0x24, 0x08, 0xf3, 0x0f, 0xb8, 0xc1}, features: []string{"avx", "popcnt"}},
+		{name: "padding and a truncated prefix", code: []byte{0x00, 0x00, 0xcc, 0xcc, 0x90, 0xc4, 0xe2}, features: []string{}},
+	}
+	for _, test := range tests {
+		features := map[string]bool{}
+		detects := x86Features(test.code, features)
+		if got := sortedKeys(features); !reflect.DeepEqual(got, test.features) || detects != test.detects {
+			t.Errorf("%s: expected %v (detects %v), got %v (%v)", test.name, test.features, test.detects, got, detects)
+		}
+	}
+}
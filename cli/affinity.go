@@ -0,0 +1,106 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/DataDrake/cli-ng/v2/cmd"
+	"github.com/vsoch/containerspec/compat"
+	"github.com/vsoch/containerspec/k8s"
+	"gopkg.in/yaml.v3"
+)
+
+// Args and flags for affinity
+type AffinityArgs struct {
+	Image string `desc:"Image, root file system, or labels json from labels generate --format json"`
+}
+
+type AffinityFlags struct {
+	Format   string `long:"format" desc:"Output format, yaml (default) or json"`
+	Selector bool   `long:"selector" desc:"Write a nodeSelector of equal labels instead of node affinity"`
+	Platform string `long:"platform" desc:"Platform to select for an image, os/arch[/variant]"`
+	Ref      string `long:"ref" desc:"Reference (e.g., tag) to select when there is more than one image"`
+}
+
+// Affinity generates the node affinity of an image
+var Affinity = cmd.Sub{
+	Name:  "affinity",
+	Alias: "aff",
+	Short: "Generate Kubernetes node affinity for the nodes that can run an image.",
+	Flags: &AffinityFlags{},
+	Args:  &AffinityArgs{},
+	Run:   RunAffinity,
+}
+
+func init() {
+	cmd.Register(&Affinity)
+}
+
+// RunAffinity prints the node affinity (or nodeSelector) of an image, to
+// patch into the pod spec of a manifest. It selects on the labels of host
+// --format k8s-labels, and notes what a nodeSelector can't select.
+func RunAffinity(r *cmd.Root, c *cmd.Sub) {
+	args := c.Args.(*AffinityArgs)
+	flags := c.Flags.(*AffinityFlags)
+
+	container, err := readAffinityContainer(args.Image, flags.Ref, flags.Platform)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := k8s.CheckArch(container); err != nil {
+		log.Fatalf("%s: %s", args.Image, err)
+	}
+
+	var snippet interface{}
+	notes := []string{}
+	if flags.Selector {
+		selector, selectorNotes := k8s.SelectorFor(container)
+		snippet = map[string]map[string]string{"nodeSelector": selector}
+		notes = selectorNotes
+	} else {
+		snippet = map[string]*k8s.Affinity{"affinity": k8s.AffinityFor(container)}
+	}
+
+	switch flags.Format {
+	case "", "yaml":
+		for _, note := range notes {
+			fmt.Printf("# %s\n", note)
+		}
+		encoder := yaml.NewEncoder(os.Stdout)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(snippet); err != nil {
+			log.Fatal(err)
+		}
+	case "json":
+		for _, note := range notes {
+			fmt.Fprintln(os.Stderr, note)
+		}
+		content, err := json.MarshalIndent(snippet, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(content))
+	default:
+		log.Fatalf("%s is not a known format, choices are yaml or json", flags.Format)
+	}
+}
+
+// readAffinityContainer reads what a container needs from a labels json file,
+// or from an image or root file system
+func readAffinityContainer(path, ref, platform string) (*compat.Container, error) {
+	if !strings.HasSuffix(path, ".json") {
+		return readContainer(path, ref, platform)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	if err := json.Unmarshal(content, &values); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return compat.FromLabels(values), nil
+}
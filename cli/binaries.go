@@ -0,0 +1,60 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/DataDrake/cli-ng/v2/cmd"
+	"github.com/vsoch/containerspec/binaries"
+)
+
+// Args and flags for binaries
+type BinariesArgs struct {
+	Rootfs string `desc:"Path to an unpacked container root file system, an image, or a squashfs file"`
+}
+
+type BinariesFlags struct {
+	Files    bool   `long:"files" desc:"Include the analysis of each ELF file"`
+	Quick    bool   `long:"quick" desc:"Only read ISA level notes and glibc-hwcaps, without disassembling"`
+	Platform string `long:"platform" desc:"Platform to select for an image, os/arch[/variant] (defaults to the host)"`
+	Ref      string `long:"ref" desc:"Reference (e.g., tag) to select when there is more than one image"`
+}
+
+// Binaries analyzes the ELF files of a container
+var Binaries = cmd.Sub{
+	Name:  "binaries",
+	Alias: "b",
+	Short: "Find the microarchitecture the binaries of a container need.",
+	Flags: &BinariesFlags{},
+	Args:  &BinariesArgs{},
+	Run:   RunBinaries,
+}
+
+func init() {
+	cmd.Register(&Binaries)
+}
+
+// RunBinaries prints the binary analysis as json
+func RunBinaries(r *cmd.Root, c *cmd.Sub) {
+	args := c.Args.(*BinariesArgs)
+	flags := c.Flags.(*BinariesFlags)
+
+	fsys, closer, err := openRootfs(args.Rootfs, flags.Ref, flags.Platform)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer closer.Close()
+	analysis, err := binaries.Analyze(fsys, binaries.Options{Quick: flags.Quick})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !flags.Files {
+		analysis.Files = nil
+	}
+	content, err := json.MarshalIndent(analysis, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(content))
+}
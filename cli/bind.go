@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/DataDrake/cli-ng/v2/cmd"
+	"github.com/vsoch/containerspec/libs"
+)
+
+// Args and flags for bind
+type BindArgs struct {
+	Rootfs    string   `desc:"Path to an unpacked container root file system, an image, or a squashfs file"`
+	Libraries []string `desc:"Host libraries to bind, as source[:target] (the target defaults to the source)"`
+}
+
+type BindFlags struct {
+	Format      string `long:"format" desc:"Output format, text (default) or json"`
+	LibraryPath string `long:"library-path" desc:"Colon separated directories searched like LD_LIBRARY_PATH (e.g., /.singularity.d/libs)"`
+	Platform    string `long:"platform" desc:"Platform to select for an image, os/arch[/variant] (defaults to the host)"`
+	Ref         string `long:"ref" desc:"Reference (e.g., tag) to select when there is more than one image"`
+}
+
+// BindCmd checks if host libraries will work when bound into a container
+var BindCmd = cmd.Sub{
+	Name:  "bind",
+	Short: "Check if host libraries will work when bind mounted into a container.",
+	Flags: &BindFlags{},
+	Args:  &BindArgs{},
+	Run:   RunBind,
+}
+
+func init() {
+	cmd.Register(&BindCmd)
+}
+
+// RunBind simulates the bind mounts and exits with an error on problems
+func RunBind(r *cmd.Root, c *cmd.Sub) {
+	args := c.Args.(*BindArgs)
+	flags := c.Flags.(*BindFlags)
+
+	binds := []libs.Bind{}
+	for _, library := range args.Libraries {
+		binds = append(binds, libs.ParseBind(library))
+	}
+	libraryPath := []string{}
+	if flags.LibraryPath != "" {
+		libraryPath = strings.Split(flags.LibraryPath, ":")
+	}
+	impact, err := bindImpact(args.Rootfs, flags.Ref, flags.Platform, binds, libraryPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch flags.Format {
+	case "", "text":
+		printImpact(impact)
+	case "json":
+		content, err := json.MarshalIndent(impact, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(content))
+	default:
+		log.Fatalf("%s is not a known format, choices are text or json", flags.Format)
+	}
+	if !impact.OK() {
+		os.Exit(1)
+	}
+}
+
+// bindImpact opens a root file system and simulates the binds, closing it
+// before we exit
+func bindImpact(path, ref, platform string, binds []libs.Bind, libraryPath []string) (*libs.Impact, error) {
+	fsys, closer, err := openRootfs(path, ref, platform)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	return libs.BindImpact(fsys, binds, libraryPath)
+}
+
+// printImpact prints one line per problem
+func printImpact(impact *libs.Impact) {
+	fmt.Printf("%d host libraries bound, %d container files load them\n", len(impact.Binds), len(impact.Affected))
+	for _, missing := range impact.Missing {
+		fmt.Printf("missing: %s, needed by /%s\n", missing.Name, strings.Join(missing.NeededBy, ", /"))
+		if missing.Uncached != "" {
+			fmt.Printf("  /%s isn't in ld.so.cache, run ldconfig\n", missing.Uncached)
+		}
+	}
+	for _, conflict := range impact.Conflicts {
+		fmt.Printf("conflict: /%s needs %s, which /%s doesn't define\n", conflict.Object, conflict.Version, conflict.Library)
+	}
+	if impact.Glibc != nil && !impact.Glibc.OK {
+		fmt.Printf("glibc: host libraries need %s, the container has %s\n", impact.Glibc.Required, impact.Glibc.Container)
+	}
+	if impact.OK() {
+		fmt.Println("ok")
+	}
+}
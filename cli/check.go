@@ -0,0 +1,166 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/DataDrake/cli-ng/v2/cmd"
+	"github.com/vsoch/containerspec/compat"
+	"github.com/vsoch/containerspec/labels"
+	"github.com/vsoch/containerspec/spec"
+)
+
+// Args and flags for check
+type CheckArgs struct {
+	Image string `desc:"Path to an image, an unpacked container root file system, or a squashfs file"`
+}
+
+type CheckFlags struct {
+	Format   string `long:"format" desc:"Output format, text (default) or json"`
+	Host     string `long:"host" desc:"Host spec json, from the host command (defaults to this host)"`
+	Platform string `long:"platform" desc:"Platform to select for an image, os/arch[/variant] (defaults to the host)"`
+	Policy   string `long:"policy" desc:"Policy file (yaml or json) with rules to check in addition to the built-in ones"`
+	Ref      string `long:"ref" desc:"Reference (e.g., tag) to select when there is more than one image"`
+}
+
+// Check determines if a container can run, and run optimally, on a host
+var Check = cmd.Sub{
+	Name:  "check",
+	Alias: "c",
+	Short: "Check if a container is compatible with a host.",
+	Flags: &CheckFlags{},
+	Args:  &CheckArgs{},
+	Run:   RunCheck,
+}
+
+func init() {
+	cmd.Register(&Check)
+}
+
+// RunCheck prints the verdict of each rule, and exits with an error if the
+// container is incompatible
+func RunCheck(r *cmd.Root, c *cmd.Sub) {
+	args := c.Args.(*CheckArgs)
+	flags := c.Flags.(*CheckFlags)
+
+	host, err := readHost(flags.Host)
+	if err != nil {
+		log.Fatal(err)
+	}
+	registry := compat.DefaultRegistry()
+	if flags.Policy != "" {
+		policy, err := compat.ReadPolicy(flags.Policy)
+		if err != nil {
+			log.Fatal(err)
+		}
+		registry = policy.Registry()
+	}
+	container, err := readContainer(args.Image, flags.Ref, flags.Platform)
+	if err != nil {
+		log.Fatal(err)
+	}
+	report := registry.Check(container, host)
+
+	switch flags.Format {
+	case "", "text":
+		fmt.Println(report.Verdict)
+		width := 9
+		for _, result := range report.Results {
+			width = max(width, len(result.Rule))
+		}
+		for _, result := range report.Results {
+			reason := result.Reason
+			if result.Code != "" {
+				reason += fmt.Sprintf(" [%s]", result.Code)
+			}
+			fmt.Printf("  %-*s %-13s %s\n", width, result.Rule, result.Verdict, reason)
+			for _, hint := range result.Hints {
+				fmt.Printf("  %-*s %-13s - %s\n", width, "", "", hint)
+			}
+		}
+	case "json":
+		content, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(content))
+	default:
+		log.Fatalf("%s is not a known format, choices are text or json", flags.Format)
+	}
+	if report.Verdict == compat.Incompatible {
+		os.Exit(1)
+	}
+}
+
+// readHost reads a host spec saved from the host command, or detects this
+// host if there is no path
+func readHost(path string) (*spec.Host, error) {
+	if path == "" {
+		return spec.DetectHost(), nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	host, err := spec.ParseHost(content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return host, nil
+}
+
+// readContainer reads what a container needs from the supercontainers labels
+// of an image, or detects it in the root file system if there are none. Other
+// labels are kept for policies that use them.
+func readContainer(path, ref, platform string) (*compat.Container, error) {
+	values := map[string]string{}
+	others := map[string]string{}
+	arch := ""
+	if img, err := openImage(path, ref, platform); err == nil {
+		config := img.Config()
+		for _, key := range labels.Keys() {
+			if value := config.Label(key); value != "" {
+				values[key] = value
+			}
+		}
+		for key, value := range config.Labels {
+			others[key] = value
+		}
+		arch = spec.GoarchFamily(config.Architecture)
+	}
+	if len(values) > 0 {
+		container := compat.FromLabels(values)
+		if container.Arch == "" {
+			container.Arch = arch
+		}
+		addLabels(container, others)
+		return container, nil
+	}
+
+	fsys, closer, err := openRootfs(path, ref, platform)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	info, err := spec.DetectRootfs(fsys)
+	if err != nil {
+		return nil, err
+	}
+	container := compat.FromRootfs(info)
+	addLabels(container, others)
+	return container, nil
+}
+
+// addLabels adds labels a container doesn't have already
+func addLabels(container *compat.Container, values map[string]string) {
+	if container.Labels == nil {
+		container.Labels = map[string]string{}
+	}
+	for key, value := range values {
+		if _, ok := container.Labels[key]; !ok {
+			container.Labels[key] = value
+		}
+	}
+}
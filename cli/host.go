@@ -1,14 +1,22 @@
 package cli
 
 import (
+	"encoding/json"
 	"fmt"
+	"log"
+
 	"github.com/DataDrake/cli-ng/v2/cmd"
-	"github.com/vsoch/containerspec/spec"
+	"github.com/vsoch/containerspec/k8s"
 )
 
 // Args and flags for generate
 type HostArgs struct{}
-type HostFlags struct{}
+type HostFlags struct {
+	Format  string `long:"format" desc:"Output format, json (default) or k8s-labels"`
+	Spec    string `long:"spec" desc:"Host spec json to convert, instead of detecting this host"`
+	NFD     bool   `long:"nfd" desc:"Write the labels to a Node Feature Discovery feature file"`
+	NFDFile string `long:"nfd-file" desc:"Path of the feature file (defaults to /etc/kubernetes/node-feature-discovery/features.d/containerspec)"`
+}
 
 // Host dumps out information about the host
 var Host = cmd.Sub{
@@ -24,13 +32,36 @@ func init() {
 	cmd.Register(&Host)
 }
 
-// RunHost detects the host of the machine
+// RunHost detects the host of the machine, as json that check can read, or
+// as Kubernetes node labels
 func RunHost(r *cmd.Root, c *cmd.Sub) {
+	flags := c.Flags.(*HostFlags)
 
-	// Create and load a new config
-	// conf := config.Load(args.Chefyaml[0])
+	host, err := readHost(flags.Spec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if flags.NFD || flags.NFDFile != "" {
+		path := flags.NFDFile
+		if path == "" {
+			path = k8s.FeatureFile
+		}
+		if err := k8s.WriteFeatureFile(path, k8s.NodeLabels(host)); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-	// Detect the host
-	arch := spec.Detect()
-	fmt.Println(arch)
+	switch flags.Format {
+	case "", "json":
+		content, err := json.MarshalIndent(host, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(content))
+	case "k8s-labels":
+		fmt.Print(k8s.FormatLabels(k8s.NodeLabels(host)))
+	default:
+		log.Fatalf("%s is not a known format, choices are json or k8s-labels", flags.Format)
+	}
 }
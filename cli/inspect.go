@@ -0,0 +1,167 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+
+	"github.com/DataDrake/cli-ng/v2/cmd"
+	"github.com/vsoch/containerspec/image"
+	"github.com/vsoch/containerspec/rootfs"
+	"github.com/vsoch/containerspec/squashfs"
+)
+
+// Args and flags for inspect
+type InspectArgs struct {
+	Image string `desc:"Path to an OCI image layout, docker save archive or SIF file"`
+}
+
+type InspectFlags struct {
+	Platform string `long:"platform" desc:"Platform to select, os/arch[/variant] (defaults to the host)"`
+	Ref      string `long:"ref" desc:"Reference (e.g., tag) to select when there is more than one image"`
+}
+
+// Inspect dumps out the configuration of an image on disk
+var Inspect = cmd.Sub{
+	Name:  "inspect",
+	Alias: "i",
+	Short: "Dump out the labels and configuration of an image on disk.",
+	Flags: &InspectFlags{},
+	Args:  &InspectArgs{},
+	Run:   RunInspect,
+}
+
+func init() {
+	cmd.Register(&Inspect)
+}
+
+// RunInspect prints the image configuration as json
+func RunInspect(r *cmd.Root, c *cmd.Sub) {
+	args := c.Args.(*InspectArgs)
+	flags := c.Flags.(*InspectFlags)
+
+	img, err := openImage(args.Image, flags.Ref, flags.Platform)
+	if err != nil {
+		log.Fatal(err)
+	}
+	content, err := json.MarshalIndent(img.Config(), "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(content))
+}
+
+// openImage opens an image for a platform string, defaulting to the host
+func openImage(path, ref, platform string) (image.Image, error) {
+	selected := image.DefaultPlatform()
+	if platform != "" {
+		var err error
+		if selected, err = image.ParsePlatform(platform); err != nil {
+			return nil, err
+		}
+	}
+	img, err := image.Open(path, ref, selected)
+	if err != nil {
+		return nil, err
+	}
+	if sif, ok := img.(*image.SIF); ok {
+		addSIFLabels(sif)
+	}
+	return img, nil
+}
+
+// addSIFLabels adds the labels in the root file system of a SIF file, where
+// newer versions of Singularity and Apptainer keep them. An image without
+// a squashfs partition or the file just has no more labels.
+func addSIFLabels(sif *image.SIF) {
+	partition, err := sif.Partition()
+	if err != nil || partition.FsType != image.SIFFsSquash {
+		return
+	}
+	reader, closer, err := sif.OpenObject(partition)
+	if err != nil {
+		return
+	}
+	defer closer.Close()
+	fsys, err := squashfs.Open(reader)
+	if err != nil {
+		return
+	}
+	content, err := fs.ReadFile(fsys, image.SIFLabelsFile)
+	if err != nil {
+		return
+	}
+	if labels, err := image.ParseSIFLabels(content); err == nil {
+		sif.AddLabels(labels)
+	}
+}
+
+// openRootfs returns the root file system of an unpacked directory or an
+// image, and what to close when it is no longer used
+func openRootfs(path, ref, platform string) (fs.FS, io.Closer, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.IsDir() && !rootfs.Exists(rootfs.Dir(path), "oci-layout") {
+		return rootfs.Dir(path), noClose{}, nil
+	}
+	if !info.IsDir() {
+		if fsys, closer, err := openSquashfs(path); err == nil {
+			return fsys, closer, nil
+		}
+	}
+	img, err := openImage(path, ref, platform)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The root file system of a SIF file is its primary squashfs partition
+	if sif, ok := img.(*image.SIF); ok {
+		partition, err := sif.Partition()
+		if err != nil {
+			return nil, nil, err
+		}
+		if partition.FsType != image.SIFFsSquash {
+			return nil, nil, fmt.Errorf("%s: only squashfs partitions are supported", path)
+		}
+		reader, closer, err := sif.OpenObject(partition)
+		if err != nil {
+			return nil, nil, err
+		}
+		fsys, err := squashfs.Open(reader)
+		if err != nil {
+			closer.Close()
+			return nil, nil, err
+		}
+		return fsys, closer, nil
+	}
+	layers, err := rootfs.NewLayerFS(img.Layers())
+	if err != nil {
+		return nil, nil, err
+	}
+	return layers, layers, nil
+}
+
+// openSquashfs opens a squashfs file, e.g., one built with mksquashfs. The
+// file stays open for the file system, until it is closed.
+func openSquashfs(path string) (fs.FS, io.Closer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	fsys, err := squashfs.Open(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return fsys, file, nil
+}
+
+// noClose is the closer of a file system that has nothing to release
+type noClose struct{}
+
+func (noClose) Close() error { return nil }
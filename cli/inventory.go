@@ -0,0 +1,169 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/DataDrake/cli-ng/v2/cmd"
+	"github.com/vsoch/containerspec/compat"
+	"github.com/vsoch/containerspec/inventory"
+)
+
+// Args and flags for inventory
+type InventoryArgs struct {
+	Dir string `desc:"Directory of host spec json files, one per node, from the host command"`
+}
+
+type InventoryFlags struct {
+	Format    string  `long:"format" desc:"Output format, text (default) or json"`
+	Image     string  `long:"image" desc:"Image (or root file system) to find the nodes that can run"`
+	Coverage  float64 `long:"coverage" desc:"Find the most specific target that runs on at least this percent of nodes"`
+	Recommend bool    `long:"recommend" desc:"Recommend the fewest targets to build so every node runs one"`
+	MaxLoss   float64 `long:"max-loss" desc:"With --recommend, the percent of its features a node may lose (default 0, optimal builds)"`
+	Images    int     `long:"images" desc:"With --recommend, build at most this many images with the least loss instead"`
+	Platform  string  `long:"platform" desc:"Platform to select for an image, os/arch[/variant]"`
+	Policy    string  `long:"policy" desc:"Policy file (yaml or json) with rules to check in addition to the built-in ones"`
+	Ref       string  `long:"ref" desc:"Reference (e.g., tag) to select when there is more than one image"`
+}
+
+// Inventory groups the nodes of a cluster, and finds where images run
+var Inventory = cmd.Sub{
+	Name:  "inventory",
+	Alias: "inv",
+	Short: "Group the nodes of a cluster, and find the nodes or target for an image.",
+	Flags: &InventoryFlags{},
+	Args:  &InventoryArgs{},
+	Run:   RunInventory,
+}
+
+func init() {
+	cmd.Register(&Inventory)
+}
+
+// inventoryResult is what the inventory command found, for json
+type inventoryResult struct {
+	Nodes     int                   `json:"nodes"`
+	Classes   []*inventory.Class    `json:"classes"`
+	Placement []inventory.Placement `json:"placement,omitempty"`
+	Covering  *inventory.Coverage   `json:"covering,omitempty"`
+	Coverage  []inventory.Coverage  `json:"coverage,omitempty"`
+
+	Recommendation *inventory.Recommendation `json:"recommendation,omitempty"`
+}
+
+// RunInventory prints the classes of nodes, the nodes that can run an image,
+// the target that covers a percent of the cluster, or the targets to build.
+// It exits with an error if no node runs the image, or no target covers
+// enough nodes.
+func RunInventory(r *cmd.Root, c *cmd.Sub) {
+	args := c.Args.(*InventoryArgs)
+	flags := c.Flags.(*InventoryFlags)
+
+	cluster, err := inventory.Read(args.Dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if flags.Policy != "" {
+		policy, err := compat.ReadPolicy(flags.Policy)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cluster.Registry = policy.Registry()
+	}
+
+	result := inventoryResult{Nodes: len(cluster.Nodes), Classes: cluster.Classes}
+	failed := false
+	if flags.Image != "" {
+		container, err := readContainer(flags.Image, flags.Ref, flags.Platform)
+		if err != nil {
+			log.Fatalf("%s: %s", flags.Image, err)
+		}
+		result.Placement = cluster.Place(container)
+		failed = len(result.Placement) == 1 && result.Placement[0].Verdict == compat.Incompatible
+	}
+	if flags.Coverage > 0 {
+		if flags.Coverage > 100 {
+			log.Fatalf("%g is not a percent of nodes, from 0 to 100", flags.Coverage)
+		}
+		result.Coverage = cluster.Coverage()
+		if covering, ok := cluster.Covering(flags.Coverage); ok {
+			result.Covering = &covering
+		} else {
+			failed = true
+		}
+	}
+
+	if flags.Recommend {
+		if flags.MaxLoss < 0 || flags.MaxLoss > 100 {
+			log.Fatalf("%g is not a percent of features, from 0 to 100", flags.MaxLoss)
+		}
+		recommendation, err := cluster.Recommend(inventory.Options{MaxLoss: flags.MaxLoss / 100, Images: flags.Images})
+		if err != nil {
+			log.Fatal(err)
+		}
+		result.Recommendation = recommendation
+	}
+
+	switch flags.Format {
+	case "", "text":
+		printInventory(&result, flags.Coverage)
+	case "json":
+		content, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(content))
+	default:
+		log.Fatalf("%s is not a known format, choices are text or json", flags.Format)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// printInventory prints the classes, then where the image runs, the target
+// that covers the cluster and the targets to build if we looked for them
+func printInventory(result *inventoryResult, percent float64) {
+	fmt.Printf("%d nodes in %d classes\n", result.Nodes, len(result.Classes))
+	for _, class := range result.Classes {
+		fmt.Printf("  %4d  %-40s %s\n", len(class.Nodes), class, strings.Join(class.Nodes, ", "))
+	}
+
+	if result.Placement != nil {
+		fmt.Println("\nnodes that run the image")
+		for _, placement := range result.Placement {
+			fmt.Printf("  %-13s %4d  %s\n", placement.Verdict, len(placement.Nodes), strings.Join(placement.Nodes, ", "))
+			for _, reason := range placement.Reasons {
+				fmt.Printf("  %-13s       - %s\n", "", reason)
+			}
+		}
+	}
+
+	if percent > 0 {
+		fmt.Println()
+		if result.Covering != nil {
+			fmt.Printf("%s is the most specific target that runs on at least %g%% of nodes (%.0f%%)\n", result.Covering.Target, percent, result.Covering.Percent)
+		} else {
+			fmt.Printf("no target runs on %g%% of nodes\n", percent)
+		}
+		for _, covered := range result.Coverage {
+			fmt.Printf("  %5.1f%%  %s\n", covered.Percent, covered.Target)
+		}
+	}
+
+	if recommendation := result.Recommendation; recommendation != nil {
+		fmt.Printf("\nbuild %d images, nodes lose %.1f%% of their features on average and at most %.1f%%\n", len(recommendation.Builds), 100*recommendation.Loss, 100*recommendation.MaxLoss)
+		for _, build := range recommendation.Builds {
+			fmt.Printf("  %-16s %5.1f%%  %s\n", build.Target, 100*build.Loss, strings.Join(build.Nodes, ", "))
+			for _, flag := range build.Flags {
+				fmt.Printf("  %-16s         %s%s: %s\n", "", flag.Compiler, flag.Requires, flag.Flags)
+			}
+		}
+		if len(recommendation.Unrecognized) > 0 {
+			fmt.Printf("no target for %s, the architecture isn't in the CPU database\n", strings.Join(recommendation.Unrecognized, ", "))
+		}
+	}
+}
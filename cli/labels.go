@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/DataDrake/cli-ng/v2/cmd"
+	"github.com/vsoch/containerspec/binaries"
+	"github.com/vsoch/containerspec/labels"
+	"github.com/vsoch/containerspec/spec"
+)
+
+// Args and flags for labels
+type LabelsArgs struct {
+	Action string `desc:"Action to take (generate)"`
+	Rootfs string `desc:"Path to an unpacked container root file system, an image, or a squashfs file"`
+}
+
+type LabelsFlags struct {
+	Analyze  bool   `long:"analyze" desc:"Set the target from the instructions the binaries use (slower)"`
+	Format   string `long:"format" desc:"Output format, label (default) or json annotations"`
+	Platform string `long:"platform" desc:"Platform to select for an image, os/arch[/variant] (defaults to the host)"`
+	Ref      string `long:"ref" desc:"Reference (e.g., tag) to select when there is more than one image"`
+}
+
+// Labels generates supercontainers labels for a container
+var Labels = cmd.Sub{
+	Name:  "labels",
+	Alias: "l",
+	Short: "Generate supercontainers labels from a container root file system.",
+	Flags: &LabelsFlags{},
+	Args:  &LabelsArgs{},
+	Run:   RunLabels,
+}
+
+func init() {
+	cmd.Register(&Labels)
+}
+
+// RunLabels detects labels for a container root file system
+func RunLabels(r *cmd.Root, c *cmd.Sub) {
+	args := c.Args.(*LabelsArgs)
+	flags := c.Flags.(*LabelsFlags)
+
+	if args.Action != "generate" {
+		log.Fatalf("%s is not a known action, choices are generate", args.Action)
+	}
+
+	fsys, closer, err := openRootfs(args.Rootfs, flags.Ref, flags.Platform)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer closer.Close()
+	info, err := spec.DetectRootfs(fsys)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if flags.Analyze {
+		analysis, err := binaries.Analyze(fsys, binaries.Options{})
+		if err != nil {
+			log.Fatal(err)
+		}
+		info.Target = analysis.Target
+	}
+	generated := labels.Generate(info)
+
+	switch flags.Format {
+	case "", "label":
+		fmt.Println(labels.Dockerfile(generated))
+	case "json":
+		content, err := labels.Annotations(generated)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(content)
+	default:
+		log.Fatalf("%s is not a known format, choices are label or json", flags.Format)
+	}
+}
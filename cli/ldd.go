@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/DataDrake/cli-ng/v2/cmd"
+	"github.com/vsoch/containerspec/libs"
+)
+
+// Args and flags for ldd
+type LddArgs struct {
+	Rootfs string `desc:"Path to an unpacked container root file system, an image, or a squashfs file"`
+	Path   string `desc:"Executable or library in the root file system (e.g., /usr/bin/python3)"`
+}
+
+type LddFlags struct {
+	Format   string `long:"format" desc:"Output format, text (default, like ldd) or json"`
+	Platform string `long:"platform" desc:"Platform to select for an image, os/arch[/variant] (defaults to the host)"`
+	Ref      string `long:"ref" desc:"Reference (e.g., tag) to select when there is more than one image"`
+}
+
+// Ldd lists the shared libraries of a file in a container
+var Ldd = cmd.Sub{
+	Name:  "ldd",
+	Short: "List the shared libraries a file in a container loads, without running it.",
+	Flags: &LddFlags{},
+	Args:  &LddArgs{},
+	Run:   RunLdd,
+}
+
+func init() {
+	cmd.Register(&Ldd)
+}
+
+// RunLdd resolves the dependency graph of a file
+func RunLdd(r *cmd.Root, c *cmd.Sub) {
+	args := c.Args.(*LddArgs)
+	flags := c.Flags.(*LddFlags)
+
+	fsys, closer, err := openRootfs(args.Rootfs, flags.Ref, flags.Platform)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer closer.Close()
+	graph, err := libs.NewResolver(fsys).Graph(args.Path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch flags.Format {
+	case "", "text":
+		printGraph(graph)
+	case "json":
+		content, err := json.MarshalIndent(graph, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(content))
+	default:
+		log.Fatalf("%s is not a known format, choices are text or json", flags.Format)
+	}
+}
+
+// printGraph prints libraries in load order, as ldd does
+func printGraph(graph *libs.Graph) {
+	for _, object := range graph.Objects[1:] {
+		name := object.Soname
+		if name == "" {
+			name = object.Path
+		}
+		fmt.Printf("\t%s => /%s\n", name, object.Path)
+	}
+	for _, missing := range graph.Missing {
+		if missing.Uncached != "" {
+			fmt.Printf("\t%s => not found (/%s needs ldconfig)\n", missing.Name, missing.Uncached)
+			continue
+		}
+		fmt.Printf("\t%s => not found\n", missing.Name)
+	}
+}
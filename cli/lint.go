@@ -0,0 +1,95 @@
+package cli
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/DataDrake/cli-ng/v2/cmd"
+	"github.com/vsoch/containerspec/dockerfile"
+	"github.com/vsoch/containerspec/labels"
+	"github.com/vsoch/containerspec/lint"
+)
+
+// Args and flags for lint
+type LintArgs struct {
+	Paths []string `desc:"Dockerfiles, or directories to search for them"`
+}
+
+type LintFlags struct {
+	Format  string `long:"format" desc:"Output format, text (default), sarif or github"`
+	Require string `long:"require" desc:"Comma separated labels to require, defaults to the required supercontainers labels"`
+}
+
+// Lint checks Dockerfiles against the label policy
+var Lint = cmd.Sub{
+	Name:  "lint",
+	Short: "Check Dockerfiles for supercontainers labels and pinned base images.",
+	Flags: &LintFlags{},
+	Args:  &LintArgs{},
+	Run:   RunLint,
+}
+
+func init() {
+	cmd.Register(&Lint)
+}
+
+// RunLint lints each Dockerfile and exits with an error if a check fails
+func RunLint(r *cmd.Root, c *cmd.Sub) {
+	args := c.Args.(*LintArgs)
+	flags := c.Flags.(*LintFlags)
+
+	options := lint.Options{}
+	if flags.Require != "" {
+		options.Required = labels.Split(flags.Require)
+	}
+
+	findings := []lint.Finding{}
+	for _, path := range findDockerfiles(args.Paths) {
+		d, err := dockerfile.Load(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		findings = append(findings, lint.Lint(d, options)...)
+	}
+	if err := lint.Write(os.Stdout, flags.Format, findings); err != nil {
+		log.Fatal(err)
+	}
+	if lint.Failed(findings) {
+		os.Exit(1)
+	}
+}
+
+// findDockerfiles expands directories to the Dockerfiles inside them
+func findDockerfiles(paths []string) []string {
+	found := []string{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !info.IsDir() {
+			found = append(found, path)
+			continue
+		}
+		err = filepath.WalkDir(path, func(name string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() && d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			base := d.Name()
+			if !d.IsDir() && (base == "Dockerfile" || strings.HasPrefix(base, "Dockerfile.") || strings.HasSuffix(base, ".Dockerfile")) {
+				found = append(found, name)
+			}
+			return nil
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	return found
+}
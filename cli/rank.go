@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/DataDrake/cli-ng/v2/cmd"
+	"github.com/vsoch/containerspec/compat"
+)
+
+// Args and flags for rank
+type RankArgs struct {
+	Images []string `desc:"Images built for different targets, or their root file systems"`
+}
+
+type RankFlags struct {
+	Format   string `long:"format" desc:"Output format, text (default) or json"`
+	Host     string `long:"host" desc:"Host spec json, from the host command (defaults to this host)"`
+	Platform string `long:"platform" desc:"Platform to select for an image, os/arch[/variant] (defaults to the host)"`
+}
+
+// Rank orders image variants by how well they run on a host
+var Rank = cmd.Sub{
+	Name:  "rank",
+	Short: "Rank images built for different targets by how well they run on a host.",
+	Flags: &RankFlags{},
+	Args:  &RankArgs{},
+	Run:   RunRank,
+}
+
+func init() {
+	cmd.Register(&Rank)
+}
+
+// RunRank prints the variants from best to worst, the best first
+func RunRank(r *cmd.Root, c *cmd.Sub) {
+	args := c.Args.(*RankArgs)
+	flags := c.Flags.(*RankFlags)
+
+	host, err := readHost(flags.Host)
+	if err != nil {
+		log.Fatal(err)
+	}
+	variants := []compat.Variant{}
+	for _, path := range args.Images {
+		container, err := readContainer(path, "", flags.Platform)
+		if err != nil {
+			log.Fatalf("%s: %s", path, err)
+		}
+		variants = append(variants, compat.Variant{Name: path, Container: container})
+	}
+	ranked := compat.Rank(variants, host)
+
+	switch flags.Format {
+	case "", "text":
+		if len(ranked) > 0 && ranked[0].Verdict != compat.Incompatible {
+			fmt.Printf("best: %s (%s)\n", ranked[0].Variant, ranked[0].Reason)
+		} else {
+			fmt.Println("none of the images run on the host")
+		}
+		for _, variant := range ranked {
+			fmt.Printf("  %.2f  %-13s %-16s %s\n", variant.Score, variant.Verdict, variant.Target, variant.Variant)
+		}
+	case "json":
+		content, err := json.MarshalIndent(ranked, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(content))
+	default:
+		log.Fatalf("%s is not a known format, choices are text or json", flags.Format)
+	}
+}
@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/DataDrake/cli-ng/v2/cmd"
+	"github.com/vsoch/containerspec/compat"
+	"github.com/vsoch/containerspec/image"
+)
+
+// Args and flags for select
+type SelectArgs struct {
+	Layout string `desc:"Path to an OCI image layout with a multi-arch index"`
+}
+
+type SelectFlags struct {
+	Format string `long:"format" desc:"Output format, text (default) or json"`
+	Host   string `long:"host" desc:"Host spec json, from the host command (defaults to this host)"`
+	Ref    string `long:"ref" desc:"Reference (e.g., tag) to select when there is more than one image"`
+}
+
+// Select chooses the image of a multi-arch index to run on a host
+var Select = cmd.Sub{
+	Name:  "select",
+	Short: "Select the image of a multi-arch index built for the closest target to a host.",
+	Flags: &SelectFlags{},
+	Args:  &SelectArgs{},
+	Run:   RunSelect,
+}
+
+func init() {
+	cmd.Register(&Select)
+}
+
+// RunSelect prints the digest and platform of the selected image, and why
+func RunSelect(r *cmd.Root, c *cmd.Sub) {
+	args := c.Args.(*SelectArgs)
+	flags := c.Flags.(*SelectFlags)
+
+	host, err := readHost(flags.Host)
+	if err != nil {
+		log.Fatal(err)
+	}
+	manifests, err := image.Manifests(args.Layout, flags.Ref)
+	if err != nil {
+		log.Fatal(err)
+	}
+	selection, err := compat.SelectManifest(manifests, host)
+	if err != nil {
+		log.Fatalf("%s: %s", args.Layout, err)
+	}
+
+	switch flags.Format {
+	case "", "text":
+		platform := "unknown platform"
+		if selection.Descriptor.Platform != nil {
+			platform = selection.Descriptor.Platform.String()
+		}
+		fmt.Printf("%s %s %s\n", selection.Descriptor.Digest, platform, selection.Target)
+		fmt.Println(selection.Reason)
+	case "json":
+		content, err := json.MarshalIndent(selection, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(content))
+	default:
+		log.Fatalf("%s is not a known format, choices are text or json", flags.Format)
+	}
+}
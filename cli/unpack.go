@@ -0,0 +1,47 @@
+package cli
+
+import (
+	"log"
+
+	"github.com/DataDrake/cli-ng/v2/cmd"
+	"github.com/vsoch/containerspec/rootfs"
+)
+
+// Args and flags for unpack
+type UnpackArgs struct {
+	Image string `desc:"Path to an OCI image layout or docker save archive"`
+	Dest  string `desc:"Directory to extract the root file system to"`
+}
+
+type UnpackFlags struct {
+	Platform string `long:"platform" desc:"Platform to select, os/arch[/variant] (defaults to the host)"`
+	Ref      string `long:"ref" desc:"Reference (e.g., tag) to select when there is more than one image"`
+}
+
+// Unpack extracts the root file system of an image
+var Unpack = cmd.Sub{
+	Name:  "unpack",
+	Alias: "u",
+	Short: "Extract the root file system of an image, applying layers in order.",
+	Flags: &UnpackFlags{},
+	Args:  &UnpackArgs{},
+	Run:   RunUnpack,
+}
+
+func init() {
+	cmd.Register(&Unpack)
+}
+
+// RunUnpack applies the image layers to the destination directory
+func RunUnpack(r *cmd.Root, c *cmd.Sub) {
+	args := c.Args.(*UnpackArgs)
+	flags := c.Flags.(*UnpackFlags)
+
+	img, err := openImage(args.Image, flags.Ref, flags.Platform)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := rootfs.Unpack(img.Layers(), args.Dest); err != nil {
+		log.Fatal(err)
+	}
+}
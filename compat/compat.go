@@ -0,0 +1,337 @@
+package compat
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vsoch/containerspec/labels"
+	"github.com/vsoch/containerspec/spec"
+	"github.com/vsoch/containerspec/utils"
+)
+
+// Can I run X on my host? Can I optimally run it? We answer by matching what
+// a container needs (its supercontainers labels) against what a host has.
+
+// Verdict says how well a container runs on a host, from best to worst:
+// optimal (built for this host), compatible (runs, e.g., built for an older
+// microarchitecture), degraded (runs without something it wants, e.g., the
+// host MPI) and incompatible (doesn't run)
+type Verdict string
+
+const (
+	Optimal      Verdict = "optimal"
+	Compatible   Verdict = "compatible"
+	Degraded     Verdict = "degraded"
+	Incompatible Verdict = "incompatible"
+)
+
+var verdictRank = map[Verdict]int{Optimal: 0, Compatible: 1, Degraded: 2, Incompatible: 3}
+
+// Worse returns the worse of two verdicts
+func Worse(a, b Verdict) Verdict {
+	if verdictRank[b] > verdictRank[a] {
+		return b
+	}
+	return a
+}
+
+// Container is what an image needs from a host
+type Container struct {
+	Arch           string   `json:"arch,omitempty"`
+	Target         string   `json:"target,omitempty"`
+	Features       []string `json:"features,omitempty"`
+	Glibc          string   `json:"glibc,omitempty"`
+	Kernel         string   `json:"kernel,omitempty"`
+	MPI            string   `json:"mpi,omitempty"`
+	GPU            string   `json:"gpu,omitempty"`
+	CUDACapability string   `json:"cuda_capability,omitempty"`
+
+	// Labels are the supercontainers labels the container has, and any
+	// others for rules that use them
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// FromLabels reads what a container needs from its supercontainers labels.
+// The architecture is the family of the target, if it is known.
+func FromLabels(values map[string]string) *Container {
+	container := Container{
+		Target:         values["org.supercontainers.target"],
+		Glibc:          values["org.supercontainers.glibc"],
+		Kernel:         values["org.supercontainers.kernel"],
+		MPI:            values["org.supercontainers.mpi"],
+		GPU:            values["org.supercontainers.gpu"],
+		CUDACapability: values["org.supercontainers.cuda.capability"],
+		Labels:         values,
+	}
+	if features, ok := values["org.supercontainers.features"]; ok {
+		container.Features = labels.Split(features)
+	}
+	if arch, ok := spec.LookupMicroarchitecture(container.Target); ok {
+		container.Arch = arch.Family().Name
+	}
+	return &container
+}
+
+// FromRootfs uses what we detect in a root file system, for images that
+// don't have labels
+func FromRootfs(info *spec.Rootfs) *Container {
+	container := FromLabels(labels.Generate(info))
+	container.Arch = info.Arch
+	return container
+}
+
+// Result is the verdict of one rule, and why. A rule that finds a problem
+// names it with a code (e.g., mpi-abi) and can give hints to fix it.
+type Result struct {
+	Rule    string   `json:"rule"`
+	Verdict Verdict  `json:"verdict"`
+	Reason  string   `json:"reason"`
+	Code    string   `json:"code,omitempty"`
+	Hints   []string `json:"hints,omitempty"`
+}
+
+// Report is the verdict for a container on a host, the worst of the rules
+type Report struct {
+	Verdict Verdict  `json:"verdict"`
+	Results []Result `json:"results"`
+}
+
+// Check matches what a container needs against a host, with the built-in
+// rules and those registered
+func Check(container *Container, host *spec.Host) *Report {
+	return defaultRegistry.Check(container, host)
+}
+
+// checkArch requires binaries for the host's architecture family
+func checkArch(container *Container, host *spec.Host) Result {
+	if container.Arch == "" || host.Arch == "" {
+		return Result{Verdict: Optimal, Reason: "the architecture isn't known"}
+	}
+	if container.Arch != host.Arch {
+		return Result{
+			Verdict: Incompatible,
+			Reason:  fmt.Sprintf("the container is built for %s, the host is %s", container.Arch, host.Arch),
+			Code:    "arch-mismatch",
+			Hints:   append([]string{fmt.Sprintf("use an image built for %s", platformName(host))}, rebuildHints(host.Target)...),
+		}
+	}
+	return Result{Verdict: Optimal, Reason: fmt.Sprintf("the container and host are %s", host.Arch)}
+}
+
+// checkTarget compares microarchitectures: the host's is optimal, and its
+// ancestors run. Otherwise the host needs every feature of the target.
+func checkTarget(container *Container, host *spec.Host) Result {
+	if container.Target == "" {
+		return Result{Verdict: Optimal, Reason: "the container doesn't name a target"}
+	}
+	target, ok := spec.LookupMicroarchitecture(container.Target)
+	if !ok {
+		return Result{
+			Verdict: Compatible,
+			Reason:  fmt.Sprintf("%s isn't in the CPU database and can't be checked", container.Target),
+			Code:    "target-unknown",
+			Hints:   []string{"label the image with a target from the CPU database, like the host's " + host.Target},
+		}
+	}
+	if container.Target == host.Target {
+		return Result{Verdict: Optimal, Reason: fmt.Sprintf("the container is built for %s, the host's microarchitecture", host.Target)}
+	}
+	if hostArch, ok := spec.LookupMicroarchitecture(host.Target); ok && target.CompatibleWith(hostArch) {
+		return Result{
+			Verdict: Compatible,
+			Reason:  fmt.Sprintf("%s is older than %s, a build for the host would be faster", target.Name, host.Target),
+			Code:    "target-older",
+			Hints:   rebuildHints(host.Target),
+		}
+	}
+	if target.Family().Name != host.Arch {
+		return Result{
+			Verdict: Incompatible,
+			Reason:  fmt.Sprintf("%s is in the %s family, the host is %s", target.Name, target.Family().Name, host.Arch),
+			Code:    "target-family",
+			Hints:   append([]string{fmt.Sprintf("use an image built for %s", platformName(host))}, rebuildHints(host.Target)...),
+		}
+	}
+	if missing := missingFeatures(target.Features, host); len(missing) > 0 {
+		return Result{
+			Verdict: Incompatible,
+			Reason:  fmt.Sprintf("%s needs %s, which the host doesn't have", target.Name, strings.Join(missing, ", ")),
+			Code:    "target-features",
+			Hints:   append(rebuildHints(host.Target), commonHints(target, host)...),
+		}
+	}
+	return Result{
+		Verdict: Compatible,
+		Reason:  fmt.Sprintf("%s isn't an ancestor of %s, but the host has all of its features", target.Name, host.Target),
+		Code:    "target-not-ancestor",
+		Hints:   rebuildHints(host.Target),
+	}
+}
+
+// checkFeatures requires CPU features the container lists
+func checkFeatures(container *Container, host *spec.Host) Result {
+	if len(container.Features) == 0 {
+		return Result{Verdict: Optimal, Reason: "the container doesn't list CPU features"}
+	}
+	if _, ok := spec.LookupMicroarchitecture(host.Target); !ok && len(host.Features) == 0 {
+		return Result{
+			Verdict: Compatible,
+			Reason:  "the host's CPU features aren't known",
+			Code:    "features-unknown",
+			Hints:   []string{"give the host's target or features in the host spec (see the host command)"},
+		}
+	}
+	if missing := missingFeatures(container.Features, host); len(missing) > 0 {
+		return Result{
+			Verdict: Incompatible,
+			Reason:  fmt.Sprintf("the host doesn't have %s", strings.Join(missing, ", ")),
+			Code:    "features-missing",
+			Hints:   append([]string{fmt.Sprintf("rebuild without %s, or run on a host that has them", strings.Join(missing, ", "))}, rebuildHints(host.Target)...),
+		}
+	}
+	return Result{Verdict: Optimal, Reason: fmt.Sprintf("the host has %s", strings.Join(container.Features, ", "))}
+}
+
+// checkGlibc compares glibc versions. A container brings its own glibc, but
+// host libraries bound into it (MPI, GPU drivers) need one at least as new
+// as the host's.
+func checkGlibc(container *Container, host *spec.Host) Result {
+	if container.Glibc == "" || host.Glibc == "" {
+		return Result{Verdict: Optimal, Reason: "the glibc version isn't known"}
+	}
+	if !BindsHostLibraries(container) {
+		return Result{Verdict: Optimal, Reason: fmt.Sprintf("the container brings its own glibc %s", container.Glibc)}
+	}
+	if utils.CompareVersions(container.Glibc, host.Glibc) < 0 {
+		return Result{
+			Verdict: Degraded,
+			Reason:  fmt.Sprintf("host libraries bound into the container may need glibc %s, the container has %s", host.Glibc, container.Glibc),
+			Code:    "glibc-older",
+			Hints: []string{
+				fmt.Sprintf("rebuild from a base image with glibc %s or newer", host.Glibc),
+				"or use the MPI and GPU libraries in the container instead of binding the host's",
+			},
+		}
+	}
+	return Result{Verdict: Optimal, Reason: fmt.Sprintf("glibc %s is at least the host's %s, so host libraries can be bound", container.Glibc, host.Glibc)}
+}
+
+// checkKernel requires the minimum kernel the container asks for
+func checkKernel(container *Container, host *spec.Host) Result {
+	if container.Kernel == "" {
+		return Result{Verdict: Optimal, Reason: "the container doesn't need a minimum kernel"}
+	}
+	if host.Kernel == "" {
+		return Result{
+			Verdict: Compatible,
+			Reason:  fmt.Sprintf("the container needs kernel %s, and the host's isn't known", container.Kernel),
+			Code:    "kernel-unknown",
+			Hints:   []string{"give the host's kernel in the host spec (see the host command)"},
+		}
+	}
+	if utils.CompareVersions(host.Kernel, container.Kernel) < 0 {
+		return Result{
+			Verdict: Incompatible,
+			Reason:  fmt.Sprintf("the container needs kernel %s, the host has %s", container.Kernel, host.Kernel),
+			Code:    "kernel-older",
+			Hints: []string{
+				fmt.Sprintf("run on a host with kernel %s or newer", container.Kernel),
+				fmt.Sprintf("or rebuild from a base image that supports kernel %s", host.Kernel),
+			},
+		}
+	}
+	return Result{Verdict: Optimal, Reason: fmt.Sprintf("kernel %s is at least %s", host.Kernel, container.Kernel)}
+}
+
+// checkMPI requires the host MPI to have the same ABI as the container's,
+// so it can be bound in. Without one, the container runs on one node.
+func checkMPI(container *Container, host *spec.Host) Result {
+	if !Needs(container.MPI) {
+		return Result{Verdict: Optimal, Reason: "the container doesn't use MPI"}
+	}
+	if host.MPI == nil {
+		return Result{
+			Verdict: Degraded,
+			Reason:  fmt.Sprintf("the container uses %s, and the host has no MPI to bind for multi-node runs", container.MPI),
+			Code:    "mpi-missing",
+			Hints:   []string{fmt.Sprintf("install %s on the host (or load its module) to run on more than one node", container.MPI)},
+		}
+	}
+	if host.MPI.Family != container.MPI {
+		return Result{
+			Verdict: Incompatible,
+			Reason:  fmt.Sprintf("the container uses %s, which isn't ABI compatible with the host's %s", container.MPI, host.MPI.Family),
+			Code:    "mpi-abi",
+			Hints: []string{
+				fmt.Sprintf("use the %s variant of the image", host.MPI.Family),
+				fmt.Sprintf("or rebuild with %s", mpiName(host.MPI)),
+			},
+		}
+	}
+	return Result{Verdict: Optimal, Reason: fmt.Sprintf("the container and host use %s", container.MPI)}
+}
+
+// checkGPU requires the GPU runtime the container uses, and for CUDA a
+// compute capability at least the one it is built for
+func checkGPU(container *Container, host *spec.Host) Result {
+	if !Needs(container.GPU) {
+		return Result{Verdict: Optimal, Reason: "the container doesn't use a GPU"}
+	}
+	if !utils.IncludesString(container.GPU, host.GPU) {
+		hints := []string{fmt.Sprintf("run on a host with %s", container.GPU)}
+		for _, gpu := range host.GPU {
+			hints = append(hints, fmt.Sprintf("or use the %s variant of the image", gpu))
+		}
+		return Result{
+			Verdict: Incompatible,
+			Reason:  fmt.Sprintf("the container uses %s, and the host doesn't have it", container.GPU),
+			Code:    "gpu-missing",
+			Hints:   hints,
+		}
+	}
+	if container.GPU == "cuda" && container.CUDACapability != "" && host.CUDACapability != "" {
+		if utils.CompareVersions(host.CUDACapability, container.CUDACapability) < 0 {
+			return Result{
+				Verdict: Incompatible,
+				Reason:  fmt.Sprintf("the container needs compute capability %s, the host has %s", container.CUDACapability, host.CUDACapability),
+				Code:    "cuda-capability",
+				Hints:   []string{fmt.Sprintf("rebuild with nvcc -arch=sm_%s to include the host's GPUs", strings.ReplaceAll(host.CUDACapability, ".", ""))},
+			}
+		}
+	}
+	return Result{Verdict: Optimal, Reason: fmt.Sprintf("the host has %s", container.GPU)}
+}
+
+// missingFeatures returns the features the host doesn't have. Without the
+// host's features, we use those of its microarchitecture and its ancestors
+// (e.g., haswell doesn't list cx16, x86_64_v2 does).
+func missingFeatures(features []string, host *spec.Host) []string {
+	hostArch := spec.Microarchitecture{Name: host.Target, Features: host.Features}
+	if _, ok := spec.LookupMicroarchitecture(host.Arch); ok {
+		hostArch.From = []string{host.Arch}
+	}
+	if arch, ok := spec.LookupMicroarchitecture(host.Target); ok && len(host.Features) == 0 {
+		hostArch = arch
+		hostArch.Features = append([]string{}, arch.Features...)
+		for _, ancestor := range arch.Ancestors() {
+			hostArch.Features = append(hostArch.Features, ancestor.Features...)
+		}
+	}
+	missing := []string{}
+	for _, feature := range features {
+		if !hostArch.Supports(feature) {
+			missing = append(missing, feature)
+		}
+	}
+	return missing
+}
+
+// BindsHostLibraries is true for containers that use host MPI or GPU libraries
+func BindsHostLibraries(container *Container) bool {
+	return Needs(container.MPI) || Needs(container.GPU)
+}
+
+// Needs is true for a label value other than unknown
+func Needs(value string) bool {
+	return value != "" && value != "unknown"
+}
@@ -0,0 +1,143 @@
+package compat
+
+import (
+	"testing"
+
+	"github.com/vsoch/containerspec/spec"
+)
+
+func TestRules(t *testing.T) {
+	haswell := &spec.Host{Arch: "x86_64", Target: "haswell", Glibc: "2.34", Kernel: "5.14.0", GPU: []string{"cuda"}, CUDACapability: "8.0", MPI: &spec.MPI{Family: "openmpi", Version: "4.1.5"}}
+	tests := []struct {
+		name      string
+		check     func(*Container, *spec.Host) Result
+		container Container
+		host      *spec.Host
+		verdict   Verdict
+		code      string
+	}{
+		{name: "arch unknown", check: checkArch, container: Container{}, host: haswell, verdict: Optimal},
+		{name: "arch match", check: checkArch, container: Container{Arch: "x86_64"}, host: haswell, verdict: Optimal},
+		{name: "arch mismatch", check: checkArch, container: Container{Arch: "aarch64"}, host: haswell, verdict: Incompatible, code: "arch-mismatch"},
+
+		{name: "target of the host", check: checkTarget, container: Container{Target: "haswell"}, host: haswell, verdict: Optimal},
+		{name: "target older", check: checkTarget, container: Container{Target: "x86_64_v3"}, host: haswell, verdict: Compatible, code: "target-older"},
+		{name: "target unknown", check: checkTarget, container: Container{Target: "future"}, host: haswell, verdict: Compatible, code: "target-unknown"},
+		{name: "target newer", check: checkTarget, container: Container{Target: "skylake_avx512"}, host: haswell, verdict: Incompatible, code: "target-features"},
+		{name: "target of another family", check: checkTarget, container: Container{Target: "graviton2"}, host: haswell, verdict: Incompatible, code: "target-family"},
+		{
+			name:      "target the host has the features of",
+			check:     checkTarget,
+			container: Container{Target: "x86_64_v2"},
+			host:      &spec.Host{Arch: "x86_64", Features: []string{"mmx", "sse", "sse2", "cx16", "lahf_lm", "popcnt", "sse3", "sse4_1", "sse4_2", "ssse3"}},
+			verdict:   Compatible,
+			code:      "target-not-ancestor",
+		},
+
+		{name: "features none", check: checkFeatures, container: Container{}, host: haswell, verdict: Optimal},
+		{name: "features of an ancestor", check: checkFeatures, container: Container{Features: []string{"avx2", "sse2"}}, host: haswell, verdict: Optimal},
+		{name: "features unknown", check: checkFeatures, container: Container{Features: []string{"avx2"}}, host: &spec.Host{Arch: "x86_64"}, verdict: Compatible, code: "features-unknown"},
+		{name: "features missing", check: checkFeatures, container: Container{Features: []string{"avx512f"}}, host: haswell, verdict: Incompatible, code: "features-missing"},
+
+		{name: "glibc unknown", check: checkGlibc, container: Container{MPI: "openmpi"}, host: haswell, verdict: Optimal},
+		{name: "glibc without host libraries", check: checkGlibc, container: Container{Glibc: "2.17"}, host: haswell, verdict: Optimal},
+		{name: "glibc newer", check: checkGlibc, container: Container{Glibc: "2.35", MPI: "openmpi"}, host: haswell, verdict: Optimal},
+		{name: "glibc older", check: checkGlibc, container: Container{Glibc: "2.28", GPU: "cuda"}, host: haswell, verdict: Degraded, code: "glibc-older"},
+
+		{name: "kernel none", check: checkKernel, container: Container{}, host: haswell, verdict: Optimal},
+		{name: "kernel newer", check: checkKernel, container: Container{Kernel: "4.18"}, host: haswell, verdict: Optimal},
+		{name: "kernel unknown", check: checkKernel, container: Container{Kernel: "4.18"}, host: &spec.Host{}, verdict: Compatible, code: "kernel-unknown"},
+		{name: "kernel older", check: checkKernel, container: Container{Kernel: "6.1"}, host: haswell, verdict: Incompatible, code: "kernel-older"},
+
+		{name: "mpi none", check: checkMPI, container: Container{MPI: "unknown"}, host: haswell, verdict: Optimal},
+		{name: "mpi match", check: checkMPI, container: Container{MPI: "openmpi"}, host: haswell, verdict: Optimal},
+		{name: "mpi missing", check: checkMPI, container: Container{MPI: "mpich"}, host: &spec.Host{}, verdict: Degraded, code: "mpi-missing"},
+		{name: "mpi abi", check: checkMPI, container: Container{MPI: "mpich"}, host: haswell, verdict: Incompatible, code: "mpi-abi"},
+
+		{name: "gpu none", check: checkGPU, container: Container{}, host: haswell, verdict: Optimal},
+		{name: "gpu capability", check: checkGPU, container: Container{GPU: "cuda", CUDACapability: "7.0"}, host: haswell, verdict: Optimal},
+		{name: "gpu missing", check: checkGPU, container: Container{GPU: "rocm"}, host: haswell, verdict: Incompatible, code: "gpu-missing"},
+		{name: "gpu capability older", check: checkGPU, container: Container{GPU: "cuda", CUDACapability: "9.0"}, host: haswell, verdict: Incompatible, code: "cuda-capability"},
+	}
+	for _, test := range tests {
+		container := test.container
+		result := test.check(&container, test.host)
+		if result.Verdict != test.verdict || result.Code != test.code {
+			t.Errorf("%s: expected %s (%q), got %s (%q): %s", test.name, test.verdict, test.code, result.Verdict, result.Code, result.Reason)
+		}
+		if (result.Verdict == Degraded || result.Verdict == Incompatible) && len(result.Hints) == 0 {
+			t.Errorf("%s: expected hints for %s", test.name, result.Code)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	container := FromLabels(map[string]string{
+		"org.supercontainers.target": "x86_64_v3",
+		"org.supercontainers.glibc":  "2.28",
+		"org.supercontainers.mpi":    "openmpi",
+	})
+	if container.Arch != "x86_64" {
+		t.Errorf("expected the arch of the target, got %q", container.Arch)
+	}
+	host := &spec.Host{Arch: "x86_64", Target: "haswell", Glibc: "2.34", MPI: &spec.MPI{Family: "openmpi"}}
+	report := Check(container, host)
+	if report.Verdict != Degraded || len(report.Results) != len(Rules()) {
+		t.Errorf("expected degraded from %d rules, got %s from %d", len(Rules()), report.Verdict, len(report.Results))
+	}
+	for _, result := range report.Results {
+		if result.Rule == "glibc" && result.Code != "glibc-older" {
+			t.Errorf("expected the glibc rule to find glibc-older, got %v", result)
+		}
+	}
+}
+
+func TestWorse(t *testing.T) {
+	verdicts := []Verdict{Optimal, Compatible, Degraded, Incompatible}
+	for i, a := range verdicts {
+		for j, b := range verdicts {
+			want := a
+			if j > i {
+				want = b
+			}
+			if got := Worse(a, b); got != want {
+				t.Errorf("%s and %s: expected %s, got %s", a, b, want, got)
+			}
+		}
+	}
+}
+
+func TestConstraint(t *testing.T) {
+	constraint := &Constraint{
+		Rule:    "ucx",
+		When:    map[string][]string{"label.org.supercontainers.interconnect": {"ucx"}},
+		Require: map[string][]string{"host.properties.ucx": {">=1.14", "!=1.15.0"}},
+		Verdict: Degraded,
+	}
+	ucx := &Container{Labels: map[string]string{"org.supercontainers.interconnect": "ucx"}}
+	tests := []struct {
+		name      string
+		container *Container
+		host      *spec.Host
+		verdict   Verdict
+		code      string
+	}{
+		{name: "doesn't apply", container: &Container{}, host: &spec.Host{}, verdict: Optimal},
+		{name: "met", container: ucx, host: &spec.Host{Properties: map[string]string{"ucx": "1.16.1"}}, verdict: Optimal},
+		{name: "older", container: ucx, host: &spec.Host{Properties: map[string]string{"ucx": "1.12"}}, verdict: Degraded, code: "ucx"},
+		{name: "excluded", container: ucx, host: &spec.Host{Properties: map[string]string{"ucx": "1.15.0"}}, verdict: Degraded, code: "ucx"},
+		{name: "unknown", container: ucx, host: &spec.Host{}, verdict: Compatible, code: "unknown-field"},
+	}
+	for _, test := range tests {
+		result := constraint.Explain(test.container, test.host)
+		if result.Verdict != test.verdict || result.Code != test.code {
+			t.Errorf("%s: expected %s (%q), got %s (%q): %s", test.name, test.verdict, test.code, result.Verdict, result.Code, result.Reason)
+		}
+	}
+
+	registry := DefaultRegistry()
+	registry.Register(constraint)
+	if report := registry.Check(ucx, &spec.Host{Properties: map[string]string{"ucx": "1.12"}}); report.Verdict != Degraded {
+		t.Errorf("expected a registered constraint to degrade the container, got %s", report.Verdict)
+	}
+}
@@ -0,0 +1,467 @@
+package compat
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/vsoch/containerspec/spec"
+	"github.com/vsoch/containerspec/utils"
+)
+
+// A small expression language for policies, e.g.:
+//
+//	label org.supercontainers.mpi == host.mpi.family
+//	host.glibc >= container.glibc
+//	container.target in host.compatible
+//	host.kernel not in [5.14.0, 5.15.2] or not label org.example.fuse
+//
+// Operands are fields (as Field names them), label <key>, a list in
+// brackets, or a value (quoted if it has spaces or operators). Comparisons
+// are ==, !=, in, not in (as versions for fields with one, see
+// versionFields), and <, <=, > and >= as versions, combined with and
+// (&&), or (||), not (!) and parentheses. An operand alone is true if it is
+// set. A comparison with a field that isn't set can't be known, and neither
+// can what depends on it. Versions are ordered one at a time: a list can't
+// be ordered, and a field with several values (e.g., host.gpu) is ordered
+// only if every value is.
+
+var (
+	// versionFields end the names of fields that hold versions, compared as
+	// versions with == and in too. Labels spell them with dots (e.g.,
+	// org.supercontainers.cuda.capability).
+	versionFields = map[string]bool{
+		"glibc":           true,
+		"kernel":          true,
+		"version":         true,
+		"os_version":      true,
+		"cuda_capability": true,
+	}
+	versionRegex = regexp.MustCompile(`^[0-9]+(\.[0-9]+)*$`)
+)
+
+// truth is true, false or unknown
+type truth int
+
+const (
+	falseTruth truth = iota
+	trueTruth
+	unknownTruth
+)
+
+func truthOf(value bool) truth {
+	if value {
+		return trueTruth
+	}
+	return falseTruth
+}
+
+// Expression is a parsed expression
+type Expression struct {
+	source string
+	root   node
+}
+
+// node evaluates to a truth, adding fields that aren't set to unknown
+type node interface {
+	eval(container *Container, host *spec.Host, unknown *[]string) truth
+}
+
+// ParseExpression parses an expression
+func ParseExpression(source string) (*Expression, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", source, err)
+	}
+	p := parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %s", p.tokens[p.pos])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", source, err)
+	}
+	return &Expression{source: source, root: root}, nil
+}
+
+// String returns the expression as it was written
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Evaluate returns whether the expression holds. If that depends on fields
+// that aren't set, it is false and they are returned.
+func (e *Expression) Evaluate(container *Container, host *spec.Host) (bool, []string) {
+	unknown := []string{}
+	switch e.root.eval(container, host, &unknown) {
+	case trueTruth:
+		return true, nil
+	case falseTruth:
+		return false, nil
+	}
+	return false, unique(unknown)
+}
+
+// Operators, longest first so <= isn't read as <
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "[", "]", ","}
+
+// tokenize splits an expression into operators, quoted values and words
+func tokenize(source string) ([]string, error) {
+	tokens := []string{}
+	for i := 0; i < len(source); {
+		c := source[i]
+		if c == ' ' || c == '\t' || c == '\n' {
+			i++
+			continue
+		}
+		if c == '"' || c == '\'' {
+			end := strings.IndexByte(source[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote")
+			}
+			tokens = append(tokens, source[i:i+end+2])
+			i += end + 2
+			continue
+		}
+		operator := ""
+		for _, op := range operators {
+			if strings.HasPrefix(source[i:], op) {
+				operator = op
+				break
+			}
+		}
+		if operator != "" {
+			tokens = append(tokens, operator)
+			i += len(operator)
+			continue
+		}
+		start := i
+		for i < len(source) && !strings.ContainsRune(" \t\n\"'=!<>&|()[],", rune(source[i])) {
+			i++
+		}
+		if start == i {
+			return nil, fmt.Errorf("unexpected %c", c)
+		}
+		tokens = append(tokens, source[start:i])
+	}
+	return tokens, nil
+}
+
+// parser is a recursive descent parser of tokens, from the lowest precedence
+type parser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *parser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *parser) next() string {
+	token := p.peek()
+	p.pos++
+	return token
+}
+
+func (p *parser) expect(token string) error {
+	if found := p.next(); found != token {
+		if found == "" {
+			return fmt.Errorf("expected %s at the end", token)
+		}
+		return fmt.Errorf("expected %s, found %s", token, found)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	for err == nil && (p.peek() == "or" || p.peek() == "||") {
+		p.next()
+		var right node
+		if right, err = p.parseAnd(); err == nil {
+			left = orNode{left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	for err == nil && (p.peek() == "and" || p.peek() == "&&") {
+		p.next()
+		var right node
+		if right, err = p.parseNot(); err == nil {
+			left = andNode{left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *parser) parseNot() (node, error) {
+	switch p.peek() {
+	case "not", "!":
+		p.next()
+		inner, err := p.parseNot()
+		return notNode{inner}, err
+	case "(":
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expect(")")
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	op := p.peek()
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=", "in":
+		p.next()
+	case "not":
+		p.next()
+		if err := p.expect("in"); err != nil {
+			return nil, err
+		}
+		op = "not in"
+	default:
+		return existsNode{left}, nil
+	}
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if strings.ContainsAny(op, "<>") && (left.list || right.list) {
+		return nil, fmt.Errorf("%s compares one version, not a list", op)
+	}
+	return compareNode{op, left, right}, nil
+}
+
+func (p *parser) parseOperand() (operand, error) {
+	token := p.next()
+	switch {
+	case token == "":
+		return operand{}, fmt.Errorf("expected a value at the end")
+	case token == "label":
+		key := p.next()
+		if key == "" || strings.Contains("=!<>&|()[],", key[:1]) {
+			return operand{}, fmt.Errorf("expected a key after label")
+		}
+		return operand{field: "label." + key}, nil
+	case token == "[":
+		values := []string{}
+		for p.peek() != "]" {
+			if len(values) > 0 {
+				if err := p.expect(","); err != nil {
+					return operand{}, err
+				}
+			}
+			value, err := p.parseOperand()
+			if err != nil {
+				return operand{}, err
+			}
+			if value.field != "" {
+				return operand{}, fmt.Errorf("a list has values, not fields like %s", value.field)
+			}
+			values = append(values, value.values...)
+		}
+		p.next()
+		return operand{values: values, literal: true, list: true}, nil
+	case token[0] == '"' || token[0] == '\'':
+		return operand{values: []string{token[1 : len(token)-1]}, literal: true}, nil
+	case strings.HasPrefix(token, "host.") || strings.HasPrefix(token, "container."):
+		return operand{field: token}, nil
+	case strings.Contains("=!<>&|()],", token[:1]) || token == "and" || token == "or" || token == "not" || token == "in":
+		return operand{}, fmt.Errorf("expected a value, found %s", token)
+	}
+	return operand{values: []string{token}, literal: true}, nil
+}
+
+// operand is a field, or literal values
+type operand struct {
+	field   string
+	values  []string
+	literal bool
+	list    bool
+}
+
+// value returns the values of an operand, and if it is set
+func (o operand) value(container *Container, host *spec.Host) ([]string, bool) {
+	if o.literal {
+		return o.values, true
+	}
+	values := Field(container, host, o.field)
+	return values, len(values) > 0
+}
+
+type orNode struct{ left, right node }
+
+func (n orNode) eval(container *Container, host *spec.Host, unknown *[]string) truth {
+	left, right := n.left.eval(container, host, unknown), n.right.eval(container, host, unknown)
+	if left == trueTruth || right == trueTruth {
+		return trueTruth
+	}
+	if left == unknownTruth || right == unknownTruth {
+		return unknownTruth
+	}
+	return falseTruth
+}
+
+type andNode struct{ left, right node }
+
+func (n andNode) eval(container *Container, host *spec.Host, unknown *[]string) truth {
+	left, right := n.left.eval(container, host, unknown), n.right.eval(container, host, unknown)
+	if left == falseTruth || right == falseTruth {
+		return falseTruth
+	}
+	if left == unknownTruth || right == unknownTruth {
+		return unknownTruth
+	}
+	return trueTruth
+}
+
+type notNode struct{ inner node }
+
+func (n notNode) eval(container *Container, host *spec.Host, unknown *[]string) truth {
+	switch n.inner.eval(container, host, unknown) {
+	case trueTruth:
+		return falseTruth
+	case falseTruth:
+		return trueTruth
+	}
+	return unknownTruth
+}
+
+// existsNode is true if a field is set
+type existsNode struct{ operand operand }
+
+func (n existsNode) eval(container *Container, host *spec.Host, unknown *[]string) truth {
+	_, ok := n.operand.value(container, host)
+	return truthOf(ok)
+}
+
+type compareNode struct {
+	op          string
+	left, right operand
+}
+
+func (n compareNode) eval(container *Container, host *spec.Host, unknown *[]string) truth {
+	left, leftOk := n.left.value(container, host)
+	right, rightOk := n.right.value(container, host)
+	if !leftOk || !rightOk {
+		for _, o := range []operand{n.left, n.right} {
+			if _, ok := o.value(container, host); !ok {
+				*unknown = append(*unknown, o.field)
+			}
+		}
+		return unknownTruth
+	}
+
+	equal := func(a, b string) bool { return a == b }
+	if isVersionField(n.left.field) || isVersionField(n.right.field) {
+		equal = sameVersion
+	}
+	switch n.op {
+	case "==":
+		return truthOf(intersects(left, right, equal))
+	case "!=":
+		return truthOf(!intersects(left, right, equal))
+	case "in":
+		return truthOf(contains(right, left, equal))
+	case "not in":
+		return truthOf(!intersects(left, right, equal))
+	}
+	for _, a := range left {
+		for _, b := range right {
+			if !ordered(n.op, utils.CompareVersions(a, b)) {
+				return falseTruth
+			}
+		}
+	}
+	return trueTruth
+}
+
+// ordered is true if the result of comparing two versions satisfies an
+// ordering operator
+func ordered(op string, compared int) bool {
+	switch op {
+	case "<":
+		return compared < 0
+	case "<=":
+		return compared <= 0
+	case ">":
+		return compared > 0
+	}
+	return compared >= 0
+}
+
+// isVersionField is true for fields with a version (e.g., host.glibc,
+// host.mpi.version or label org.supercontainers.cuda.capability)
+func isVersionField(field string) bool {
+	for name := range versionFields {
+		if strings.HasSuffix(field, "."+name) || strings.HasSuffix(field, "."+strings.ReplaceAll(name, "_", ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameVersion compares versions, so 2.17 is 2.17.0. Anything else (e.g., a
+// kernel release with a suffix) has to be the same string.
+func sameVersion(a, b string) bool {
+	if versionRegex.MatchString(a) && versionRegex.MatchString(b) {
+		return utils.CompareVersions(a, b) == 0
+	}
+	return a == b
+}
+
+// intersects is true if the lists share a value
+func intersects(a, b []string, equal func(string, string) bool) bool {
+	for _, value := range a {
+		if includes(b, value, equal) {
+			return true
+		}
+	}
+	return false
+}
+
+// contains is true if every value is in the list
+func contains(list, values []string, equal func(string, string) bool) bool {
+	for _, value := range values {
+		if !includes(list, value, equal) {
+			return false
+		}
+	}
+	return true
+}
+
+// includes is true if a value is in the list
+func includes(list []string, value string, equal func(string, string) bool) bool {
+	for _, item := range list {
+		if equal(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// unique removes repeated names, keeping the first
+func unique(names []string) []string {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			result = append(result, name)
+		}
+	}
+	return result
+}
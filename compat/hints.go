@@ -0,0 +1,92 @@
+package compat
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vsoch/containerspec/spec"
+)
+
+// Hints say how to fix what a rule found. Rebuild advice uses the compiler
+// flags in the CPU database, e.g., rebuild for x86_64_v2 with
+// -march=x86-64-v2 -mtune=generic using gcc>=11.1.
+
+// hintCompilers are the compilers we give flags for, unless a target has
+// none of them
+var hintCompilers = []string{"gcc", "clang"}
+
+// rebuildHints says how to build for a target with each compiler
+func rebuildHints(target string) []string {
+	arch, ok := spec.LookupMicroarchitecture(target)
+	if !ok {
+		return nil
+	}
+	compilers := []string{}
+	for _, compiler := range hintCompilers {
+		if _, ok := arch.CompilerFlags(compiler); ok {
+			compilers = append(compilers, compiler)
+		}
+	}
+	if len(compilers) == 0 {
+		for compiler := range arch.Compilers {
+			compilers = append(compilers, compiler)
+		}
+		sort.Strings(compilers)
+	}
+
+	hints := []string{}
+	for _, compiler := range compilers {
+		flags, ok := arch.CompilerFlags(compiler)
+		if !ok {
+			continue
+		}
+		hint := fmt.Sprintf("rebuild for %s with %s using %s%s", arch.Name, flags.Flags, compiler, flags.Requires())
+		if len(hints) > 0 {
+			hint = "or " + hint
+		}
+		hints = append(hints, hint)
+	}
+	if len(hints) == 0 {
+		hints = append(hints, fmt.Sprintf("rebuild for %s", arch.Name))
+	}
+	return hints
+}
+
+// commonHints says how to build one image for a target and a host that
+// doesn't run it, for its most specific ancestor the host runs
+func commonHints(target spec.Microarchitecture, host *spec.Host) []string {
+	hostArch, ok := spec.LookupMicroarchitecture(host.Target)
+	if !ok {
+		return nil
+	}
+	var common *spec.Microarchitecture
+	for _, ancestor := range target.Ancestors() {
+		if ancestor.CompatibleWith(hostArch) && (common == nil || ancestor.Depth() > common.Depth()) {
+			ancestor := ancestor
+			common = &ancestor
+		}
+	}
+	if common == nil || common.Name == hostArch.Name {
+		return nil
+	}
+	hints := rebuildHints(common.Name)
+	hints[0] = fmt.Sprintf("or, to run on %s and %s, %s", target.Name, host.Target, strings.TrimPrefix(hints[0], "or "))
+	return hints[:1]
+}
+
+// platformName is the OCI platform of a host if we know it, or its family
+func platformName(host *spec.Host) string {
+	if platform, ok := spec.PlatformOf(host.Target); ok {
+		return platform.OCI
+	}
+	return host.Arch
+}
+
+// mpiName is an MPI with its version, if we know it
+func mpiName(mpi *spec.MPI) string {
+	if mpi.Version != "" {
+		return fmt.Sprintf("%s %s", mpi.Family, mpi.Version)
+	}
+	return mpi.Family
+}
@@ -0,0 +1,131 @@
+package compat
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vsoch/containerspec/image"
+	"github.com/vsoch/containerspec/spec"
+)
+
+// A multi-arch index only says linux/amd64/v3 or linux/arm64/v8, but a host
+// is an icelake or a graviton2. We choose the image built for the closest
+// target the host can run, walking from the host's microarchitecture through
+// its ancestors down to the generic family.
+
+// Selection is the image chosen from an index for a host
+type Selection struct {
+	Descriptor image.Descriptor `json:"descriptor"`
+	Target     string           `json:"target,omitempty"`
+	Verdict    Verdict          `json:"verdict"`
+	Reason     string           `json:"reason"`
+}
+
+// FromDescriptor reads what an image needs from its descriptor in an index:
+// supercontainers annotations, then the platform. The target annotation is
+// more specific than the variant, and CPU features of both are required.
+func FromDescriptor(descriptor image.Descriptor) *Container {
+	values := map[string]string{}
+	for key, value := range descriptor.Annotations {
+		if strings.HasPrefix(key, "org.supercontainers.") {
+			values[key] = value
+		}
+	}
+	container := FromLabels(values)
+	if descriptor.Platform == nil {
+		return container
+	}
+	platform := descriptor.Platform
+	container.Arch = spec.GoarchFamily(platform.Architecture)
+	if container.Target == "" {
+		container.Target = container.Arch
+		if target, ok := spec.TargetOfGo(platform.Architecture, platform.Variant); ok {
+			container.Target = target
+		}
+	}
+	container.Features = append(container.Features, platform.CPUFeatures...)
+	return container
+}
+
+// SelectManifest chooses the image of an index to run on a host: the first
+// target in the host's fallback chain that an image is built for, skipping
+// images that are incompatible for other reasons (e.g., a GPU the host
+// doesn't have). Images that run but aren't in the chain are ranked last.
+func SelectManifest(manifests []image.Descriptor, host *spec.Host) (*Selection, error) {
+	candidates := map[string][]int{}
+	reports := []*Report{}
+	variants := []Variant{}
+	byDigest := map[string]int{}
+	for i, descriptor := range manifests {
+		container := FromDescriptor(descriptor)
+		report := Check(container, host)
+		reports = append(reports, report)
+		if report.Verdict == Incompatible {
+			continue
+		}
+		candidates[container.Target] = append(candidates[container.Target], i)
+		variants = append(variants, Variant{Name: descriptor.Digest, Container: container})
+		byDigest[descriptor.Digest] = i
+	}
+
+	chain := FallbackChain(host)
+	for step, target := range chain {
+		best := -1
+		for _, i := range candidates[target] {
+			if best < 0 || verdictRank[reports[i].Verdict] < verdictRank[reports[best].Verdict] {
+				best = i
+			}
+		}
+		if best < 0 {
+			continue
+		}
+		selection := Selection{Descriptor: manifests[best], Target: target, Verdict: reports[best].Verdict}
+		if step == 0 {
+			selection.Reason = fmt.Sprintf("the image is built for %s, the host's microarchitecture", target)
+		} else {
+			selection.Reason = fmt.Sprintf("there is no image for %s, %s is the closest target the host runs", chain[0], target)
+		}
+		return &selection, nil
+	}
+
+	// Images the host runs without a target in the chain, e.g., built for
+	// another vendor's microarchitecture with features the host has
+	if ranked := Rank(variants, host); len(ranked) > 0 {
+		return &Selection{
+			Descriptor: manifests[byDigest[ranked[0].Variant]],
+			Target:     ranked[0].Target,
+			Verdict:    ranked[0].Verdict,
+			Reason:     ranked[0].Reason,
+		}, nil
+	}
+
+	platforms := []string{}
+	for _, descriptor := range manifests {
+		if descriptor.Platform != nil {
+			platforms = append(platforms, descriptor.Platform.String())
+		}
+	}
+	return nil, fmt.Errorf("no image runs on %s %s, found %s", host.Arch, host.Target, strings.Join(platforms, ", "))
+}
+
+// FallbackChain is the host's microarchitecture, then its ancestors nearest
+// first, ending with the generic family (e.g., icelake, ..., x86_64_v4, ...,
+// x86_64)
+func FallbackChain(host *spec.Host) []string {
+	arch, ok := spec.LookupMicroarchitecture(host.Target)
+	if !ok {
+		if arch, ok = spec.LookupMicroarchitecture(host.Arch); !ok {
+			return []string{host.Arch}
+		}
+	}
+	chain := []string{arch.Name}
+	for _, ancestor := range arch.Ancestors() {
+		if ancestor.Name != arch.Family().Name {
+			chain = append(chain, ancestor.Name)
+		}
+	}
+	if arch.Name != arch.Family().Name {
+		chain = append(chain, arch.Family().Name)
+	}
+	return chain
+}
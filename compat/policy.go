@@ -0,0 +1,149 @@
+package compat
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/vsoch/containerspec/spec"
+	"gopkg.in/yaml.v3"
+)
+
+// A policy is rules in a yaml or json file, so a site can change them without
+// recompiling, e.g.:
+//
+//	rules:
+//	  - name: mpi-abi
+//	    when: label org.supercontainers.mpi
+//	    require: label org.supercontainers.mpi == host.mpi.family
+//	  - name: fuse
+//	    when: label org.example.fuse
+//	    require: host.kernel not in [5.14.0, 5.15.2]
+//	    verdict: degraded
+//	    reason: these kernels break our FUSE driver
+
+// Policy is a set of rules read from a file
+type Policy struct {
+	Rules []*PolicyRule `json:"rules" yaml:"rules"`
+}
+
+// PolicyRule is a rule from expressions. When When holds (or there is none),
+// Require must too.
+type PolicyRule struct {
+	Rule    string  `json:"name" yaml:"name"`
+	When    string  `json:"when,omitempty" yaml:"when,omitempty"`
+	Require string  `json:"require" yaml:"require"`
+	Verdict Verdict `json:"verdict,omitempty" yaml:"verdict,omitempty"`
+	Reason  string  `json:"reason,omitempty" yaml:"reason,omitempty"`
+
+	// Code and hints when the rule fails, the code defaults to the name
+	Code  string   `json:"code,omitempty" yaml:"code,omitempty"`
+	Hints []string `json:"hints,omitempty" yaml:"hints,omitempty"`
+
+	when, require *Expression
+}
+
+// ReadPolicy reads a policy from a yaml or json file (by its extension)
+func ReadPolicy(path string) (*Policy, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := ParsePolicy(content, strings.ToLower(filepath.Ext(path)) == ".json")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return policy, nil
+}
+
+// ParsePolicy parses a policy from json or yaml, and its expressions
+func ParsePolicy(content []byte, isJSON bool) (*Policy, error) {
+	policy := Policy{}
+	if isJSON {
+		err := json.Unmarshal(content, &policy)
+		if err != nil {
+			return nil, err
+		}
+	} else if err := yaml.Unmarshal(content, &policy); err != nil {
+		return nil, err
+	}
+
+	for i, rule := range policy.Rules {
+		if rule.Rule == "" {
+			return nil, fmt.Errorf("rule %d doesn't have a name", i+1)
+		}
+		if rule.Require == "" {
+			return nil, fmt.Errorf("rule %s doesn't have an expression to require", rule.Rule)
+		}
+		if _, ok := verdictRank[rule.Verdict]; !ok && rule.Verdict != "" {
+			return nil, fmt.Errorf("rule %s: %s is not a verdict, choices are optimal, compatible, degraded or incompatible", rule.Rule, rule.Verdict)
+		}
+		var err error
+		if rule.When != "" {
+			if rule.when, err = ParseExpression(rule.When); err != nil {
+				return nil, fmt.Errorf("rule %s: %s", rule.Rule, err)
+			}
+		}
+		if rule.require, err = ParseExpression(rule.Require); err != nil {
+			return nil, fmt.Errorf("rule %s: %s", rule.Rule, err)
+		}
+	}
+	return &policy, nil
+}
+
+// Registry returns the rules Check uses followed by those of the policy,
+// which replace rules with the same name
+func (p *Policy) Registry() *Registry {
+	registry := DefaultRegistry()
+	for _, rule := range p.Rules {
+		registry.Register(rule)
+	}
+	return registry
+}
+
+// Name of the rule
+func (r *PolicyRule) Name() string { return r.Rule }
+
+// Check the container and host against the rule
+func (r *PolicyRule) Check(container *Container, host *spec.Host) (Verdict, string) {
+	result := r.Explain(container, host)
+	return result.Verdict, result.Reason
+}
+
+// Explain checks the container and host against the rule. If it depends on
+// fields that aren't set, it can't be checked and the container is compatible.
+func (r *PolicyRule) Explain(container *Container, host *spec.Host) Result {
+	if r.when != nil {
+		if applies, unknown := r.when.Evaluate(container, host); !applies {
+			if len(unknown) > 0 {
+				return Result{Verdict: Optimal, Reason: fmt.Sprintf("%s doesn't apply, %s isn't known", r.Rule, strings.Join(unknown, ", "))}
+			}
+			return Result{Verdict: Optimal, Reason: fmt.Sprintf("%s doesn't apply, %s is false", r.Rule, r.when)}
+		}
+	}
+	holds, unknown := r.require.Evaluate(container, host)
+	if len(unknown) > 0 {
+		return Result{
+			Verdict: Compatible,
+			Reason:  fmt.Sprintf("%s can't be checked, %s isn't known", r.Rule, strings.Join(unknown, ", ")),
+			Code:    "unknown-field",
+			Hints:   []string{fmt.Sprintf("give %s in the host spec or labels", strings.Join(unknown, ", "))},
+		}
+	}
+	if holds {
+		return Result{Verdict: Optimal, Reason: fmt.Sprintf("%s holds", r.require)}
+	}
+	result := Result{Verdict: r.Verdict, Reason: r.Reason, Code: r.Code, Hints: r.Hints}
+	if result.Verdict == "" {
+		result.Verdict = Incompatible
+	}
+	if result.Reason == "" {
+		result.Reason = fmt.Sprintf("%s is false", r.require)
+	}
+	if result.Code == "" {
+		result.Code = r.Rule
+	}
+	return result
+}
@@ -0,0 +1,111 @@
+package compat
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/vsoch/containerspec/spec"
+)
+
+func TestExpressionVersions(t *testing.T) {
+	container := &Container{Glibc: "2.17", Labels: map[string]string{"org.supercontainers.glibc": "2.17"}}
+	host := &spec.Host{Glibc: "2.17.0", Kernel: "5.14.0-284.el9", Arch: "x86_64"}
+	tests := []struct {
+		source string
+		holds  bool
+	}{
+		{source: "container.glibc == 2.17.0", holds: true},
+		{source: "container.glibc == host.glibc", holds: true},
+		{source: "label org.supercontainers.glibc == 2.17.0", holds: true},
+		{source: "container.glibc != 2.17.0", holds: false},
+		{source: "container.glibc in [2.17.0, 2.28]", holds: true},
+		{source: "host.glibc not in [2.17, 2.28]", holds: false},
+		{source: "host.kernel == 5.14.0", holds: false},
+		{source: "host.kernel == 5.14.0-284.el9", holds: true},
+		{source: "host.arch == x86_64", holds: true},
+	}
+	for _, test := range tests {
+		expression, err := ParseExpression(test.source)
+		if err != nil {
+			t.Errorf("%s: %s", test.source, err)
+			continue
+		}
+		if holds, unknown := expression.Evaluate(container, host); holds != test.holds || len(unknown) > 0 {
+			t.Errorf("%s: expected %v, got %v (unknown %v)", test.source, test.holds, holds, unknown)
+		}
+	}
+}
+
+func TestPolicyRegistry(t *testing.T) {
+	policy, err := ParsePolicy([]byte("rules:\n  - name: glibc\n    require: container.glibc == 2.28\n  - name: fuse\n    require: host.kernel != 5.14.0\n"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	builtin := Rules()
+	registry := policy.Registry()
+
+	// Rules of the policy replace built-in ones with their name, or follow them
+	names := []string{}
+	for _, rule := range registry.Rules() {
+		names = append(names, rule.Name())
+	}
+	if len(names) != len(builtin)+1 || names[len(names)-1] != "fuse" {
+		t.Errorf("expected the built-in rules and fuse, got %v", names)
+	}
+	for i, rule := range builtin {
+		if _, ok := rule.(*PolicyRule); ok {
+			t.Errorf("expected built-in rule %s not to be replaced", rule.Name())
+		}
+		if names[i] != rule.Name() {
+			t.Errorf("expected rule %d to be %s, got %s", i, rule.Name(), names[i])
+		}
+	}
+
+	// Check still uses only the built-in rules
+	if len(Rules()) != len(builtin) {
+		t.Errorf("expected the policy not to change the rules Check uses")
+	}
+	container, host := &Container{Glibc: "2.17"}, &spec.Host{Kernel: "5.15.0"}
+	if verdict := registry.Check(container, host).Verdict; verdict != Incompatible {
+		t.Errorf("expected the policy to make the container incompatible, got %s", verdict)
+	}
+	if verdict := Check(container, host).Verdict; verdict == Incompatible {
+		t.Errorf("expected the built-in rules to allow the container, got %s", verdict)
+	}
+}
+
+func TestExpressionOrdering(t *testing.T) {
+	container := &Container{Labels: map[string]string{"org.supercontainers.cuda.capability": "8.0", "org.example.sm": "8.0"}}
+	host := &spec.Host{Glibc: "2.34", CUDACapability: "8.0.0", GPU: []string{"1.2", "1.10"}}
+	tests := []struct {
+		source string
+		holds  bool
+	}{
+		{source: "label org.supercontainers.cuda.capability == host.cuda_capability", holds: true},
+		{source: "label org.supercontainers.cuda.capability in [7.0, 8.0.0]", holds: true},
+		{source: "label org.example.sm == 8.0.0", holds: false},
+		{source: "host.glibc >= 2.28", holds: true},
+		{source: "host.glibc < 2.4", holds: false},
+		{source: "host.glibc <= 2.34.0", holds: true},
+		{source: "host.gpu > 1.1", holds: true},
+		{source: "host.gpu >= 1.10", holds: false},
+		{source: "host.gpu < 1.10", holds: false},
+		{source: "host.gpu <= 1.10", holds: true},
+	}
+	for _, test := range tests {
+		expression, err := ParseExpression(test.source)
+		if err != nil {
+			t.Errorf("%s: %s", test.source, err)
+			continue
+		}
+		if holds, unknown := expression.Evaluate(container, host); holds != test.holds || len(unknown) > 0 {
+			t.Errorf("%s: expected %v, got %v (unknown %v)", test.source, test.holds, holds, unknown)
+		}
+	}
+
+	for _, source := range []string{"host.glibc >= [2.17, 2.28]", "[2.17] < host.glibc"} {
+		if _, err := ParseExpression(source); err == nil || !strings.Contains(err.Error(), "not a list") {
+			t.Errorf("%s: expected an error ordering a list, got %v", source, err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package compat
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vsoch/containerspec/spec"
+)
+
+// Can I optimally run it? When an image is built for several targets (e.g.,
+// x86_64_v2, x86_64_v3 and icelake), the best one for a host is the closest
+// to its microarchitecture that it can run.
+
+// Variant is one image of a set built for different targets
+type Variant struct {
+	Name      string     `json:"name"`
+	Container *Container `json:"container"`
+}
+
+// Ranked is the score of a variant for a host. Score is 1 for a variant built
+// for the host's microarchitecture, divided by one more than the steps to it
+// in the ancestry graph, and multiplied by the fraction of the target's
+// features the host has.
+type Ranked struct {
+	Variant  string   `json:"variant"`
+	Target   string   `json:"target,omitempty"`
+	Verdict  Verdict  `json:"verdict"`
+	Score    float64  `json:"score"`
+	Distance int      `json:"distance"`
+	Missing  []string `json:"missing,omitempty"`
+	Reason   string   `json:"reason"`
+
+	// Of two variants as close, the more specific one (deeper in the graph,
+	// e.g., haswell over x86_64_v3) is tuned better
+	depth int
+}
+
+// Rank orders variants from best to worst for a host: by verdict, so those
+// that don't run are last, then by score
+func Rank(variants []Variant, host *spec.Host) []Ranked {
+	ranked := []Ranked{}
+	for _, variant := range variants {
+		ranked = append(ranked, rank(variant, host))
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if a.Verdict != b.Verdict {
+			return verdictRank[a.Verdict] < verdictRank[b.Verdict]
+		}
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.depth != b.depth {
+			return a.depth > b.depth
+		}
+		return a.Variant < b.Variant
+	})
+	return ranked
+}
+
+// rank scores one variant, explaining the distance and the rules that failed
+func rank(variant Variant, host *spec.Host) Ranked {
+	container := variant.Container
+	report := Check(container, host)
+	ranked := Ranked{Variant: variant.Name, Target: container.Target, Verdict: report.Verdict, Distance: -1}
+
+	// A variant without a target is built for its family (e.g., x86_64)
+	target, ok := spec.LookupMicroarchitecture(container.Target)
+	if !ok {
+		target, ok = spec.LookupMicroarchitecture(container.Arch)
+	}
+	hostArch, found := spec.LookupMicroarchitecture(host.Target)
+	if !found {
+		hostArch, found = spec.LookupMicroarchitecture(host.Arch)
+	}
+
+	reasons := []string{}
+	if ok && found {
+		if distance, same := target.Distance(hostArch); same {
+			ranked.Distance = distance
+			ranked.depth = target.Depth()
+			ranked.Missing = missingFeatures(target.Features, host)
+			ranked.Score = 1 / float64(1+distance)
+			if len(target.Features) > 0 {
+				ranked.Score *= float64(len(target.Features)-len(ranked.Missing)) / float64(len(target.Features))
+			}
+			reasons = append(reasons, explainDistance(target, hostArch, distance, ranked.Missing))
+		}
+	} else {
+		reasons = append(reasons, "the target isn't known")
+	}
+
+	// Other rules (e.g., arch, MPI or GPU) can make a close variant a bad choice
+	for _, result := range report.Results {
+		if result.Rule != "target" && verdictRank[result.Verdict] >= verdictRank[Degraded] {
+			reasons = append(reasons, result.Reason)
+		}
+	}
+	ranked.Reason = strings.Join(reasons, "; ")
+	return ranked
+}
+
+// explainDistance describes where a target is relative to the host
+func explainDistance(target, host spec.Microarchitecture, distance int, missing []string) string {
+	steps := fmt.Sprintf("%d steps", distance)
+	if distance == 1 {
+		steps = "1 step"
+	}
+	var reason string
+	switch {
+	case distance == 0:
+		reason = fmt.Sprintf("%s is the host's microarchitecture", target.Name)
+	case target.CompatibleWith(host):
+		reason = fmt.Sprintf("%s is an ancestor of %s, %s away", target.Name, host.Name, steps)
+	default:
+		reason = fmt.Sprintf("%s isn't an ancestor of %s, %s away", target.Name, host.Name, steps)
+	}
+	if len(missing) > 0 {
+		reason += fmt.Sprintf(", and the host doesn't have %s", strings.Join(missing, ", "))
+	}
+	return reason
+}
@@ -0,0 +1,354 @@
+package compat
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vsoch/containerspec/spec"
+	"github.com/vsoch/containerspec/utils"
+)
+
+// Sites have constraints we can't know (e.g., the fabric needs a certain UCX,
+// or a kernel breaks a FUSE driver). A rule checks one of them, and rules are
+// added in Go (anything with Name and Check), declared as data (Constraint)
+// or as expressions in a Policy.
+
+// Rule checks one thing a container needs against a host
+type Rule interface {
+	Name() string
+	Check(container *Container, host *spec.Host) (Verdict, string)
+}
+
+// Explainer is a rule that also gives a code for what it found (e.g.,
+// mpi-abi), and hints to fix it
+type Explainer interface {
+	Rule
+	Explain(container *Container, host *spec.Host) Result
+}
+
+// explained is a rule from a function that explains its result
+type explained struct {
+	name  string
+	check func(*Container, *spec.Host) Result
+}
+
+func (r explained) Name() string { return r.name }
+
+func (r explained) Check(container *Container, host *spec.Host) (Verdict, string) {
+	result := r.check(container, host)
+	return result.Verdict, result.Reason
+}
+
+func (r explained) Explain(container *Container, host *spec.Host) Result {
+	return r.check(container, host)
+}
+
+// ruleFunc is a rule from a function
+type ruleFunc struct {
+	name  string
+	check func(*Container, *spec.Host) (Verdict, string)
+}
+
+func (r ruleFunc) Name() string { return r.name }
+
+func (r ruleFunc) Check(container *Container, host *spec.Host) (Verdict, string) {
+	return r.check(container, host)
+}
+
+// NewRule makes a rule from a function
+func NewRule(name string, check func(*Container, *spec.Host) (Verdict, string)) Rule {
+	return ruleFunc{name: name, check: check}
+}
+
+// Registry holds rules in the order they are checked
+type Registry struct {
+	rules []Rule
+}
+
+// NewRegistry returns a registry with the given rules
+func NewRegistry(rules ...Rule) *Registry {
+	registry := Registry{}
+	for _, rule := range rules {
+		registry.Register(rule)
+	}
+	return &registry
+}
+
+// Register adds a rule to be checked after the others. A rule with the name
+// of one already registered replaces it, so a site can change a built-in rule.
+func (r *Registry) Register(rule Rule) {
+	for i, existing := range r.rules {
+		if existing.Name() == rule.Name() {
+			r.rules[i] = rule
+			return
+		}
+	}
+	r.rules = append(r.rules, rule)
+}
+
+// Rules returns the registered rules in order
+func (r *Registry) Rules() []Rule {
+	return append([]Rule{}, r.rules...)
+}
+
+// Check matches what a container needs against a host with every rule
+func (r *Registry) Check(container *Container, host *spec.Host) *Report {
+	report := Report{Verdict: Optimal}
+	for _, rule := range r.rules {
+		result := Result{}
+		if explainer, ok := rule.(Explainer); ok {
+			result = explainer.Explain(container, host)
+		} else {
+			result.Verdict, result.Reason = rule.Check(container, host)
+		}
+		result.Rule = rule.Name()
+		report.Results = append(report.Results, result)
+		report.Verdict = Worse(report.Verdict, result.Verdict)
+	}
+	return &report
+}
+
+// Rules are checked in order, and each one skips what isn't specified
+var defaultRegistry = NewRegistry(
+	explained{"arch", checkArch},
+	explained{"target", checkTarget},
+	explained{"features", checkFeatures},
+	explained{"glibc", checkGlibc},
+	explained{"kernel", checkKernel},
+	explained{"mpi", checkMPI},
+	explained{"gpu", checkGPU},
+)
+
+// Register adds a rule to those Check uses, e.g., from the init of a package
+func Register(rule Rule) {
+	defaultRegistry.Register(rule)
+}
+
+// Rules returns the rules Check uses, in order
+func Rules() []Rule {
+	return defaultRegistry.Rules()
+}
+
+// DefaultRegistry returns a copy of the rules Check uses, to add others to
+// (e.g., from a Policy) without changing Check
+func DefaultRegistry() *Registry {
+	return NewRegistry(defaultRegistry.Rules()...)
+}
+
+// Constraint is a rule declared as data, so it can be read from json. When
+// the fields in When match, the fields in Require must too. Fields are named
+// as Field names them. Patterns are a value, * for any value, or a value
+// after one of =, !=, <, <=, > and >=, compared as versions. A field with
+// several values (e.g., host.gpu) matches if one of them does, and != if
+// none is equal.
+type Constraint struct {
+	Rule    string              `json:"name"`
+	When    map[string][]string `json:"when,omitempty"`
+	Require map[string][]string `json:"require"`
+
+	// Verdict when a requirement isn't met, incompatible by default
+	Verdict Verdict `json:"verdict,omitempty"`
+	Reason  string  `json:"reason,omitempty"`
+
+	// Code and hints when a requirement isn't met, the code defaults to the name
+	Code  string   `json:"code,omitempty"`
+	Hints []string `json:"hints,omitempty"`
+}
+
+// Name of the rule
+func (c *Constraint) Name() string { return c.Rule }
+
+// Check the container and host against the constraint
+func (c *Constraint) Check(container *Container, host *spec.Host) (Verdict, string) {
+	result := c.Explain(container, host)
+	return result.Verdict, result.Reason
+}
+
+// Explain checks the container and host against the constraint. A field the
+// host doesn't say can't be checked, so the container is only compatible.
+func (c *Constraint) Explain(container *Container, host *spec.Host) Result {
+	for _, name := range sortedKeys(c.When) {
+		if !matchAll(Field(container, host, name), c.When[name]) {
+			return Result{Verdict: Optimal, Reason: fmt.Sprintf("%s doesn't apply, %s isn't %s", c.Rule, name, strings.Join(c.When[name], " and "))}
+		}
+	}
+
+	unknown := []string{}
+	for _, name := range sortedKeys(c.Require) {
+		values := Field(container, host, name)
+		if len(values) == 0 {
+			unknown = append(unknown, name)
+			continue
+		}
+		if !matchAll(values, c.Require[name]) {
+			result := Result{Verdict: c.Verdict, Reason: c.Reason, Code: c.Code, Hints: c.Hints}
+			if result.Verdict == "" {
+				result.Verdict = Incompatible
+			}
+			if result.Reason == "" {
+				result.Reason = fmt.Sprintf("%s is %s, %s requires %s", name, strings.Join(values, ", "), c.Rule, strings.Join(c.Require[name], " and "))
+			}
+			if result.Code == "" {
+				result.Code = c.Rule
+			}
+			return result
+		}
+	}
+	if len(unknown) > 0 {
+		return Result{
+			Verdict: Compatible,
+			Reason:  fmt.Sprintf("%s can't be checked, %s isn't known", c.Rule, strings.Join(unknown, ", ")),
+			Code:    "unknown-field",
+			Hints:   []string{fmt.Sprintf("give %s in the host spec or labels", strings.Join(unknown, ", "))},
+		}
+	}
+	return Result{Verdict: Optimal, Reason: fmt.Sprintf("%s is met", c.Rule)}
+}
+
+// Field returns the values of a field of the container or host, or nothing
+// if it isn't set. Fields are named as in the json of the container and host
+// (e.g., container.mpi, host.kernel, host.mpi.family or host.properties.ucx),
+// label.<key> for a label of the container, or host.compatible for the
+// targets the host runs. A field can have several values (e.g., host.gpu).
+func Field(container *Container, host *spec.Host, name string) []string {
+	if key, ok := strings.CutPrefix(name, "label."); ok {
+		if value, ok := container.Labels[key]; ok && value != "" {
+			return []string{value}
+		}
+		return nil
+	}
+	if name == "host.compatible" {
+		if host.Arch == "" && host.Target == "" {
+			return nil
+		}
+		return FallbackChain(host)
+	}
+	source, path, _ := strings.Cut(name, ".")
+	var value interface{}
+	switch source {
+	case "container":
+		value = container
+	case "host":
+		value = host
+	default:
+		return nil
+	}
+	content, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	var object interface{}
+	if err := json.Unmarshal(content, &object); err != nil {
+		return nil
+	}
+	return fieldValues(object, path)
+}
+
+// fieldValues walks a dotted path in json. Keys can have dots (e.g., labels
+// and properties), so the rest of the path is tried as a key first.
+func fieldValues(value interface{}, path string) []string {
+	if path == "" {
+		return flatten(value)
+	}
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if child, ok := object[path]; ok {
+		return flatten(child)
+	}
+	for i := range path {
+		if path[i] != '.' {
+			continue
+		}
+		if child, ok := object[path[:i]]; ok {
+			return fieldValues(child, path[i+1:])
+		}
+	}
+	return nil
+}
+
+// flatten returns the values of json as strings
+func flatten(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return nil
+		}
+		return []string{v}
+	case float64:
+		return []string{strconv.FormatFloat(v, 'f', -1, 64)}
+	case bool:
+		return []string{strconv.FormatBool(v)}
+	case []interface{}:
+		values := []string{}
+		for _, item := range v {
+			values = append(values, flatten(item)...)
+		}
+		return values
+	}
+	return nil
+}
+
+// matchAll is true if the values match every pattern
+func matchAll(values, patterns []string) bool {
+	for _, pattern := range patterns {
+		if !match(values, pattern) {
+			return false
+		}
+	}
+	return true
+}
+
+// match is true if one of the values matches the pattern, or for != if none
+// is equal
+func match(values []string, pattern string) bool {
+	if pattern == "*" {
+		return len(values) > 0
+	}
+	if expected, ok := strings.CutPrefix(pattern, "!="); ok {
+		return !utils.IncludesString(strings.TrimSpace(expected), values)
+	}
+	for _, value := range values {
+		if matchValue(value, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchValue compares one value to a pattern
+func matchValue(value, pattern string) bool {
+	for _, op := range []string{"<=", ">=", "<", ">", "="} {
+		expected, ok := strings.CutPrefix(pattern, op)
+		if !ok {
+			continue
+		}
+		compared := utils.CompareVersions(value, strings.TrimSpace(expected))
+		switch op {
+		case "<=":
+			return compared <= 0
+		case ">=":
+			return compared >= 0
+		case "<":
+			return compared < 0
+		case ">":
+			return compared > 0
+		}
+		return value == strings.TrimSpace(expected)
+	}
+	return value == pattern
+}
+
+// sortedKeys returns the fields of a constraint in a stable order
+func sortedKeys(fields map[string][]string) []string {
+	keys := []string{}
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,350 @@
+package dockerfile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// We only need enough of the Dockerfile syntax to reason about base images
+// and labels, so instructions are kept as raw arguments and only FROM, ARG
+// and LABEL are parsed further.
+
+var (
+	directiveRegex = regexp.MustCompile(`^#\s*([a-zA-Z]+)\s*=\s*(.+)$`)
+
+	// A heredoc (RUN <<EOF) is followed by lines up to its terminator, with
+	// leading tabs removed for <<-. The word can be quoted.
+	heredocRegex = regexp.MustCompile(`<<(-?)(["']?)([a-zA-Z_][a-zA-Z0-9_]*)(["']?)`)
+)
+
+// heredocCommands are the instructions that can have heredocs
+var heredocCommands = map[string]bool{"RUN": true, "COPY": true, "ADD": true}
+
+// heredoc is the terminator of a heredoc body
+type heredoc struct {
+	word      string
+	stripTabs bool
+}
+
+// Instruction is one (possibly continued) line in a Dockerfile
+type Instruction struct {
+	Command string
+	Args    string
+	Line    int
+}
+
+// Label is a key and value from a LABEL instruction
+type Label struct {
+	Key   string
+	Value string
+	Line  int
+}
+
+// Stage is a build stage, starting with a FROM instruction
+type Stage struct {
+	Index    int
+	Name     string
+	Image    string
+	Platform string
+	Line     int
+	Labels   []Label
+
+	// Build arguments declared in the stage that have a default. A global
+	// argument declared again without a default keeps the global one.
+	Args map[string]string
+}
+
+// Dockerfile holds the parsed instructions and build stages
+type Dockerfile struct {
+	Path         string
+	Instructions []Instruction
+	Stages       []*Stage
+
+	// Global build arguments, declared before the first FROM, that have a
+	// default. Others are only known at build time (--build-arg).
+	Args map[string]string
+}
+
+// Load reads and parses a Dockerfile
+func Load(path string) (*Dockerfile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	d, err := Parse(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	d.Path = path
+	return d, nil
+}
+
+// Parse reads Dockerfile instructions and groups them into stages
+func Parse(reader io.Reader) (*Dockerfile, error) {
+	d := Dockerfile{Args: map[string]string{}}
+	escape := `\`
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+
+	lineno := 0
+	start := 0
+	current := ""
+	directives := true
+	heredocs := []heredoc{}
+
+	for scanner.Scan() {
+		lineno++
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		// Heredoc bodies are content (e.g., a script), not instructions
+		if len(heredocs) > 0 {
+			if heredocs[0].stripTabs {
+				line = strings.TrimLeft(line, "\t")
+			}
+			if line == heredocs[0].word {
+				heredocs = heredocs[1:]
+			}
+			continue
+		}
+
+		// Parser directives are only allowed before anything else
+		if directives {
+			if match := directiveRegex.FindStringSubmatch(trimmed); match != nil {
+				if strings.ToLower(match[1]) == "escape" {
+					escape = strings.TrimSpace(match[2])
+				}
+				continue
+			}
+			directives = false
+		}
+
+		// Comments and empty lines are skipped, even inside a continuation
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if current == "" {
+			start = lineno
+		}
+		if strings.HasSuffix(trimmed, escape) {
+			current += strings.TrimSuffix(trimmed, escape) + " "
+			continue
+		}
+		current += trimmed
+		heredocs = append(heredocs, d.add(current, start)...)
+		current = ""
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if current != "" {
+		d.add(current, start)
+	}
+	return &d, nil
+}
+
+// add records one instruction and updates stages. It returns the heredocs
+// that follow the instruction, in order.
+func (d *Dockerfile) add(line string, lineno int) []heredoc {
+	parts := strings.SplitN(line, " ", 2)
+	instruction := Instruction{Command: strings.ToUpper(parts[0]), Line: lineno}
+	if len(parts) > 1 {
+		instruction.Args = strings.TrimSpace(parts[1])
+	}
+	d.Instructions = append(d.Instructions, instruction)
+
+	switch instruction.Command {
+	case "FROM":
+		d.Stages = append(d.Stages, parseFrom(instruction, len(d.Stages)))
+	case "ARG":
+		// Global arguments are only in scope for FROM, and an argument
+		// of a stage only for the rest of the stage
+		scope := d.Args
+		if len(d.Stages) > 0 {
+			scope = d.Stages[len(d.Stages)-1].Args
+		}
+		for _, token := range tokenize(instruction.Args) {
+			pair := strings.SplitN(token, "=", 2)
+			// A default from an argument without one isn't known either
+			if len(pair) == 2 {
+				if value, ok := resolve(pair[1], scope); ok {
+					scope[pair[0]] = value
+				} else {
+					delete(scope, pair[0])
+				}
+			} else if value, ok := d.Args[pair[0]]; ok && len(d.Stages) > 0 {
+				scope[pair[0]] = value
+			}
+		}
+	case "LABEL":
+		if len(d.Stages) > 0 {
+			stage := d.Stages[len(d.Stages)-1]
+			stage.Labels = append(stage.Labels, parseLabels(instruction.Args, lineno)...)
+		}
+	}
+
+	heredocs := []heredoc{}
+	if heredocCommands[instruction.Command] {
+		for _, match := range heredocRegex.FindAllStringSubmatch(instruction.Args, -1) {
+			if match[2] == match[4] {
+				heredocs = append(heredocs, heredoc{word: match[3], stripTabs: match[1] == "-"})
+			}
+		}
+	}
+	return heredocs
+}
+
+// parseFrom parses FROM [--platform=<platform>] <image> [AS <name>]
+func parseFrom(instruction Instruction, index int) *Stage {
+	stage := Stage{Index: index, Line: instruction.Line, Args: map[string]string{}}
+	fields := strings.Fields(instruction.Args)
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+		switch {
+		case strings.HasPrefix(field, "--platform="):
+			stage.Platform = strings.TrimPrefix(field, "--platform=")
+		case strings.HasPrefix(field, "--"):
+			continue
+		case strings.EqualFold(field, "as") && i+1 < len(fields):
+			stage.Name = fields[i+1]
+			i++
+		case stage.Image == "":
+			stage.Image = field
+		}
+	}
+	return &stage
+}
+
+// parseLabels parses key=value pairs with optional quoting, and the legacy
+// "LABEL key value" form
+func parseLabels(args string, lineno int) []Label {
+	labels := []Label{}
+	tokens := tokenize(args)
+	if len(tokens) > 1 && !strings.Contains(tokens[0], "=") {
+		return append(labels, Label{Key: tokens[0], Value: strings.Join(tokens[1:], " "), Line: lineno})
+	}
+	for _, token := range tokens {
+		pair := strings.SplitN(token, "=", 2)
+		label := Label{Key: pair[0], Line: lineno}
+		if len(pair) == 2 {
+			label.Value = pair[1]
+		}
+		labels = append(labels, label)
+	}
+	return labels
+}
+
+// tokenize splits on whitespace, removing quotes and honoring escapes
+func tokenize(args string) []string {
+	tokens := []string{}
+	var token strings.Builder
+	var quote rune
+	inToken := false
+	escaped := false
+
+	for _, char := range args {
+		switch {
+		case escaped:
+			token.WriteRune(char)
+			escaped = false
+		case char == '\\' && quote != '\'':
+			escaped = true
+			inToken = true
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			} else {
+				token.WriteRune(char)
+			}
+		case char == '"' || char == '\'':
+			quote = char
+			inToken = true
+		case char == ' ' || char == '\t':
+			if inToken {
+				tokens = append(tokens, token.String())
+				token.Reset()
+				inToken = false
+			}
+		default:
+			token.WriteRune(char)
+			inToken = true
+		}
+	}
+	if inToken {
+		tokens = append(tokens, token.String())
+	}
+	return tokens
+}
+
+// Final returns the last build stage, the one that becomes the image
+func (d *Dockerfile) Final() *Stage {
+	if len(d.Stages) == 0 {
+		return nil
+	}
+	return d.Stages[len(d.Stages)-1]
+}
+
+// Stage looks up a previous build stage by name or index
+func (d *Dockerfile) Stage(ref string, before int) *Stage {
+	for _, stage := range d.Stages[:before] {
+		if stage.Name != "" && strings.EqualFold(stage.Name, ref) {
+			return stage
+		}
+		if fmt.Sprint(stage.Index) == ref {
+			return stage
+		}
+	}
+	return nil
+}
+
+// Inherited returns the stages a stage builds on, itself first. Labels
+// from all of them end up in the image built from the stage.
+func (d *Dockerfile) Inherited(stage *Stage) []*Stage {
+	stages := []*Stage{}
+	for stage != nil {
+		stages = append(stages, stage)
+		stage = d.Stage(stage.Image, stage.Index)
+	}
+	return stages
+}
+
+// Expand substitutes $VAR and ${VAR} with the defaults of global build
+// arguments, as in a FROM instruction. It is false if a variable doesn't
+// have a default, and the value is only known at build time.
+func (d *Dockerfile) Expand(value string) (string, bool) {
+	return resolve(value, d.Args)
+}
+
+// Expand substitutes $VAR and ${VAR} with the defaults of the build
+// arguments of a stage, as in its LABEL instructions. It is false if a
+// variable doesn't have a default.
+func (s *Stage) Expand(value string) (string, bool) {
+	return resolve(value, s.Args)
+}
+
+// resolve substitutes variables with build argument defaults, and is false
+// if one of them doesn't have a default
+func resolve(value string, args map[string]string) (string, bool) {
+	resolved := true
+	expanded := os.Expand(value, func(name string) string {
+		// Support ${VAR:-default} for unset arguments
+		if parts := strings.SplitN(name, ":-", 2); len(parts) == 2 {
+			if v := args[parts[0]]; v != "" {
+				return v
+			}
+			return parts[1]
+		}
+		value, ok := args[name]
+		if !ok {
+			resolved = false
+		}
+		return value
+	})
+	return expanded, resolved
+}
@@ -0,0 +1,152 @@
+package dockerfile
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseInstructions(t *testing.T) {
+	tests := []struct {
+		name         string
+		content      string
+		instructions []Instruction
+	}{
+		{
+			name:    "continuation with comments",
+			content: "FROM ubuntu\nRUN apt-get update && \\\n    # a comment\n    apt-get install -y gcc\nLABEL a=b",
+			instructions: []Instruction{
+				{Command: "FROM", Args: "ubuntu", Line: 1},
+				{Command: "RUN", Args: "apt-get update &&  apt-get install -y gcc", Line: 2},
+				{Command: "LABEL", Args: "a=b", Line: 5},
+			},
+		},
+		{
+			name:    "escape directive",
+			content: "# escape=`\nFROM windows\nRUN dir `\n  c:\\\nLABEL a=b",
+			instructions: []Instruction{
+				{Command: "FROM", Args: "windows", Line: 2},
+				{Command: "RUN", Args: "dir  c:\\", Line: 3},
+				{Command: "LABEL", Args: "a=b", Line: 5},
+			},
+		},
+		{
+			name:    "directive after an instruction is a comment",
+			content: "FROM ubuntu\n# escape=`\nRUN ls \\\n  -l",
+			instructions: []Instruction{
+				{Command: "FROM", Args: "ubuntu", Line: 1},
+				{Command: "RUN", Args: "ls  -l", Line: 3},
+			},
+		},
+		{
+			name:    "heredoc",
+			content: "FROM ubuntu\nRUN <<EOF\nLABEL org.supercontainers.mpi=nope\nEOF\nLABEL a=b",
+			instructions: []Instruction{
+				{Command: "FROM", Args: "ubuntu", Line: 1},
+				{Command: "RUN", Args: "<<EOF", Line: 2},
+				{Command: "LABEL", Args: "a=b", Line: 5},
+			},
+		},
+		{
+			name:    "heredocs with tabs and quotes",
+			content: "FROM ubuntu\nCOPY <<-\"A\" <<B /opt/\n\tLABEL x=y\n\tA\nFROM nope\nB\nRUN cat <<'EOF' > /x\n  EOF\nEOF\nLABEL a=b",
+			instructions: []Instruction{
+				{Command: "FROM", Args: "ubuntu", Line: 1},
+				{Command: "COPY", Args: "<<-\"A\" <<B /opt/", Line: 2},
+				{Command: "RUN", Args: "cat <<'EOF' > /x", Line: 7},
+				{Command: "LABEL", Args: "a=b", Line: 10},
+			},
+		},
+		{
+			name:    "shift in a command isn't a heredoc",
+			content: "FROM ubuntu\nRUN echo $((1<<2))\nLABEL a=b",
+			instructions: []Instruction{
+				{Command: "FROM", Args: "ubuntu", Line: 1},
+				{Command: "RUN", Args: "echo $((1<<2))", Line: 2},
+				{Command: "LABEL", Args: "a=b", Line: 3},
+			},
+		},
+	}
+	for _, test := range tests {
+		d, err := Parse(strings.NewReader(test.content))
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(d.Instructions, test.instructions) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.instructions, d.Instructions)
+		}
+	}
+}
+
+func TestParseStages(t *testing.T) {
+	d, err := Parse(strings.NewReader(`FROM --platform=linux/amd64 ubuntu:22.04 AS build
+LABEL org.supercontainers.mpi=openmpi "org.supercontainers.gpu"="cuda"
+FROM build
+LABEL description "a legacy label"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Stages) != 2 {
+		t.Fatalf("expected 2 stages, got %d", len(d.Stages))
+	}
+	build, final := d.Stages[0], d.Final()
+	if build.Name != "build" || build.Image != "ubuntu:22.04" || build.Platform != "linux/amd64" {
+		t.Errorf("expected stage build from ubuntu:22.04 for linux/amd64, got %+v", build)
+	}
+	labels := []Label{{Key: "org.supercontainers.mpi", Value: "openmpi", Line: 2}, {Key: "org.supercontainers.gpu", Value: "cuda", Line: 2}}
+	if !reflect.DeepEqual(build.Labels, labels) {
+		t.Errorf("expected labels %v, got %v", labels, build.Labels)
+	}
+	if want := []Label{{Key: "description", Value: "a legacy label", Line: 4}}; !reflect.DeepEqual(final.Labels, want) {
+		t.Errorf("expected labels %v, got %v", want, final.Labels)
+	}
+	if inherited := d.Inherited(final); len(inherited) != 2 || inherited[1] != build {
+		t.Errorf("expected the final stage to inherit from build, got %v", inherited)
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	d, err := Parse(strings.NewReader(`ARG BASE=ubuntu VERSION=22.04
+ARG TARGET
+FROM ${BASE}:${VERSION}
+ARG VERSION
+ARG GLIBC=2.35 MPI="open mpi"
+ARG TARGET
+ARG ARCH=${TARGET:-x86_64}
+FROM ${BASE}
+ARG BASE
+ARG LIBC=$GLIBC
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		args  map[string]string
+		value string
+		want  string
+		ok    bool
+	}{
+		{name: "global", args: d.Args, value: "${BASE}:$VERSION", want: "ubuntu:22.04", ok: true},
+		{name: "global without a default", args: d.Args, value: "$TARGET", want: "", ok: false},
+		{name: "global declared again", args: d.Stages[0].Args, value: "$VERSION", want: "22.04", ok: true},
+		{name: "global not declared in the stage", args: d.Stages[0].Args, value: "$BASE", want: "", ok: false},
+		{name: "stage", args: d.Stages[0].Args, value: "${GLIBC} $MPI", want: "2.35 open mpi", ok: true},
+		{name: "stage without a default", args: d.Stages[0].Args, value: "$TARGET", want: "", ok: false},
+		{name: "default of an unset argument", args: d.Stages[0].Args, value: "$ARCH", want: "x86_64", ok: true},
+		{name: "literal", args: d.Stages[0].Args, value: "zen2", want: "zen2", ok: true},
+		{name: "default from an argument of another stage", args: d.Stages[1].Args, value: "$LIBC", want: "", ok: false},
+		{name: "global declared again in the next stage", args: d.Stages[1].Args, value: "$BASE", want: "ubuntu", ok: true},
+	}
+	for _, test := range tests {
+		value, ok := resolve(test.value, test.args)
+		if value != test.want || ok != test.ok {
+			t.Errorf("%s: expected %q (%v), got %q (%v)", test.name, test.want, test.ok, value, ok)
+		}
+	}
+	if d.Stages[0].Image != "${BASE}:${VERSION}" {
+		t.Errorf("expected the image to be kept as written, got %s", d.Stages[0].Image)
+	}
+}
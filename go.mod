@@ -1,9 +1,11 @@
 module github.com/vsoch/containerspec
 
-go 1.13
+go 1.25
 
 require (
-	github.com/DataDrake/cli-ng/v2 v2.0.2 // indirect
-	github.com/archspec/archspec-go v0.0.0-20200618083206-89a4a3fc5891 // indirect
-	github.com/scylladb/go-set v1.0.2 // indirect
+	github.com/DataDrake/cli-ng/v2 v2.0.2
+	github.com/klauspost/compress v1.18.0
+	github.com/ulikunitz/xz v0.5.12
+	golang.org/x/arch v0.23.0
+	gopkg.in/yaml.v3 v3.0.1
 )
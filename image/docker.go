@@ -0,0 +1,238 @@
+package image
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// MediaTypeDockerLayer is an uncompressed layer, which is what docker save writes
+const MediaTypeDockerLayer = "application/vnd.docker.image.rootfs.diff.tar"
+
+// archiveManifest is one entry of manifest.json in a docker save archive
+type archiveManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// archiveEntry is where a file's content starts in the archive
+type archiveEntry struct {
+	offset int64
+	size   int64
+}
+
+// maxArchiveLinks limits how many links are followed to find a file
+const maxArchiveLinks = 10
+
+// Archive is an image read from a docker save tarball. Both the legacy
+// layout (<id>/layer.tar) and the OCI layout newer versions of docker write
+// are described by manifest.json, so we only rely on that.
+type Archive struct {
+	Path     string
+	RepoTags []string
+	entries  map[string]archiveEntry
+	layers   []string
+	config   *Config
+}
+
+// OpenArchive reads the manifest and config for an image in a docker save
+// archive. When there is more than one image, ref selects one by its
+// repository tag (an empty ref matches any).
+func OpenArchive(filename, ref string, platform Platform) (*Archive, error) {
+	entries, err := indexArchive(filename)
+	if err != nil {
+		return nil, err
+	}
+	archive := Archive{Path: filename, entries: entries}
+
+	var manifests []archiveManifest
+	if err := archive.readJSON("manifest.json", &manifests); err != nil {
+		return nil, fmt.Errorf("%s is not a docker archive: %s", filename, err)
+	}
+
+	found := []string{}
+	for _, manifest := range manifests {
+		if ref != "" && !matchesTag(manifest.RepoTags, ref) {
+			continue
+		}
+		var config imageConfig
+		if err := archive.readJSON(manifest.Config, &config); err != nil {
+			return nil, err
+		}
+		candidate := Platform{OS: config.OS, Architecture: config.Architecture, Variant: config.Variant}
+		if config.Architecture != "" && !platform.Matches(candidate) {
+			found = append(found, candidate.String())
+			continue
+		}
+		for _, layer := range manifest.Layers {
+			if err := archive.checkLayer(layer); err != nil {
+				return nil, fmt.Errorf("%s: %s", filename, err)
+			}
+		}
+		archive.RepoTags = manifest.RepoTags
+		archive.layers = manifest.Layers
+		archive.config = config.toConfig(map[string]string{})
+		return &archive, nil
+	}
+	if len(found) > 0 {
+		return nil, fmt.Errorf("%s: no image for platform %s, found %s", filename, platform, strings.Join(found, ", "))
+	}
+	return nil, fmt.Errorf("%s: no image %s", filename, ref)
+}
+
+// Config returns the image configuration
+func (a *Archive) Config() *Config {
+	return a.config
+}
+
+// Layers returns the layers listed in manifest.json. Layers in the OCI
+// layout are named by their digest, and checked against it when read.
+func (a *Archive) Layers() []Layer {
+	layers := []Layer{}
+	for _, name := range a.layers {
+		entry := a.entries[path.Clean(name)]
+		digest := layerDigest(name)
+		layers = append(layers, Layer{
+			MediaType: MediaTypeDockerLayer,
+			Digest:    digest,
+			Size:      entry.size,
+			open: func() (io.ReadCloser, error) {
+				reader, err := a.openEntry(entry)
+				if err != nil {
+					return nil, err
+				}
+				return newVerifier(reader, digest), nil
+			},
+		})
+	}
+	return layers
+}
+
+// checkLayer makes sure a layer in manifest.json is in the archive, and
+// that a layer named by its digest has a valid one
+func (a *Archive) checkLayer(name string) error {
+	if _, ok := a.entries[path.Clean(name)]; !ok {
+		return fmt.Errorf("layer %s not found in archive", name)
+	}
+	if strings.HasPrefix(path.Clean(name), "blobs/") && !digestRegex.MatchString(layerDigest(name)) {
+		return fmt.Errorf("layer %s is not named by a digest", name)
+	}
+	return nil
+}
+
+// layerDigest is the digest of a layer in the OCI layout (blobs/sha256/),
+// or empty for a legacy layer (<id>/layer.tar)
+func layerDigest(name string) string {
+	if dir, encoded := path.Split(path.Clean(name)); dir == "blobs/sha256/" {
+		return "sha256:" + encoded
+	}
+	return ""
+}
+
+// readJSON unmarshals a file from the archive
+func (a *Archive) readJSON(name string, v interface{}) error {
+	entry, ok := a.entries[path.Clean(name)]
+	if !ok {
+		return fmt.Errorf("%s not found in archive", name)
+	}
+	reader, err := a.openEntry(entry)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return json.NewDecoder(reader).Decode(v)
+}
+
+// sectionReader closes the archive when the reader is done
+type sectionReader struct {
+	*io.SectionReader
+	file *os.File
+}
+
+func (s sectionReader) Close() error {
+	return s.file.Close()
+}
+
+// openEntry reads one file from the archive without extracting it
+func (a *Archive) openEntry(entry archiveEntry) (io.ReadCloser, error) {
+	file, err := os.Open(a.Path)
+	if err != nil {
+		return nil, err
+	}
+	return sectionReader{io.NewSectionReader(file, entry.offset, entry.size), file}, nil
+}
+
+// indexArchive records where each regular file starts in a tar archive,
+// so we can read files later without scanning it again. Links (e.g., a
+// layer docker save wrote before, as <id>/layer.tar -> ../<other>/layer.tar)
+// point to the file they link to.
+func indexArchive(filename string) (map[string]archiveEntry, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := map[string]archiveEntry{}
+	links := map[string]string{}
+	reader := tar.NewReader(file)
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", filename, err)
+		}
+		name := path.Clean(header.Name)
+		switch header.Typeflag {
+		case tar.TypeSymlink:
+			links[name] = path.Join(path.Dir(name), header.Linkname)
+			continue
+		case tar.TypeLink:
+			links[name] = path.Clean(header.Linkname)
+			continue
+		case tar.TypeReg:
+		default:
+			continue
+		}
+		// The tar reader doesn't read ahead, so the file is at the content
+		offset, err := file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		entries[name] = archiveEntry{offset: offset, size: header.Size}
+	}
+
+	// A link to a missing file, or too many links, is left out
+	for name, target := range links {
+		for i := 0; i < maxArchiveLinks; i++ {
+			if entry, ok := entries[target]; ok {
+				entries[name] = entry
+				break
+			}
+			next, ok := links[target]
+			if !ok {
+				break
+			}
+			target = next
+		}
+	}
+	return entries, nil
+}
+
+// matchesTag compares a ref to repository tags, where name alone means latest
+func matchesTag(tags []string, ref string) bool {
+	for _, tag := range tags {
+		if tag == ref || tag == ref+":latest" || strings.TrimPrefix(tag, "docker.io/library/") == ref {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,231 @@
+package image
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// archiveFile is a file in a test docker save archive, or a link to one
+type archiveFile struct {
+	name     string
+	content  string
+	symlink  string
+	hardlink string
+}
+
+// writeArchive writes files to a docker save archive, with a manifest.json
+// for the manifests given
+func writeArchive(t *testing.T, manifests []archiveManifest, files ...archiveFile) string {
+	t.Helper()
+	content, err := json.Marshal(manifests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files = append(files, archiveFile{name: "manifest.json", content: string(content)})
+
+	path := filepath.Join(t.TempDir(), "image.tar")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	writer := tar.NewWriter(file)
+	for _, f := range files {
+		header := &tar.Header{Name: f.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(f.content))}
+		if f.symlink != "" {
+			header.Typeflag, header.Linkname, header.Size = tar.TypeSymlink, f.symlink, 0
+		} else if f.hardlink != "" {
+			header.Typeflag, header.Linkname, header.Size = tar.TypeLink, f.hardlink, 0
+		}
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := writer.Write([]byte(f.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// archiveConfig is an image configuration for a platform
+func archiveConfig(name, arch string) archiveFile {
+	return archiveFile{name: name, content: `{"os": "linux", "architecture": "` + arch + `", "config": {"Labels": {"org.supercontainers.target": "` + arch + `"}}}`}
+}
+
+// sha256Hex is the encoded sha256 digest of content
+func sha256Hex(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// readLayers reads the content of every layer
+func readLayers(layers []Layer) ([]string, error) {
+	contents := []string{}
+	for _, layer := range layers {
+		reader, err := layer.Open()
+		if err != nil {
+			return nil, err
+		}
+		content, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return nil, err
+		}
+		contents = append(contents, string(content))
+	}
+	return contents, nil
+}
+
+func TestOpenArchiveLegacyLinks(t *testing.T) {
+	// docker save writes a layer it has already written as a symlink, and
+	// other tools write hard links
+	path := writeArchive(t,
+		[]archiveManifest{{Config: "config.json", RepoTags: []string{"app:latest"}, Layers: []string{"a/layer.tar", "b/layer.tar", "c/layer.tar", "d/layer.tar"}}},
+		archiveConfig("config.json", "amd64"),
+		archiveFile{name: "a/layer.tar", content: "first"},
+		archiveFile{name: "b/layer.tar", symlink: "../a/layer.tar"},
+		archiveFile{name: "c/layer.tar", hardlink: "b/layer.tar"},
+		archiveFile{name: "d/layer.tar", content: "last"},
+	)
+	archive, err := OpenArchive(path, "app", Platform{OS: "linux", Architecture: "amd64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	layers := archive.Layers()
+	contents, err := readLayers(layers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"first", "first", "first", "last"}; strings.Join(contents, ",") != strings.Join(want, ",") {
+		t.Errorf("expected layers %v, got %v", want, contents)
+	}
+	for i, layer := range layers {
+		if layer.Size != int64(len(contents[i])) || layer.Digest != "" {
+			t.Errorf("layer %d: expected size %d and no digest, got %d and %q", i, len(contents[i]), layer.Size, layer.Digest)
+		}
+	}
+	if archive.Config().Label("org.supercontainers.target") != "amd64" {
+		t.Errorf("expected the config of the image, got %v", archive.Config().Labels)
+	}
+}
+
+func TestOpenArchiveBlobs(t *testing.T) {
+	digest := sha256Hex("layer")
+	path := writeArchive(t,
+		[]archiveManifest{{Config: "blobs/sha256/config", Layers: []string{"blobs/sha256/" + digest, "blobs/sha256/" + sha256Hex("other")}}},
+		archiveConfig("blobs/sha256/config", "arm64"),
+		archiveFile{name: "blobs/sha256/" + digest, content: "layer"},
+		archiveFile{name: "blobs/sha256/" + sha256Hex("other"), content: "changed"},
+	)
+	archive, err := OpenArchive(path, "", Platform{OS: "linux", Architecture: "arm64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	layers := archive.Layers()
+	if layers[0].Digest != "sha256:"+digest {
+		t.Errorf("expected digest sha256:%s, got %s", digest, layers[0].Digest)
+	}
+	if _, err := readLayers(layers[:1]); err != nil {
+		t.Errorf("expected the layer to match its digest, got %s", err)
+	}
+	if _, err := readLayers(layers[1:]); err == nil || !strings.Contains(err.Error(), "digest mismatch") {
+		t.Errorf("expected a digest mismatch, got %v", err)
+	}
+}
+
+func TestOpenArchiveErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest archiveManifest
+		files    []archiveFile
+		err      string
+	}{
+		{
+			name:     "missing layer",
+			manifest: archiveManifest{Config: "config.json", Layers: []string{"a/layer.tar", "b/layer.tar"}},
+			files:    []archiveFile{{name: "a/layer.tar", content: "a"}},
+			err:      "layer b/layer.tar not found",
+		},
+		{
+			name:     "symlink to a missing layer",
+			manifest: archiveManifest{Config: "config.json", Layers: []string{"a/layer.tar"}},
+			files:    []archiveFile{{name: "a/layer.tar", symlink: "../b/layer.tar"}},
+			err:      "layer a/layer.tar not found",
+		},
+		{
+			name:     "symlink loop",
+			manifest: archiveManifest{Config: "config.json", Layers: []string{"a/layer.tar"}},
+			files:    []archiveFile{{name: "a/layer.tar", symlink: "../b/layer.tar"}, {name: "b/layer.tar", symlink: "../a/layer.tar"}},
+			err:      "layer a/layer.tar not found",
+		},
+		{
+			name:     "blob not named by a digest",
+			manifest: archiveManifest{Config: "config.json", Layers: []string{"blobs/sha256/../layer"}},
+			files:    []archiveFile{{name: "blobs/layer", content: "a"}},
+			err:      "is not named by a digest",
+		},
+		{
+			name:     "missing config",
+			manifest: archiveManifest{Config: "missing.json"},
+			err:      "missing.json not found",
+		},
+	}
+	for _, test := range tests {
+		files := append([]archiveFile{archiveConfig("config.json", "amd64")}, test.files...)
+		_, err := OpenArchive(writeArchive(t, []archiveManifest{test.manifest}, files...), "", Platform{OS: "linux", Architecture: "amd64"})
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected an error with %q, got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestOpenArchiveSelect(t *testing.T) {
+	path := writeArchive(t,
+		[]archiveManifest{
+			{Config: "amd64.json", RepoTags: []string{"app:latest"}, Layers: []string{}},
+			{Config: "arm64.json", RepoTags: []string{"app:arm64"}, Layers: []string{}},
+		},
+		archiveConfig("amd64.json", "amd64"),
+		archiveConfig("arm64.json", "arm64"),
+	)
+	amd64, arm64 := Platform{OS: "linux", Architecture: "amd64"}, Platform{OS: "linux", Architecture: "arm64"}
+	tests := []struct {
+		ref      string
+		platform Platform
+		arch     string
+		err      string
+	}{
+		{ref: "", platform: amd64, arch: "amd64"},
+		{ref: "app", platform: amd64, arch: "amd64"},
+		{ref: "app:arm64", platform: arm64, arch: "arm64"},
+		{ref: "", platform: arm64, arch: "arm64"},
+		{ref: "app:latest", platform: arm64, err: "no image for platform linux/arm64, found linux/amd64"},
+		{ref: "other", platform: amd64, err: "no image other"},
+	}
+	for _, test := range tests {
+		archive, err := OpenArchive(path, test.ref, test.platform)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s %s: expected an error with %q, got %v", test.ref, test.platform, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %s: %s", test.ref, test.platform, err)
+			continue
+		}
+		if archive.Config().Architecture != test.arch {
+			t.Errorf("%s %s: expected %s, got %s", test.ref, test.platform, test.arch, archive.Config().Architecture)
+		}
+	}
+}
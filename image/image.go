@@ -0,0 +1,179 @@
+package image
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// Images are read from disk, without a container daemon or registry.
+// Every format exposes the same configuration and layers.
+
+// Image is a container image on disk
+type Image interface {
+
+	// Config returns the image configuration for the selected platform
+	Config() *Config
+
+	// Layers returns the file system layers, base layer first
+	Layers() []Layer
+}
+
+// Config is the part of an image configuration we need to reason about
+// compatibility, with labels from the config and annotations from the manifest
+type Config struct {
+	OS           string            `json:"os,omitempty"`
+	Architecture string            `json:"architecture,omitempty"`
+	Variant      string            `json:"variant,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	Env          []string          `json:"env,omitempty"`
+	Entrypoint   []string          `json:"entrypoint,omitempty"`
+	Cmd          []string          `json:"cmd,omitempty"`
+	WorkingDir   string            `json:"working_dir,omitempty"`
+}
+
+// Label returns a label, falling back to a manifest annotation of the same name
+func (c *Config) Label(key string) string {
+	if value, ok := c.Labels[key]; ok {
+		return value
+	}
+	return c.Annotations[key]
+}
+
+// Layer is a file system layer, possibly compressed according to MediaType
+type Layer struct {
+	MediaType string
+	Digest    string
+	Size      int64
+	open      func() (io.ReadCloser, error)
+}
+
+// NewLayer returns a layer whose content, as stored, comes from open
+func NewLayer(mediaType, digest string, size int64, open func() (io.ReadCloser, error)) Layer {
+	return Layer{MediaType: mediaType, Digest: digest, Size: size, open: open}
+}
+
+// Open returns a reader for the layer content as stored (not decompressed)
+func (l Layer) Open() (io.ReadCloser, error) {
+	if l.open == nil {
+		return nil, fmt.Errorf("layer %s has no content", l.Digest)
+	}
+	return l.open()
+}
+
+// Platform identifies the os, architecture and variant an image is built for
+type Platform struct {
+	OS           string   `json:"os"`
+	Architecture string   `json:"architecture"`
+	Variant      string   `json:"variant,omitempty"`
+	OSVersion    string   `json:"os.version,omitempty"`
+	Features     []string `json:"os.features,omitempty"`
+
+	// CPUFeatures is reserved by OCI for required CPU features (e.g., avx2)
+	CPUFeatures []string `json:"features,omitempty"`
+}
+
+// String formats the platform as os/arch[/variant]
+func (p Platform) String() string {
+	parts := []string{p.OS, p.Architecture}
+	if p.Variant != "" {
+		parts = append(parts, p.Variant)
+	}
+	return strings.Join(parts, "/")
+}
+
+// ParsePlatform parses os/arch[/variant], e.g., linux/amd64/v3
+func ParsePlatform(value string) (Platform, error) {
+	parts := strings.Split(value, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Platform{}, fmt.Errorf("%s is not a platform in the format os/arch[/variant]", value)
+	}
+	platform := Platform{OS: parts[0], Architecture: normalizeArchitecture(parts[1])}
+	if len(parts) == 3 {
+		platform.Variant = parts[2]
+	}
+	return platform, nil
+}
+
+// DefaultPlatform is the platform of this host
+func DefaultPlatform() Platform {
+	return Platform{OS: runtime.GOOS, Architecture: runtime.GOARCH}
+}
+
+// Matches determines if an image for other can be used when asking for p.
+// An empty variant matches any variant.
+func (p Platform) Matches(other Platform) bool {
+	if p.OS != "" && other.OS != "" && p.OS != other.OS {
+		return false
+	}
+	if normalizeArchitecture(p.Architecture) != normalizeArchitecture(other.Architecture) {
+		return false
+	}
+	return p.Variant == "" || p.Variant == normalizeVariant(other)
+}
+
+// normalizeArchitecture uses the names from GOARCH, as OCI does
+func normalizeArchitecture(arch string) string {
+	switch arch {
+	case "x86_64", "x86-64":
+		return "amd64"
+	case "aarch64":
+		return "arm64"
+	case "i386", "i686":
+		return "386"
+	}
+	return arch
+}
+
+// normalizeVariant fills in the default variant arm64 images omit
+func normalizeVariant(p Platform) string {
+	if p.Variant == "" && normalizeArchitecture(p.Architecture) == "arm64" {
+		return "v8"
+	}
+	return p.Variant
+}
+
+// Open reads an image from disk, detecting the format from the path.
+// A ref (e.g., a tag) selects an image when the path holds more than one.
+func Open(path, ref string, platform Platform) (Image, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return OpenLayout(path, ref, platform)
+	}
+
+	magic, err := readMagic(path)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(magic) >= 41 && string(magic[32:41]) == sifMagic:
+		return OpenSIF(path)
+	case len(magic) >= 262 && string(magic[257:262]) == "ustar":
+		return OpenArchive(path, ref, platform)
+	case len(magic) >= 2 && magic[0] == 0x1f && magic[1] == 0x8b:
+		return nil, fmt.Errorf("%s is compressed, decompress the archive first", path)
+	}
+	return nil, fmt.Errorf("%s is not a known image format", path)
+}
+
+// readMagic reads enough of a file to recognize its format
+func readMagic(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	magic := make([]byte, 512)
+	n, err := io.ReadFull(file, magic)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return magic[:n], nil
+}
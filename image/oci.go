@@ -0,0 +1,332 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Media types we need to tell apart
+const (
+	MediaTypeIndex          = "application/vnd.oci.image.index.v1+json"
+	MediaTypeManifest       = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeDockerList     = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// A digest is algorithm:encoded, and is the path of a blob in the layout
+var digestRegex = regexp.MustCompile(`^[a-z0-9]+(?:[+._-][a-z0-9]+)*:[a-zA-Z0-9=_-]+$`)
+
+// Descriptor points to a blob in the layout
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Platform    *Platform         `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Index is an OCI image index (index.json, or a nested multi-arch index)
+type Index struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	Manifests     []Descriptor      `json:"manifests"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// Manifest is an OCI image manifest
+type Manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	Config        Descriptor        `json:"config"`
+	Layers        []Descriptor      `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// imageConfig is the image configuration blob
+type imageConfig struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant"`
+	Config       struct {
+		Labels     map[string]string `json:"Labels"`
+		Env        []string          `json:"Env"`
+		Entrypoint []string          `json:"Entrypoint"`
+		Cmd        []string          `json:"Cmd"`
+		WorkingDir string            `json:"WorkingDir"`
+	} `json:"config"`
+}
+
+// toConfig converts the configuration blob and manifest annotations
+func (c imageConfig) toConfig(annotations map[string]string) *Config {
+	return &Config{
+		OS:           c.OS,
+		Architecture: c.Architecture,
+		Variant:      c.Variant,
+		Labels:       c.Config.Labels,
+		Annotations:  annotations,
+		Env:          c.Config.Env,
+		Entrypoint:   c.Config.Entrypoint,
+		Cmd:          c.Config.Cmd,
+		WorkingDir:   c.Config.WorkingDir,
+	}
+}
+
+// Layout is an image read from an OCI image layout directory
+type Layout struct {
+	Path       string
+	Descriptor Descriptor
+	Manifest   Manifest
+	config     *Config
+}
+
+// OpenLayout reads the manifest and config for a platform from an OCI image
+// layout. When the index has more than one image, ref selects one by its
+// org.opencontainers.image.ref.name annotation (an empty ref matches any).
+func OpenLayout(path, ref string, platform Platform) (*Layout, error) {
+	index, err := ReadIndex(path)
+	if err != nil {
+		return nil, err
+	}
+	descriptor, err := selectDescriptor(path, index.Manifests, ref, platform)
+	if err != nil {
+		return nil, err
+	}
+	return OpenManifest(path, descriptor)
+}
+
+// OpenManifest reads the manifest and config of an image in an OCI image
+// layout, e.g., one chosen from Manifests
+func OpenManifest(path string, descriptor Descriptor) (*Layout, error) {
+	layout := Layout{Path: path, Descriptor: descriptor}
+	if err := readJSONBlob(path, descriptor, &layout.Manifest); err != nil {
+		return nil, err
+	}
+	var config imageConfig
+	if err := readJSONBlob(path, layout.Manifest.Config, &config); err != nil {
+		return nil, err
+	}
+
+	// Annotations on the descriptor in the index are kept if the manifest
+	// doesn't repeat them
+	annotations := map[string]string{}
+	for key, value := range descriptor.Annotations {
+		annotations[key] = value
+	}
+	for key, value := range layout.Manifest.Annotations {
+		annotations[key] = value
+	}
+	layout.config = config.toConfig(annotations)
+	return &layout, nil
+}
+
+// ReadIndex reads index.json from an OCI image layout
+func ReadIndex(path string) (*Index, error) {
+	content, err := os.ReadFile(filepath.Join(path, "oci-layout"))
+	if err != nil {
+		return nil, fmt.Errorf("%s is not an OCI image layout: %s", path, err)
+	}
+	var marker struct {
+		Version string `json:"imageLayoutVersion"`
+	}
+	if err := json.Unmarshal(content, &marker); err != nil {
+		return nil, fmt.Errorf("%s: invalid oci-layout: %s", path, err)
+	}
+
+	var index Index
+	content, err = os.ReadFile(filepath.Join(path, "index.json"))
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &index); err != nil {
+		return nil, fmt.Errorf("%s: invalid index.json: %s", path, err)
+	}
+	return &index, nil
+}
+
+// ReadNestedIndex reads an index that index.json points to
+func ReadNestedIndex(path string, descriptor Descriptor) (*Index, error) {
+	var index Index
+	if err := readJSONBlob(path, descriptor, &index); err != nil {
+		return nil, err
+	}
+	return &index, nil
+}
+
+// Manifests lists the image manifests of an OCI image layout, descending
+// into nested (multi-arch) indexes. ref selects images by their
+// org.opencontainers.image.ref.name annotation (an empty ref matches any).
+func Manifests(path, ref string) ([]Descriptor, error) {
+	index, err := ReadIndex(path)
+	if err != nil {
+		return nil, err
+	}
+	manifests := []Descriptor{}
+	for _, descriptor := range index.Manifests {
+		if ref != "" && descriptor.Annotations["org.opencontainers.image.ref.name"] != ref {
+			continue
+		}
+		found, err := listManifests(path, descriptor)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, found...)
+	}
+	if len(manifests) == 0 && ref != "" {
+		return nil, fmt.Errorf("%s: no image %s", path, ref)
+	}
+	return manifests, nil
+}
+
+// listManifests returns a manifest, or the manifests of a nested index
+func listManifests(path string, descriptor Descriptor) ([]Descriptor, error) {
+	switch descriptor.MediaType {
+	case MediaTypeIndex, MediaTypeDockerList:
+		index, err := ReadNestedIndex(path, descriptor)
+		if err != nil {
+			return nil, err
+		}
+		manifests := []Descriptor{}
+		for _, nested := range index.Manifests {
+			found, err := listManifests(path, nested)
+			if err != nil {
+				return nil, err
+			}
+			manifests = append(manifests, found...)
+		}
+		return manifests, nil
+	case MediaTypeManifest, MediaTypeDockerManifest, "":
+		return []Descriptor{descriptor}, nil
+	}
+	return nil, nil
+}
+
+// Config returns the image configuration
+func (l *Layout) Config() *Config {
+	return l.config
+}
+
+// Layers returns the layers from the manifest
+func (l *Layout) Layers() []Layer {
+	layers := []Layer{}
+	for _, descriptor := range l.Manifest.Layers {
+		digest := descriptor.Digest
+		layers = append(layers, Layer{
+			MediaType: descriptor.MediaType,
+			Digest:    digest,
+			Size:      descriptor.Size,
+			open: func() (io.ReadCloser, error) {
+				blob, err := blobPath(l.Path, digest)
+				if err != nil {
+					return nil, err
+				}
+				file, err := os.Open(blob)
+				if err != nil {
+					return nil, err
+				}
+				return newVerifier(file, digest), nil
+			},
+		})
+	}
+	return layers
+}
+
+// selectDescriptor finds the image manifest for a ref and platform,
+// descending into nested indexes
+func selectDescriptor(path string, manifests []Descriptor, ref string, platform Platform) (Descriptor, error) {
+	for _, descriptor := range manifests {
+		if ref != "" && descriptor.Annotations["org.opencontainers.image.ref.name"] != ref {
+			continue
+		}
+		switch descriptor.MediaType {
+		case MediaTypeIndex, MediaTypeDockerList:
+			index, err := ReadNestedIndex(path, descriptor)
+			if err != nil {
+				return Descriptor{}, err
+			}
+			if found, err := selectDescriptor(path, index.Manifests, "", platform); err == nil {
+				return found, nil
+			}
+		case MediaTypeManifest, MediaTypeDockerManifest, "":
+			if descriptor.Platform == nil || platform.Matches(*descriptor.Platform) {
+				return descriptor, nil
+			}
+		}
+	}
+	if ref != "" {
+		return Descriptor{}, fmt.Errorf("%s: no image %s for platform %s", path, ref, platform)
+	}
+	return Descriptor{}, fmt.Errorf("%s: no image for platform %s", path, platform)
+}
+
+// blobPath returns the path to a blob, blobs/<algorithm>/<encoded>. The
+// digest comes from the layout, so it can't be trusted to stay inside it.
+func blobPath(path, digest string) (string, error) {
+	if !digestRegex.MatchString(digest) || strings.Contains(digest, "/") || strings.Contains(digest, "..") {
+		return "", fmt.Errorf("%s: %q is not a valid digest", path, digest)
+	}
+	parts := strings.SplitN(digest, ":", 2)
+	return filepath.Join(path, "blobs", parts[0], parts[1]), nil
+}
+
+// readJSONBlob reads, verifies and unmarshals a small blob
+func readJSONBlob(path string, descriptor Descriptor, v interface{}) error {
+	blob, err := blobPath(path, descriptor.Digest)
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(blob)
+	if err != nil {
+		return err
+	}
+	if err := verify(descriptor.Digest, content); err != nil {
+		return err
+	}
+	return json.Unmarshal(content, v)
+}
+
+// verify checks sha256 digests, other algorithms are trusted
+func verify(digest string, content []byte) error {
+	if !strings.HasPrefix(digest, "sha256:") {
+		return nil
+	}
+	sum := sha256.Sum256(content)
+	if actual := "sha256:" + hex.EncodeToString(sum[:]); actual != digest {
+		return fmt.Errorf("digest mismatch, expected %s but found %s", digest, actual)
+	}
+	return nil
+}
+
+// verifier checks the sha256 digest of a blob as it is read, and fails the
+// read that reaches the end if it doesn't match
+type verifier struct {
+	io.ReadCloser
+	digest string
+	hash   hash.Hash
+}
+
+// newVerifier verifies sha256 digests, other algorithms are trusted
+func newVerifier(reader io.ReadCloser, digest string) io.ReadCloser {
+	if !strings.HasPrefix(digest, "sha256:") {
+		return reader
+	}
+	return &verifier{ReadCloser: reader, digest: digest, hash: sha256.New()}
+}
+
+func (v *verifier) Read(p []byte) (int, error) {
+	n, err := v.ReadCloser.Read(p)
+	v.hash.Write(p[:n])
+	if err == io.EOF {
+		if actual := "sha256:" + hex.EncodeToString(v.hash.Sum(nil)); actual != v.digest {
+			return n, fmt.Errorf("digest mismatch, expected %s but found %s", v.digest, actual)
+		}
+	}
+	return n, err
+}
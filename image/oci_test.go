@@ -0,0 +1,227 @@
+package image
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testdata/layout has an index "latest" with a linux/amd64 and a
+// linux/arm64/v8 image, each with one layer holding etc/arch
+
+const testLayout = "testdata/layout"
+
+func TestOpenLayout(t *testing.T) {
+	img, err := Open(testLayout, "", Platform{OS: "linux", Architecture: "amd64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := img.(*Layout); !ok {
+		t.Fatalf("expected a layout, got %T", img)
+	}
+
+	config := img.Config()
+	if config.Architecture != "amd64" || config.OS != "linux" {
+		t.Errorf("expected linux/amd64, got %s/%s", config.OS, config.Architecture)
+	}
+	if got := config.Label("org.supercontainers.target"); got != "haswell" {
+		t.Errorf("expected target haswell, got %q", got)
+	}
+	if got := config.Label("org.opencontainers.image.title"); got != "app-amd64" {
+		t.Errorf("expected the manifest annotation for a missing label, got %q", got)
+	}
+	if got := config.Label("org.supercontainers.gpu"); got != "" {
+		t.Errorf("expected no gpu label, got %q", got)
+	}
+	if want := []string{"PATH=/usr/local/bin:/usr/bin", "APP=amd64"}; !reflect.DeepEqual(config.Env, want) {
+		t.Errorf("expected env %v, got %v", want, config.Env)
+	}
+	if want := []string{"/usr/bin/app"}; !reflect.DeepEqual(config.Entrypoint, want) {
+		t.Errorf("expected entrypoint %v, got %v", want, config.Entrypoint)
+	}
+	if want := []string{"--help"}; !reflect.DeepEqual(config.Cmd, want) {
+		t.Errorf("expected cmd %v, got %v", want, config.Cmd)
+	}
+	if config.WorkingDir != "/work" {
+		t.Errorf("expected working dir /work, got %q", config.WorkingDir)
+	}
+}
+
+func TestOpenLayoutPlatform(t *testing.T) {
+	tests := []struct {
+		platform string
+		ref      string
+		arch     string
+		err      bool
+	}{
+		{platform: "linux/amd64", arch: "amd64"},
+		{platform: "linux/arm64", arch: "arm64"},
+		{platform: "linux/aarch64/v8", arch: "arm64"},
+		{platform: "linux/arm64", ref: "latest", arch: "arm64"},
+		{platform: "linux/amd64/v3", err: true},
+		{platform: "linux/ppc64le", err: true},
+		{platform: "linux/amd64", ref: "missing", err: true},
+	}
+	for _, test := range tests {
+		platform, err := ParsePlatform(test.platform)
+		if err != nil {
+			t.Fatal(err)
+		}
+		layout, err := OpenLayout(testLayout, test.ref, platform)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s %s: expected an error, got %s", test.platform, test.ref, layout.Config().Architecture)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %s: %s", test.platform, test.ref, err)
+			continue
+		}
+		if got := layout.Config().Architecture; got != test.arch {
+			t.Errorf("%s %s: expected %s, got %s", test.platform, test.ref, test.arch, got)
+		}
+	}
+}
+
+func TestManifests(t *testing.T) {
+	manifests, err := Manifests(testLayout, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	platforms := []string{}
+	for _, descriptor := range manifests {
+		platforms = append(platforms, descriptor.Platform.String())
+	}
+	if want := []string{"linux/amd64", "linux/arm64/v8"}; !reflect.DeepEqual(platforms, want) {
+		t.Errorf("expected %v, got %v", want, platforms)
+	}
+}
+
+func TestLayoutLayers(t *testing.T) {
+	layout, err := OpenLayout(testLayout, "", Platform{OS: "linux", Architecture: "arm64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	layers := layout.Layers()
+	if len(layers) != 1 {
+		t.Fatalf("expected 1 layer, got %d", len(layers))
+	}
+	reader, err := layers[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	archive := tar.NewReader(reader)
+	header, err := archive.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Name != "etc/arch" || string(content) != "arm64\n" {
+		t.Errorf("expected etc/arch with arm64, got %s with %q", header.Name, content)
+	}
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		t.Errorf("expected the layer to verify, got %s", err)
+	}
+}
+
+func TestLayoutLayerDigestMismatch(t *testing.T) {
+	path := copyLayout(t)
+	layout, err := OpenLayout(path, "", Platform{OS: "linux", Architecture: "amd64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer := layout.Layers()[0]
+	blob, err := blobPath(path, layer.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(blob, []byte("tampered"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := layer.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if _, err := io.ReadAll(reader); err == nil || !strings.Contains(err.Error(), "digest mismatch") {
+		t.Errorf("expected a digest mismatch, got %v", err)
+	}
+}
+
+func TestBlobPath(t *testing.T) {
+	tests := []struct {
+		digest string
+		path   string
+	}{
+		{digest: "sha256:58379874c48f73ca89cc9cc185b1f5c057163a88bc5d482e69eaf8df76ac958a", path: "layout/blobs/sha256/58379874c48f73ca89cc9cc185b1f5c057163a88bc5d482e69eaf8df76ac958a"},
+		{digest: "sha512+b64u:LCa0a2j_xo_5m0U8HTBBNBNCLXBkg7-g-YpeiGJm564", path: "layout/blobs/sha512+b64u/LCa0a2j_xo_5m0U8HTBBNBNCLXBkg7-g-YpeiGJm564"},
+		{digest: "sha256:../../../etc/passwd"},
+		{digest: "sha256/../..:abc"},
+		{digest: "..:abc"},
+		{digest: "sha256"},
+		{digest: ""},
+	}
+	for _, test := range tests {
+		path, err := blobPath("layout", test.digest)
+		if test.path == "" {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %s", test.digest, path)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", test.digest, err)
+		} else if path != filepath.FromSlash(test.path) {
+			t.Errorf("%q: expected %s, got %s", test.digest, test.path, path)
+		}
+	}
+}
+
+func TestOpenLayoutInvalidDigest(t *testing.T) {
+	path := copyLayout(t)
+	index := `{"schemaVersion": 2, "manifests": [{"mediaType": "` + MediaTypeManifest + `", "digest": "sha256:../../../../etc/passwd", "size": 1}]}`
+	if err := os.WriteFile(filepath.Join(path, "index.json"), []byte(index), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenLayout(path, "", Platform{OS: "linux", Architecture: "amd64"}); err == nil || !strings.Contains(err.Error(), "not a valid digest") {
+		t.Errorf("expected an invalid digest, got %v", err)
+	}
+}
+
+// copyLayout copies the test layout to a directory a test can change
+func copyLayout(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "layout")
+	err := filepath.Walk(testLayout, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(testLayout, name)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return os.MkdirAll(filepath.Join(path, relative), 0755)
+		}
+		content, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(path, relative), content, 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,371 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// The Singularity Image Format is a global header, a table of descriptors,
+// and the data objects they point to. See https://github.com/sylabs/sif
+
+const (
+	sifMagic          = "SIF_MAGIC"
+	sifHeaderSize     = 128
+	sifDescriptorSize = 585
+
+	// SIFLabelsFile has the labels in the root file system of an image
+	SIFLabelsFile = ".singularity.d/labels.json"
+)
+
+// SIF data object types
+const (
+	SIFDataDeffile   int32 = 0x4001
+	SIFDataEnvVar    int32 = 0x4002
+	SIFDataLabels    int32 = 0x4003
+	SIFDataPartition int32 = 0x4004
+	SIFDataSignature int32 = 0x4005
+	SIFDataJSON      int32 = 0x4006
+	SIFDataGeneric   int32 = 0x4007
+)
+
+// SIF partition file system and partition types
+const (
+	SIFFsSquash          int32 = 1
+	SIFFsExt3            int32 = 2
+	SIFFsImmuObj         int32 = 3
+	SIFFsRaw             int32 = 4
+	SIFFsEncryptedSquash int32 = 5
+
+	SIFPartSystem  int32 = 1
+	SIFPartPrimSys int32 = 2
+	SIFPartData    int32 = 3
+	SIFPartOverlay int32 = 4
+)
+
+// sifArchitectures maps SIF architecture codes to GOARCH names
+var sifArchitectures = map[string]string{
+	"01": "386",
+	"02": "amd64",
+	"03": "arm",
+	"04": "arm64",
+	"05": "ppc64",
+	"06": "ppc64le",
+	"07": "mips",
+	"08": "mipsle",
+	"09": "mips64",
+	"10": "mips64le",
+	"11": "s390x",
+	"12": "riscv64",
+}
+
+// sifHeader is the global header, stored little endian
+type sifHeader struct {
+	Launch            [32]byte
+	Magic             [10]byte
+	Version           [3]byte
+	Arch              [3]byte
+	ID                [16]byte
+	CreatedAt         int64
+	ModifiedAt        int64
+	DescriptorsFree   int64
+	DescriptorsTotal  int64
+	DescriptorsOffset int64
+	DescriptorsSize   int64
+	DataOffset        int64
+	DataSize          int64
+}
+
+// sifDescriptor is one entry of the descriptor table
+type sifDescriptor struct {
+	DataType        int32
+	Used            bool
+	ID              uint32
+	GroupID         uint32
+	LinkedID        uint32
+	Offset          int64
+	Size            int64
+	SizeWithPadding int64
+	CreatedAt       int64
+	ModifiedAt      int64
+	UID             int64
+	GID             int64
+	Name            [128]byte
+	Extra           [384]byte
+}
+
+// SIFObject is a data object in a SIF file
+type SIFObject struct {
+	DataType int32
+	ID       uint32
+	GroupID  uint32
+	LinkedID uint32
+	Offset   int64
+	Size     int64
+	Name     string
+
+	// Set for partitions
+	FsType       int32
+	PartType     int32
+	Architecture string
+}
+
+// SIF is an image read from a Singularity/Apptainer SIF file
+type SIF struct {
+	Path         string
+	Version      string
+	Architecture string
+	Objects      []SIFObject
+	config       *Config
+}
+
+// OpenSIF reads the header and descriptors of a SIF file, along with the
+// labels and environment objects
+func OpenSIF(path string) (*SIF, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var header sifHeader
+	if err := binary.Read(io.NewSectionReader(file, 0, sifHeaderSize), binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("%s: reading SIF header: %s", path, err)
+	}
+	if cString(header.Magic[:]) != sifMagic {
+		return nil, fmt.Errorf("%s is not a SIF file", path)
+	}
+
+	sif := SIF{
+		Path:         path,
+		Version:      cString(header.Version[:]),
+		Architecture: sifArchitectures[cString(header.Arch[:])],
+	}
+
+	table := io.NewSectionReader(file, header.DescriptorsOffset, header.DescriptorsSize)
+	for i := int64(0); i < header.DescriptorsTotal; i++ {
+		var descriptor sifDescriptor
+		if err := binary.Read(table, binary.LittleEndian, &descriptor); err != nil {
+			return nil, fmt.Errorf("%s: reading SIF descriptor %d: %s", path, i, err)
+		}
+		if !descriptor.Used {
+			continue
+		}
+		object := SIFObject{
+			DataType: descriptor.DataType,
+			ID:       descriptor.ID,
+			GroupID:  descriptor.GroupID,
+			LinkedID: descriptor.LinkedID,
+			Offset:   descriptor.Offset,
+			Size:     descriptor.Size,
+			Name:     cString(descriptor.Name[:]),
+		}
+		if object.DataType == SIFDataPartition {
+			extra := bytes.NewReader(descriptor.Extra[:])
+			binary.Read(extra, binary.LittleEndian, &object.FsType)
+			binary.Read(extra, binary.LittleEndian, &object.PartType)
+			arch := make([]byte, 3)
+			extra.Read(arch)
+			object.Architecture = sifArchitectures[cString(arch)]
+		}
+		sif.Objects = append(sif.Objects, object)
+	}
+
+	if err := sif.readConfig(file); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return &sif, nil
+}
+
+// sifMetadata is the inspect metadata newer versions of Singularity and
+// Apptainer store as a JSON object, which has the labels of the image.
+// Some versions wrap it in data, as inspect --json prints it.
+type sifMetadata struct {
+	Attributes sifAttributes `json:"attributes"`
+	Data       struct {
+		Attributes sifAttributes `json:"attributes"`
+	} `json:"data"`
+}
+
+type sifAttributes struct {
+	Labels map[string]string `json:"labels"`
+}
+
+// readConfig fills the configuration from the labels, inspect metadata,
+// environment and primary partition objects. Newer images don't have a
+// labels object, and also keep labels in /.singularity.d/labels.json in
+// the partition (see SIFLabelsFile), which the caller can add.
+func (s *SIF) readConfig(file *os.File) error {
+	config := Config{OS: "linux", Architecture: s.Architecture, Labels: map[string]string{}}
+	if partition, err := s.Partition(); err == nil && partition.Architecture != "" {
+		config.Architecture = partition.Architecture
+	}
+
+	for _, object := range s.Objects {
+		switch object.DataType {
+		case SIFDataLabels:
+			content, err := readObject(file, object)
+			if err != nil {
+				return err
+			}
+			labels, err := ParseSIFLabels(content)
+			if err != nil {
+				return fmt.Errorf("invalid labels object %d: %s", object.ID, err)
+			}
+			for key, value := range labels {
+				config.Labels[key] = value
+			}
+		case SIFDataJSON:
+			content, err := readObject(file, object)
+			if err != nil {
+				return err
+			}
+			// Other JSON objects aren't metadata, so they are skipped
+			var metadata sifMetadata
+			if json.Unmarshal(content, &metadata) != nil {
+				continue
+			}
+			for _, labels := range []map[string]string{metadata.Attributes.Labels, metadata.Data.Attributes.Labels} {
+				for key, value := range labels {
+					if _, ok := config.Labels[key]; !ok {
+						config.Labels[key] = value
+					}
+				}
+			}
+		case SIFDataEnvVar:
+			content, err := readObject(file, object)
+			if err != nil {
+				return err
+			}
+			for _, line := range strings.Split(string(content), "\n") {
+				if strings.Contains(line, "=") {
+					config.Env = append(config.Env, strings.TrimPrefix(strings.TrimSpace(line), "export "))
+				}
+			}
+		}
+	}
+	s.config = &config
+	return nil
+}
+
+// ParseSIFLabels reads a labels object or SIFLabelsFile, which is either a
+// flat JSON object, or an object of objects keyed by partition or app that
+// we flatten
+func ParseSIFLabels(content []byte) (map[string]string, error) {
+	labels := map[string]string{}
+	if err := json.Unmarshal(content, &labels); err == nil {
+		return labels, nil
+	}
+	// A failed unmarshal can leave some of the keys
+	labels = map[string]string{}
+	nested := map[string]map[string]string{}
+	if err := json.Unmarshal(content, &nested); err != nil {
+		return nil, err
+	}
+	for _, group := range nested {
+		for key, value := range group {
+			labels[key] = value
+		}
+	}
+	return labels, nil
+}
+
+// Config returns the image configuration, labels from the labels objects
+func (s *SIF) Config() *Config {
+	return s.config
+}
+
+// Layers returns no layers: the root file system is a squashfs partition
+func (s *SIF) Layers() []Layer {
+	return []Layer{}
+}
+
+// Partition returns the primary system partition, the container root
+func (s *SIF) Partition() (SIFObject, error) {
+	for _, object := range s.Objects {
+		if object.DataType == SIFDataPartition && object.PartType == SIFPartPrimSys {
+			return object, nil
+		}
+	}
+	return SIFObject{}, fmt.Errorf("%s has no primary system partition", s.Path)
+}
+
+// Deffile returns the definition file the image was built from, if present
+func (s *SIF) Deffile() (string, error) {
+	for _, object := range s.Objects {
+		if object.DataType == SIFDataDeffile {
+			content, err := s.ReadObject(object)
+			return string(content), err
+		}
+	}
+	return "", nil
+}
+
+// ReadObject reads the content of a data object
+func (s *SIF) ReadObject(object SIFObject) ([]byte, error) {
+	file, err := os.Open(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return readObject(file, object)
+}
+
+// OpenObject returns a reader for a data object, e.g., the squashfs partition
+func (s *SIF) OpenObject(object SIFObject) (io.ReaderAt, io.Closer, error) {
+	file, err := os.Open(s.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkObject(file, object); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return io.NewSectionReader(file, object.Offset, object.Size), file, nil
+}
+
+// AddLabels adds labels the image doesn't have already, e.g., from
+// SIFLabelsFile
+func (s *SIF) AddLabels(labels map[string]string) {
+	for key, value := range labels {
+		if _, ok := s.config.Labels[key]; !ok {
+			s.config.Labels[key] = value
+		}
+	}
+}
+
+func readObject(file *os.File, object SIFObject) ([]byte, error) {
+	if err := checkObject(file, object); err != nil {
+		return nil, err
+	}
+	content := make([]byte, object.Size)
+	if _, err := file.ReadAt(content, object.Offset); err != nil {
+		return nil, fmt.Errorf("reading SIF object %d: %s", object.ID, err)
+	}
+	return content, nil
+}
+
+// checkObject makes sure a data object is inside the file, since its
+// descriptor can't be trusted
+func checkObject(file *os.File, object SIFObject) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if object.Offset < 0 || object.Size < 0 || object.Offset > info.Size()-object.Size {
+		return fmt.Errorf("SIF object %d (offset %d, size %d) is outside the file", object.ID, object.Offset, object.Size)
+	}
+	return nil
+}
+
+// cString trims a fixed size, null terminated field
+func cString(field []byte) string {
+	if i := bytes.IndexByte(field, 0); i >= 0 {
+		field = field[:i]
+	}
+	return strings.TrimSpace(string(field))
+}
@@ -0,0 +1,143 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// sifObjectData is a data object to write to a test SIF file. A size other
+// than zero replaces the size of the content in its descriptor.
+type sifObjectData struct {
+	dataType int32
+	content  string
+	size     int64
+}
+
+// writeSIF writes a SIF file with a descriptor for each object
+func writeSIF(t *testing.T, objects []sifObjectData) string {
+	t.Helper()
+	descriptorsOffset := int64(sifHeaderSize)
+	dataOffset := descriptorsOffset + int64(len(objects))*sifDescriptorSize
+
+	var descriptors, data bytes.Buffer
+	for i, object := range objects {
+		size := int64(len(object.content))
+		if object.size != 0 {
+			size = object.size
+		}
+		descriptor := sifDescriptor{
+			DataType: object.dataType,
+			Used:     true,
+			ID:       uint32(i + 1),
+			Offset:   dataOffset + int64(data.Len()),
+			Size:     size,
+		}
+		if err := binary.Write(&descriptors, binary.LittleEndian, descriptor); err != nil {
+			t.Fatal(err)
+		}
+		data.WriteString(object.content)
+	}
+
+	header := sifHeader{
+		DescriptorsTotal:  int64(len(objects)),
+		DescriptorsOffset: descriptorsOffset,
+		DescriptorsSize:   int64(descriptors.Len()),
+		DataOffset:        dataOffset,
+		DataSize:          int64(data.Len()),
+	}
+	copy(header.Magic[:], sifMagic)
+	copy(header.Arch[:], "02")
+
+	var file bytes.Buffer
+	binary.Write(&file, binary.LittleEndian, header)
+	file.Write(descriptors.Bytes())
+	file.Write(data.Bytes())
+	path := filepath.Join(t.TempDir(), "image.sif")
+	if err := os.WriteFile(path, file.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestOpenSIFLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		objects []sifObjectData
+		labels  map[string]string
+	}{
+		{
+			name:    "labels object",
+			objects: []sifObjectData{{dataType: SIFDataLabels, content: `{"org.supercontainers.mpi": "openmpi"}`}},
+			labels:  map[string]string{"org.supercontainers.mpi": "openmpi"},
+		},
+		{
+			name:    "nested labels object",
+			objects: []sifObjectData{{dataType: SIFDataLabels, content: `{"system-partition": {"org.supercontainers.mpi": "openmpi"}}`}},
+			labels:  map[string]string{"org.supercontainers.mpi": "openmpi"},
+		},
+		{
+			name: "inspect metadata",
+			objects: []sifObjectData{
+				{dataType: SIFDataJSON, content: `{"attributes": {"labels": {"org.supercontainers.mpi": "mpich"}}, "type": "container"}`},
+				{dataType: SIFDataJSON, content: `["not", "metadata"]`},
+			},
+			labels: map[string]string{"org.supercontainers.mpi": "mpich"},
+		},
+		{
+			name:    "wrapped inspect metadata",
+			objects: []sifObjectData{{dataType: SIFDataJSON, content: `{"data": {"attributes": {"labels": {"org.supercontainers.gpu": "cuda"}}}}`}},
+			labels:  map[string]string{"org.supercontainers.gpu": "cuda"},
+		},
+		{
+			name: "labels object first",
+			objects: []sifObjectData{
+				{dataType: SIFDataJSON, content: `{"attributes": {"labels": {"org.supercontainers.mpi": "mpich", "org.supercontainers.gpu": "cuda"}}}`},
+				{dataType: SIFDataLabels, content: `{"org.supercontainers.mpi": "openmpi"}`},
+			},
+			labels: map[string]string{"org.supercontainers.mpi": "openmpi", "org.supercontainers.gpu": "cuda"},
+		},
+	}
+	for _, test := range tests {
+		sif, err := OpenSIF(writeSIF(t, test.objects))
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(sif.Config().Labels, test.labels) {
+			t.Errorf("%s: expected labels %v, got %v", test.name, test.labels, sif.Config().Labels)
+		}
+		if sif.Config().Architecture != "amd64" {
+			t.Errorf("%s: expected amd64, got %s", test.name, sif.Config().Architecture)
+		}
+	}
+}
+
+func TestOpenSIFAddLabels(t *testing.T) {
+	sif, err := OpenSIF(writeSIF(t, []sifObjectData{{dataType: SIFDataLabels, content: `{"org.supercontainers.mpi": "openmpi"}`}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels, err := ParseSIFLabels([]byte(`{"org.supercontainers.mpi": "mpich", "org.supercontainers.target": "zen2"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sif.AddLabels(labels)
+	want := map[string]string{"org.supercontainers.mpi": "openmpi", "org.supercontainers.target": "zen2"}
+	if !reflect.DeepEqual(sif.Config().Labels, want) {
+		t.Errorf("expected labels %v, got %v", want, sif.Config().Labels)
+	}
+}
+
+func TestOpenSIFObjectOutsideFile(t *testing.T) {
+	for _, size := range []int64{-1, 1 << 40, 1<<63 - 1} {
+		_, err := OpenSIF(writeSIF(t, []sifObjectData{{dataType: SIFDataLabels, content: `{}`, size: size}}))
+		if err == nil || !strings.Contains(err.Error(), "outside the file") {
+			t.Errorf("size %d: expected an object outside the file, got %v", size, err)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package elftest
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+)
+
+// Small 64-bit little endian ELF files for tests, with the parts of ELF the
+// binaries and libs packages read: the dynamic section, symbol versions,
+// GNU property notes, indirect functions and code.
+
+// Need is the versions of a library a file needs (.gnu.version_r)
+type Need struct {
+	Library  string
+	Versions []string
+}
+
+// File describes an ELF file to write
+type File struct {
+	// Machine defaults to x86_64
+	Machine elf.Machine
+
+	// Dynamic section entries
+	Soname  string
+	Needed  []string
+	RPath   string
+	RunPath string
+
+	// Needs are the versions needed from each library, and Defines the
+	// versions the file defines (.gnu.version_d) after its base version
+	Needs   []Need
+	Defines []string
+
+	// Text is the content of an executable .text section
+	Text []byte
+
+	// Property is the descriptor of a GNU property note, written to the
+	// .note.gnu.property section and a PT_GNU_PROPERTY segment
+	Property []byte
+
+	// IFuncs are dynamic symbols defined as GNU indirect functions
+	IFuncs []string
+}
+
+// X86ISANeeded is a GNU property with the x86 ISA needed bits
+func X86ISANeeded(bits uint32) []byte {
+	property := make([]byte, 16)
+	binary.LittleEndian.PutUint32(property[0:], 0xc0008002)
+	binary.LittleEndian.PutUint32(property[4:], 4)
+	binary.LittleEndian.PutUint32(property[8:], bits)
+	return property
+}
+
+// section is a section to write, in order after the null section
+type section struct {
+	name    string
+	kind    elf.SectionType
+	flags   elf.SectionFlag
+	link    uint32
+	info    uint32
+	align   uint64
+	entsize uint64
+	data    []byte
+}
+
+// strtab builds a string table, sharing equal strings
+type strtab struct {
+	data    bytes.Buffer
+	offsets map[string]uint32
+}
+
+func newStrtab() *strtab {
+	table := strtab{offsets: map[string]uint32{}}
+	table.data.WriteByte(0)
+	return &table
+}
+
+func (s *strtab) add(value string) uint32 {
+	if offset, ok := s.offsets[value]; ok {
+		return offset
+	}
+	offset := uint32(s.data.Len())
+	s.data.WriteString(value + "\x00")
+	s.offsets[value] = offset
+	return offset
+}
+
+// Bytes writes the ELF file
+func (f File) Bytes() []byte {
+	order := binary.LittleEndian
+	machine := f.Machine
+	if machine == elf.EM_NONE {
+		machine = elf.EM_X86_64
+	}
+
+	// Sections are numbered from 1, the null section is 0
+	const (
+		textIndex = 1 + iota
+		dynstrIndex
+		dynsymIndex
+	)
+	dynstr := newStrtab()
+	sections := []section{
+		{name: ".text", kind: elf.SHT_PROGBITS, flags: elf.SHF_ALLOC | elf.SHF_EXECINSTR, align: 16, data: f.Text},
+		{name: ".dynstr", kind: elf.SHT_STRTAB, flags: elf.SHF_ALLOC, align: 1},
+	}
+
+	var symbols bytes.Buffer
+	symbols.Write(make([]byte, 24))
+	for _, name := range f.IFuncs {
+		binary.Write(&symbols, order, elf.Sym64{
+			Name:  dynstr.add(name),
+			Info:  elf.ST_INFO(elf.STB_GLOBAL, elf.STT_LOOS),
+			Shndx: textIndex,
+		})
+	}
+	sections = append(sections, section{name: ".dynsym", kind: elf.SHT_DYNSYM, flags: elf.SHF_ALLOC, link: dynstrIndex, info: 1, align: 8, entsize: 24, data: symbols.Bytes()})
+
+	var dynamic bytes.Buffer
+	entry := func(tag elf.DynTag, value uint32) {
+		binary.Write(&dynamic, order, elf.Dyn64{Tag: int64(tag), Val: uint64(value)})
+	}
+	for _, needed := range f.Needed {
+		entry(elf.DT_NEEDED, dynstr.add(needed))
+	}
+	if f.Soname != "" {
+		entry(elf.DT_SONAME, dynstr.add(f.Soname))
+	}
+	if f.RPath != "" {
+		entry(elf.DT_RPATH, dynstr.add(f.RPath))
+	}
+	if f.RunPath != "" {
+		entry(elf.DT_RUNPATH, dynstr.add(f.RunPath))
+	}
+	entry(elf.DT_NULL, 0)
+	sections = append(sections, section{name: ".dynamic", kind: elf.SHT_DYNAMIC, flags: elf.SHF_ALLOC | elf.SHF_WRITE, link: dynstrIndex, align: 8, entsize: 16, data: dynamic.Bytes()})
+
+	// Symbol versions need a version for each symbol, all global here
+	if len(f.Needs) > 0 || len(f.Defines) > 0 {
+		versym := make([]byte, symbols.Len()/24*2)
+		sections = append(sections, section{name: ".gnu.version", kind: elf.SHT_GNU_VERSYM, flags: elf.SHF_ALLOC, link: dynsymIndex, align: 2, entsize: 2, data: versym})
+	}
+	index := uint16(2)
+	if len(f.Needs) > 0 {
+		var needs bytes.Buffer
+		for i, need := range f.Needs {
+			next := uint32(16 + 16*len(need.Versions))
+			if i == len(f.Needs)-1 {
+				next = 0
+			}
+			binary.Write(&needs, order, []uint16{1, uint16(len(need.Versions))})
+			binary.Write(&needs, order, []uint32{dynstr.add(need.Library), 16, next})
+			for j, version := range need.Versions {
+				next := uint32(16)
+				if j == len(need.Versions)-1 {
+					next = 0
+				}
+				binary.Write(&needs, order, uint32(0))
+				binary.Write(&needs, order, []uint16{0, index})
+				binary.Write(&needs, order, []uint32{dynstr.add(version), next})
+				index++
+			}
+		}
+		sections = append(sections, section{name: ".gnu.version_r", kind: elf.SHT_GNU_VERNEED, flags: elf.SHF_ALLOC, link: dynstrIndex, info: uint32(len(f.Needs)), align: 8, data: needs.Bytes()})
+	}
+	if len(f.Defines) > 0 {
+		var defines bytes.Buffer
+		names := append([]string{f.Soname}, f.Defines...)
+		for i, name := range names {
+			flags, next := uint16(0), uint32(28)
+			if i == 0 {
+				flags = uint16(elf.VER_FLG_BASE)
+			}
+			if i == len(names)-1 {
+				next = 0
+			}
+			binary.Write(&defines, order, []uint16{1, flags, uint16(i + 1), 1})
+			binary.Write(&defines, order, []uint32{0, 20, next, dynstr.add(name), 0})
+		}
+		sections = append(sections, section{name: ".gnu.version_d", kind: elf.SHT_GNU_VERDEF, flags: elf.SHF_ALLOC, link: dynstrIndex, info: uint32(len(names)), align: 8, data: defines.Bytes()})
+	}
+
+	property := -1
+	if f.Property != nil {
+		var note bytes.Buffer
+		binary.Write(&note, order, []uint32{4, uint32(len(f.Property)), 5})
+		note.WriteString("GNU\x00")
+		note.Write(f.Property)
+		property = len(sections)
+		sections = append(sections, section{name: ".note.gnu.property", kind: elf.SHT_NOTE, flags: elf.SHF_ALLOC, align: 8, data: note.Bytes()})
+	}
+	sections[dynstrIndex-1].data = dynstr.data.Bytes()
+
+	shstrtab := newStrtab()
+	for i := range sections {
+		shstrtab.add(sections[i].name)
+	}
+	shstrtab.add(".shstrtab")
+	sections = append(sections, section{name: ".shstrtab", kind: elf.SHT_STRTAB, align: 1, data: shstrtab.data.Bytes()})
+
+	// The ELF header, a program header for the property note, the content
+	// of the sections, then the section headers
+	phnum := 0
+	if property >= 0 {
+		phnum = 1
+	}
+	offset := uint64(64 + 56*phnum)
+	offsets := []uint64{}
+	var content bytes.Buffer
+	for _, s := range sections {
+		for (offset+uint64(content.Len()))%s.align != 0 {
+			content.WriteByte(0)
+		}
+		offsets = append(offsets, offset+uint64(content.Len()))
+		content.Write(s.data)
+	}
+	for (offset+uint64(content.Len()))%8 != 0 {
+		content.WriteByte(0)
+	}
+	shoff := offset + uint64(content.Len())
+
+	var file bytes.Buffer
+	header := elf.Header64{
+		Type:      uint16(elf.ET_DYN),
+		Machine:   uint16(machine),
+		Version:   uint32(elf.EV_CURRENT),
+		Shoff:     shoff,
+		Ehsize:    64,
+		Phentsize: 56,
+		Phnum:     uint16(phnum),
+		Shentsize: 64,
+		Shnum:     uint16(len(sections) + 1),
+		Shstrndx:  uint16(len(sections)),
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	if phnum > 0 {
+		header.Phoff = 64
+	}
+	binary.Write(&file, order, header)
+	if property >= 0 {
+		size := uint64(len(sections[property].data))
+		binary.Write(&file, order, elf.Prog64{
+			Type:   uint32(elf.PT_GNU_PROPERTY),
+			Flags:  uint32(elf.PF_R),
+			Off:    offsets[property],
+			Vaddr:  offsets[property],
+			Paddr:  offsets[property],
+			Filesz: size,
+			Memsz:  size,
+			Align:  8,
+		})
+	}
+	file.Write(content.Bytes())
+
+	binary.Write(&file, order, elf.Section64{})
+	for i, s := range sections {
+		binary.Write(&file, order, elf.Section64{
+			Name:      shstrtab.add(s.name),
+			Type:      uint32(s.kind),
+			Flags:     uint64(s.flags),
+			Addr:      offsets[i],
+			Off:       offsets[i],
+			Size:      uint64(len(s.data)),
+			Link:      s.link,
+			Info:      s.info,
+			Addralign: s.align,
+			Entsize:   s.entsize,
+		})
+	}
+	return file.Bytes()
+}
@@ -0,0 +1,248 @@
+package inventory
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/vsoch/containerspec/compat"
+	"github.com/vsoch/containerspec/spec"
+	"github.com/vsoch/containerspec/utils"
+)
+
+// A cluster is many host specs, one per node (saved with the host command).
+// Nodes that are the same for compatibility (microarchitecture, glibc, MPI
+// and GPUs) are a class, so a cluster of thousands of nodes is a few classes.
+
+// Node is the host spec of one node, named for its file
+type Node struct {
+	Name string     `json:"name"`
+	Host *spec.Host `json:"host"`
+}
+
+// Class is nodes that are the same for compatibility
+type Class struct {
+	Arch           string   `json:"arch"`
+	Target         string   `json:"target,omitempty"`
+	Glibc          string   `json:"glibc,omitempty"`
+	MPI            string   `json:"mpi,omitempty"`
+	GPU            []string `json:"gpu,omitempty"`
+	CUDACapability string   `json:"cuda_capability,omitempty"`
+	Nodes          []string `json:"nodes"`
+}
+
+// Inventory is the nodes of a cluster, and their classes from largest
+type Inventory struct {
+	Nodes   []Node   `json:"nodes"`
+	Classes []*Class `json:"classes"`
+
+	// Registry has the rules nodes are checked with, those of compat.Check
+	// if it isn't set
+	Registry *compat.Registry `json:"-"`
+}
+
+// Placement is the nodes an image runs on with one verdict, and why
+type Placement struct {
+	Verdict compat.Verdict `json:"verdict"`
+	Nodes   []string       `json:"nodes"`
+	Reasons []string       `json:"reasons,omitempty"`
+}
+
+// Coverage is the nodes that run images built for a target
+type Coverage struct {
+	Target  string   `json:"target"`
+	Percent float64  `json:"percent"`
+	Nodes   []string `json:"nodes"`
+
+	// Of targets that cover as many nodes, the one with more features (its
+	// own and its ancestors') is faster. Depth in the graph doesn't say, e.g.,
+	// sandybridge is deeper than x86_64_v3 but doesn't have avx2.
+	features int
+}
+
+// Read reads the host specs (json) in a directory
+func Read(dir string) (*Inventory, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	nodes := []Node{}
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		host, err := spec.ParseHost(content)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		nodes = append(nodes, Node{Name: strings.TrimSuffix(filepath.Base(path), ".json"), Host: host})
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("%s doesn't have host specs (json files from the host command)", dir)
+	}
+	return New(nodes), nil
+}
+
+// New groups nodes into classes
+func New(nodes []Node) *Inventory {
+	inventory := Inventory{Nodes: nodes}
+	classes := map[string]*Class{}
+	for _, node := range nodes {
+		class := classOf(node.Host)
+		key := class.String()
+		if existing, ok := classes[key]; ok {
+			class = existing
+		} else {
+			classes[key] = class
+			inventory.Classes = append(inventory.Classes, class)
+		}
+		class.Nodes = append(class.Nodes, node.Name)
+	}
+	sort.SliceStable(inventory.Classes, func(i, j int) bool {
+		return len(inventory.Classes[i].Nodes) > len(inventory.Classes[j].Nodes)
+	})
+	return &inventory
+}
+
+// check matches a container against a host with the rules of the inventory
+func (i *Inventory) check(container *compat.Container, host *spec.Host) *compat.Report {
+	if i.Registry != nil {
+		return i.Registry.Check(container, host)
+	}
+	return compat.Check(container, host)
+}
+
+// classOf is the class of a host, without nodes
+func classOf(host *spec.Host) *Class {
+	class := Class{
+		Arch:           host.Arch,
+		Target:         host.Target,
+		Glibc:          host.Glibc,
+		GPU:            append([]string{}, host.GPU...),
+		CUDACapability: host.CUDACapability,
+	}
+	if host.MPI != nil {
+		class.MPI = host.MPI.Family
+	}
+	sort.Strings(class.GPU)
+	return &class
+}
+
+// String describes a class, e.g., haswell glibc 2.28 mpich cuda 7.0
+func (c *Class) String() string {
+	parts := []string{c.Target}
+	if c.Target == "" {
+		parts = []string{c.Arch}
+	}
+	if c.Glibc != "" {
+		parts = append(parts, "glibc "+c.Glibc)
+	}
+	if c.MPI != "" {
+		parts = append(parts, c.MPI)
+	}
+	for _, gpu := range c.GPU {
+		if gpu == "cuda" && c.CUDACapability != "" {
+			gpu += " " + c.CUDACapability
+		}
+		parts = append(parts, gpu)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Place checks an image on every node, and groups the nodes by verdict from
+// best to worst. Nodes of a class can differ (e.g., in kernel), so each one
+// is checked.
+func (i *Inventory) Place(container *compat.Container) []Placement {
+	placements := map[compat.Verdict]*Placement{}
+	for _, node := range i.Nodes {
+		report := i.check(container, node.Host)
+		placement, ok := placements[report.Verdict]
+		if !ok {
+			placement = &Placement{Verdict: report.Verdict}
+			placements[report.Verdict] = placement
+		}
+		placement.Nodes = append(placement.Nodes, node.Name)
+		for _, result := range report.Results {
+			if result.Verdict == report.Verdict && result.Verdict != compat.Optimal && !utils.IncludesString(result.Reason, placement.Reasons) {
+				placement.Reasons = append(placement.Reasons, result.Reason)
+			}
+		}
+	}
+
+	result := []Placement{}
+	for _, verdict := range []compat.Verdict{compat.Optimal, compat.Compatible, compat.Degraded, compat.Incompatible} {
+		if placement, ok := placements[verdict]; ok {
+			result = append(result, *placement)
+		}
+	}
+	return result
+}
+
+// Coverage returns every target a node runs with the nodes that run it, from
+// the most nodes, and of as many the most specific target
+func (i *Inventory) Coverage() []Coverage {
+	targets := map[string]bool{}
+	for _, node := range i.Nodes {
+		for _, target := range compat.FallbackChain(node.Host) {
+			targets[target] = true
+		}
+	}
+
+	coverage := []Coverage{}
+	for target := range targets {
+		arch, ok := spec.LookupMicroarchitecture(target)
+		if !ok {
+			continue
+		}
+		container := compat.Container{Arch: arch.Family().Name, Target: target}
+		covered := Coverage{Target: target, Nodes: []string{}, features: countFeatures(arch)}
+		for _, node := range i.Nodes {
+			if i.check(&container, node.Host).Verdict != compat.Incompatible {
+				covered.Nodes = append(covered.Nodes, node.Name)
+			}
+		}
+		covered.Percent = 100 * float64(len(covered.Nodes)) / float64(len(i.Nodes))
+		coverage = append(coverage, covered)
+	}
+	sort.Slice(coverage, func(a, b int) bool {
+		x, y := coverage[a], coverage[b]
+		if len(x.Nodes) != len(y.Nodes) {
+			return len(x.Nodes) > len(y.Nodes)
+		}
+		if x.features != y.features {
+			return x.features > y.features
+		}
+		return x.Target < y.Target
+	})
+	return coverage
+}
+
+// Covering returns the most specific target (with the most features) that
+// runs on at least a percent of the nodes, the one image to build for them
+func (i *Inventory) Covering(percent float64) (Coverage, bool) {
+	best, found := Coverage{}, false
+	for _, covered := range i.Coverage() {
+		if covered.Percent < percent {
+			continue
+		}
+		if !found || covered.features > best.features || (covered.features == best.features && len(covered.Nodes) > len(best.Nodes)) {
+			best, found = covered, true
+		}
+	}
+	return best, found
+}
+
+// countFeatures counts the features of a target and its ancestors
+func countFeatures(arch spec.Microarchitecture) int {
+	features := map[string]bool{}
+	for _, a := range append([]spec.Microarchitecture{arch}, arch.Ancestors()...) {
+		for _, feature := range a.Features {
+			features[feature] = true
+		}
+	}
+	return len(features)
+}
@@ -0,0 +1,85 @@
+package inventory
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vsoch/containerspec/compat"
+	"github.com/vsoch/containerspec/spec"
+)
+
+// cluster has three haswell nodes, an icelake node and a graviton2 node,
+// with the names of more nodes of other targets
+func cluster(others ...string) *Inventory {
+	host := func(arch, target string) *spec.Host {
+		return &spec.Host{Arch: arch, Target: target, Glibc: "2.34"}
+	}
+	nodes := []Node{
+		{Name: "node01", Host: host("x86_64", "haswell")},
+		{Name: "node02", Host: host("x86_64", "haswell")},
+		{Name: "node03", Host: host("x86_64", "haswell")},
+		{Name: "node04", Host: host("x86_64", "icelake")},
+		{Name: "node05", Host: host("aarch64", "graviton2")},
+	}
+	for j := 0; j+1 < len(others); j += 2 {
+		nodes = append(nodes, Node{Name: others[j], Host: host(others[j+1], "")})
+	}
+	return New(nodes)
+}
+
+func TestNew(t *testing.T) {
+	inventory := cluster()
+	classes := []string{}
+	for _, class := range inventory.Classes {
+		classes = append(classes, class.String())
+	}
+	want := []string{"haswell glibc 2.34", "icelake glibc 2.34", "graviton2 glibc 2.34"}
+	if !reflect.DeepEqual(classes, want) {
+		t.Errorf("expected classes %v, got %v", want, classes)
+	}
+}
+
+func TestPlace(t *testing.T) {
+	placements := cluster().Place(&compat.Container{Arch: "x86_64", Target: "haswell"})
+	want := []Placement{
+		{Verdict: compat.Optimal, Nodes: []string{"node01", "node02", "node03"}},
+		{Verdict: compat.Compatible, Nodes: []string{"node04"}, Reasons: []string{"haswell is older than icelake, a build for the host would be faster"}},
+		{Verdict: compat.Incompatible, Nodes: []string{"node05"}, Reasons: []string{"the container is built for x86_64, the host is aarch64", "haswell is in the x86_64 family, the host is aarch64"}},
+	}
+	if !reflect.DeepEqual(placements, want) {
+		t.Errorf("expected %+v, got %+v", want, placements)
+	}
+}
+
+func TestCoverage(t *testing.T) {
+	inventory := cluster()
+	coverage := inventory.Coverage()
+	if first := coverage[0]; first.Target != "haswell" || first.Percent != 80 || len(first.Nodes) != 4 {
+		t.Errorf("expected haswell to cover the most nodes with the most features, got %+v", first)
+	}
+	targets := map[string]float64{}
+	for _, covered := range coverage {
+		targets[covered.Target] = covered.Percent
+	}
+	for target, percent := range map[string]float64{"x86_64": 80, "x86_64_v4": 20, "icelake": 20, "graviton2": 20, "aarch64": 20} {
+		if targets[target] != percent {
+			t.Errorf("expected %s to cover %g%% of nodes, got %g%%", target, percent, targets[target])
+		}
+	}
+
+	tests := []struct {
+		percent float64
+		target  string
+	}{
+		{percent: 80, target: "haswell"},
+		{percent: 60, target: "haswell"},
+		{percent: 20, target: "icelake"},
+		{percent: 100},
+	}
+	for _, test := range tests {
+		covering, ok := inventory.Covering(test.percent)
+		if covering.Target != test.target || ok != (test.target != "") {
+			t.Errorf("%g%%: expected %q, got %q (%v)", test.percent, test.target, covering.Target, ok)
+		}
+	}
+}
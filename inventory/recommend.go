@@ -0,0 +1,384 @@
+package inventory
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vsoch/containerspec/compat"
+	"github.com/vsoch/containerspec/spec"
+	"github.com/vsoch/containerspec/utils"
+)
+
+// Which variants should CI build? Each node runs best with a build for its
+// own microarchitecture, and every image we don't build costs the nodes that
+// run an older target some performance. We measure that loss as the share of
+// the features of a node's microarchitecture (and its ancestors) that the
+// target it runs doesn't have, e.g., an icelake node running x86_64_v3 loses
+// avx512.
+
+// maxCombinations bounds the exact search for the smallest set of targets,
+// after which we choose them greedily
+const maxCombinations = 200000
+
+// Options trade the number of images against performance. With Images, we
+// choose up to that many targets with the least loss. Otherwise, we choose
+// the fewest targets that lose at most MaxLoss (0 to 1) on every node.
+type Options struct {
+	MaxLoss float64
+	Images  int
+}
+
+// Build is a target to build, how to build it, and the nodes that run it
+type Build struct {
+	Target string         `json:"target"`
+	Flags  []CompilerFlag `json:"flags,omitempty"`
+	Nodes  []string       `json:"nodes"`
+
+	// Loss is the most any of the nodes loses
+	Loss float64 `json:"loss"`
+}
+
+// CompilerFlag is how a compiler builds for a target, from the CPU database.
+// Versions are as the database has them (e.g., 11.1: for 11.1 and later).
+type CompilerFlag struct {
+	Compiler string `json:"compiler"`
+	Versions string `json:"versions"`
+	Requires string `json:"requires"`
+	Flags    string `json:"flags"`
+}
+
+// Recommendation is the targets to build for a cluster
+type Recommendation struct {
+	Builds []Build `json:"builds"`
+
+	// Loss is the mean over nodes, and MaxLoss the most for a node
+	Loss    float64 `json:"loss"`
+	MaxLoss float64 `json:"max_loss"`
+
+	// Unrecognized are nodes whose architecture isn't in the CPU database,
+	// so there is no target to build for them
+	Unrecognized []string `json:"unrecognized,omitempty"`
+}
+
+// compilers we give flags for
+var recommendCompilers = []string{"gcc", "clang"}
+
+// Recommend chooses the targets to build so every node of the cluster runs
+// one, trading the number of images against performance loss. Nodes whose
+// architecture isn't in the CPU database are left out, and reported.
+func (i *Inventory) Recommend(options Options) (*Recommendation, error) {
+	known, unrecognized := i.recognized()
+	if len(known.Nodes) == 0 {
+		return nil, fmt.Errorf("no node has an architecture in the CPU database (%s)", strings.Join(unrecognized, ", "))
+	}
+	recommendation, err := known.recommend(options)
+	if err != nil {
+		return nil, err
+	}
+	recommendation.Unrecognized = unrecognized
+	return recommendation, nil
+}
+
+// recognized returns the nodes whose target or architecture is in the CPU
+// database as an inventory, and the names of the others
+func (i *Inventory) recognized() (*Inventory, []string) {
+	nodes, unrecognized := []Node{}, []string{}
+	for _, node := range i.Nodes {
+		_, target := spec.LookupMicroarchitecture(node.Host.Target)
+		_, arch := spec.LookupMicroarchitecture(node.Host.Arch)
+		if target || arch {
+			nodes = append(nodes, node)
+		} else {
+			unrecognized = append(unrecognized, node.Name)
+		}
+	}
+	if len(unrecognized) == 0 {
+		return i, nil
+	}
+	known := New(nodes)
+	known.Registry = i.Registry
+	return known, unrecognized
+}
+
+// recommend chooses the targets for nodes that all have one
+func (i *Inventory) recommend(options Options) (*Recommendation, error) {
+	candidates, losses := i.candidates()
+	candidates = undominated(candidates, losses, len(i.Classes))
+
+	var best []string
+	bestLoss := 0.0
+	consider := func(set []string) {
+		loss, maxLoss, ok := i.assign(set, losses)
+		if !ok {
+			return
+		}
+		if options.Images == 0 && maxLoss > options.MaxLoss+1e-9 {
+			return
+		}
+		// Sets are considered from the smallest, so of two as good the first
+		// has fewer images
+		if best == nil || loss < bestLoss-1e-9 {
+			best, bestLoss = append([]string{}, set...), loss
+		}
+	}
+
+	limit := len(candidates)
+	if options.Images > 0 && options.Images < limit {
+		limit = options.Images
+	}
+	for size := 1; size <= limit; size++ {
+		if combinations(len(candidates), size) > maxCombinations {
+			greedy := i.greedy(candidates, losses, options)
+			consider(greedy)
+			break
+		}
+		eachCombination(candidates, size, consider)
+
+		// The fewest targets that are good enough, or as many as we may build
+		if best != nil && options.Images == 0 {
+			break
+		}
+	}
+	if best == nil {
+		if options.Images > 0 {
+			return nil, fmt.Errorf("the cluster has %d architecture families, which need more than %d images", len(i.families()), options.Images)
+		}
+		return nil, fmt.Errorf("no set of targets loses at most %.0f%% on every node", 100*options.MaxLoss)
+	}
+	return i.recommendation(best, losses), nil
+}
+
+// candidates are the targets a node can run (its microarchitecture and its
+// ancestors), with the loss of each class of nodes running each one, or -1
+// if it doesn't run
+func (i *Inventory) candidates() ([]string, map[string][]float64) {
+	targets := []string{}
+	seen := map[string]bool{}
+	for _, class := range i.Classes {
+		host := i.host(class)
+		for _, target := range compat.FallbackChain(host) {
+			if _, ok := spec.LookupMicroarchitecture(target); ok && !seen[target] {
+				seen[target] = true
+				targets = append(targets, target)
+			}
+		}
+	}
+	sort.Strings(targets)
+
+	losses := map[string][]float64{}
+	for _, target := range targets {
+		arch, _ := spec.LookupMicroarchitecture(target)
+		container := compat.Container{Arch: arch.Family().Name, Target: target}
+		features := countFeatures(arch)
+		for _, class := range i.Classes {
+			host := i.host(class)
+			loss := -1.0
+			if i.check(&container, host).Verdict != compat.Incompatible {
+				loss = 0
+				if hostArch, ok := spec.LookupMicroarchitecture(host.Target); ok {
+					if hostFeatures := countFeatures(hostArch); hostFeatures > 0 && features < hostFeatures {
+						loss = 1 - float64(features)/float64(hostFeatures)
+					}
+				}
+			}
+			losses[target] = append(losses[target], loss)
+		}
+	}
+	return targets, losses
+}
+
+// host is the spec of the first node of a class, which is what the class has
+func (i *Inventory) host(class *Class) *spec.Host {
+	for _, node := range i.Nodes {
+		if node.Name == class.Nodes[0] {
+			return node.Host
+		}
+	}
+	return &spec.Host{Arch: class.Arch, Target: class.Target}
+}
+
+// undominated removes targets that are no better for any class than another
+// target (e.g., nocona when x86_64_v2 runs on the same nodes with less loss)
+func undominated(targets []string, losses map[string][]float64, classes int) []string {
+	dominates := func(a, b string) bool {
+		strictly := false
+		for k := 0; k < classes; k++ {
+			x, y := losses[a][k], losses[b][k]
+			if y >= 0 && (x < 0 || x > y) {
+				return false
+			}
+			if y < 0 && x >= 0 || x < y {
+				strictly = true
+			}
+		}
+		// Of targets as good for every class, keep the first by name
+		return strictly || a < b
+	}
+	kept := []string{}
+	for _, target := range targets {
+		dominated := false
+		for _, other := range targets {
+			if other != target && dominates(other, target) {
+				dominated = true
+				break
+			}
+		}
+		if !dominated {
+			kept = append(kept, target)
+		}
+	}
+	return kept
+}
+
+// assign gives each class the target of a set it loses least with, and
+// returns the mean loss over nodes and the most for a node. It is false if
+// a class doesn't run any of them.
+func (i *Inventory) assign(set []string, losses map[string][]float64) (float64, float64, bool) {
+	total, most := 0.0, 0.0
+	for k, class := range i.Classes {
+		best := -1.0
+		for _, target := range set {
+			if loss := losses[target][k]; loss >= 0 && (best < 0 || loss < best) {
+				best = loss
+			}
+		}
+		if best < 0 {
+			return 0, 0, false
+		}
+		total += best * float64(len(class.Nodes))
+		most = max(most, best)
+	}
+	return total / float64(len(i.Nodes)), most, true
+}
+
+// greedy chooses targets one at a time, each the one that lowers the loss
+// the most, until the set is good enough or as large as we may build
+func (i *Inventory) greedy(candidates []string, losses map[string][]float64, options Options) []string {
+	set := []string{}
+	for len(set) < len(candidates) && (options.Images == 0 || len(set) < options.Images) {
+		best, bestScore := "", 0.0
+		for _, target := range candidates {
+			if utils.IncludesString(target, set) {
+				continue
+			}
+			score := i.score(append(set, target), losses)
+			if best == "" || score < bestScore {
+				best, bestScore = target, score
+			}
+		}
+		set = append(set, best)
+		if _, most, ok := i.assign(set, losses); ok && options.Images == 0 && most <= options.MaxLoss+1e-9 {
+			break
+		}
+	}
+	return set
+}
+
+// score is the loss of a set, counting nodes that don't run it as lost
+func (i *Inventory) score(set []string, losses map[string][]float64) float64 {
+	total := 0.0
+	for k, class := range i.Classes {
+		best := 1.0
+		for _, target := range set {
+			if loss := losses[target][k]; loss >= 0 && loss < best {
+				best = loss
+			}
+		}
+		total += best * float64(len(class.Nodes))
+	}
+	return total
+}
+
+// recommendation describes the builds of a set of targets
+func (i *Inventory) recommendation(set []string, losses map[string][]float64) *Recommendation {
+	builds := map[string]*Build{}
+	recommendation := Recommendation{}
+	total := 0.0
+	for k, class := range i.Classes {
+		chosen, best := "", -1.0
+		for _, target := range set {
+			if loss := losses[target][k]; loss >= 0 && (best < 0 || loss < best) {
+				chosen, best = target, loss
+			}
+		}
+		build, ok := builds[chosen]
+		if !ok {
+			build = &Build{Target: chosen, Flags: compilerFlags(chosen)}
+			builds[chosen] = build
+		}
+		build.Nodes = append(build.Nodes, class.Nodes...)
+		build.Loss = max(build.Loss, best)
+		total += best * float64(len(class.Nodes))
+		recommendation.MaxLoss = max(recommendation.MaxLoss, best)
+	}
+	recommendation.Loss = total / float64(len(i.Nodes))
+
+	for _, build := range builds {
+		sort.Strings(build.Nodes)
+		recommendation.Builds = append(recommendation.Builds, *build)
+	}
+	sort.Slice(recommendation.Builds, func(a, b int) bool {
+		x, y := recommendation.Builds[a], recommendation.Builds[b]
+		if len(x.Nodes) != len(y.Nodes) {
+			return len(x.Nodes) > len(y.Nodes)
+		}
+		return x.Target < y.Target
+	})
+	return &recommendation
+}
+
+// compilerFlags says how gcc and clang build for a target
+func compilerFlags(target string) []CompilerFlag {
+	arch, ok := spec.LookupMicroarchitecture(target)
+	if !ok {
+		return nil
+	}
+	flags := []CompilerFlag{}
+	for _, compiler := range recommendCompilers {
+		if entry, ok := arch.CompilerFlags(compiler); ok {
+			flags = append(flags, CompilerFlag{Compiler: compiler, Versions: entry.Versions, Requires: entry.Requires(), Flags: entry.Flags})
+		}
+	}
+	return flags
+}
+
+// families are the architecture families of the nodes
+func (i *Inventory) families() []string {
+	families := []string{}
+	for _, class := range i.Classes {
+		if !utils.IncludesString(class.Arch, families) {
+			families = append(families, class.Arch)
+		}
+	}
+	return families
+}
+
+// combinations is n choose k, or more than we search once it is too large
+func combinations(n, k int) int {
+	result := 1
+	for j := 0; j < k; j++ {
+		result = result * (n - j) / (j + 1)
+		if result > maxCombinations {
+			return result
+		}
+	}
+	return result
+}
+
+// eachCombination calls a function with each set of k targets
+func eachCombination(targets []string, k int, call func([]string)) {
+	set := make([]string, k)
+	var choose func(start, depth int)
+	choose = func(start, depth int) {
+		if depth == k {
+			call(set)
+			return
+		}
+		for j := start; j <= len(targets)-(k-depth); j++ {
+			set[depth] = targets[j]
+			choose(j+1, depth+1)
+		}
+	}
+	choose(0, 0)
+}
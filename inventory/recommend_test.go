@@ -0,0 +1,141 @@
+package inventory
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vsoch/containerspec/spec"
+)
+
+// builds are the targets of a recommendation with their nodes
+func builds(recommendation *Recommendation) map[string]string {
+	result := map[string]string{}
+	for _, build := range recommendation.Builds {
+		result[build.Target] = strings.Join(build.Nodes, ",")
+	}
+	return result
+}
+
+func TestRecommend(t *testing.T) {
+	tests := []struct {
+		name         string
+		inventory    *Inventory
+		options      Options
+		builds       map[string]string
+		unrecognized []string
+		err          string
+	}{
+		{
+			name:      "optimal",
+			inventory: cluster(),
+			builds:    map[string]string{"haswell": "node01,node02,node03", "icelake": "node04", "graviton2": "node05"},
+		},
+		{
+			name:      "loss",
+			inventory: cluster(),
+			options:   Options{MaxLoss: 0.55},
+			builds:    map[string]string{"haswell": "node01,node02,node03,node04", "graviton2": "node05"},
+		},
+		{
+			name:      "images",
+			inventory: cluster(),
+			options:   Options{Images: 2},
+			builds:    map[string]string{"haswell": "node01,node02,node03,node04", "graviton2": "node05"},
+		},
+		{name: "too few images", inventory: cluster(), options: Options{Images: 1}, err: "2 architecture families"},
+		{
+			name:      "icelake loses too much on haswell",
+			inventory: cluster(),
+			options:   Options{MaxLoss: 0.5},
+			builds:    map[string]string{"haswell": "node01,node02,node03", "icelake": "node04", "graviton2": "node05"},
+		},
+		{
+			name:         "unrecognized",
+			inventory:    cluster("node06", "riscv64", "node07", "mips64le"),
+			builds:       map[string]string{"haswell": "node01,node02,node03", "icelake": "node04", "graviton2": "node05"},
+			unrecognized: []string{"node06", "node07"},
+		},
+		{name: "nothing recognized", inventory: New([]Node{{Name: "node06", Host: &spec.Host{Arch: "riscv64"}}}), err: "no node has an architecture in the CPU database (node06)"},
+	}
+	for _, test := range tests {
+		recommendation, err := test.inventory.Recommend(test.options)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected an error with %q, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if got := builds(recommendation); !reflect.DeepEqual(got, test.builds) {
+			t.Errorf("%s: expected builds %v, got %v", test.name, test.builds, got)
+		}
+		if !reflect.DeepEqual(recommendation.Unrecognized, test.unrecognized) {
+			t.Errorf("%s: expected unrecognized nodes %v, got %v", test.name, test.unrecognized, recommendation.Unrecognized)
+		}
+	}
+}
+
+func TestUndominated(t *testing.T) {
+	losses := map[string][]float64{
+		"a": {0, 0.5},
+		"b": {0.1, 0.6},
+		"c": {-1, 0},
+		"d": {0, 0.5},
+		"e": {0.2, -1},
+	}
+	kept := undominated([]string{"a", "b", "c", "d", "e"}, losses, 2)
+	if want := []string{"a", "c"}; !reflect.DeepEqual(kept, want) {
+		t.Errorf("expected %v, got %v", want, kept)
+	}
+}
+
+func TestAssign(t *testing.T) {
+	// Three haswell nodes in the first class, one icelake node in the second
+	inventory := New(cluster().Nodes[:4])
+	losses := map[string][]float64{"x": {0, 0.4}, "y": {-1, 0}, "z": {0.2, 0.2}}
+	tests := []struct {
+		set  []string
+		loss float64
+		most float64
+		fits bool
+	}{
+		{set: []string{"x"}, loss: 0.1, most: 0.4, fits: true},
+		{set: []string{"x", "y"}, loss: 0, most: 0, fits: true},
+		{set: []string{"y", "z"}, loss: 0.15, most: 0.2, fits: true},
+		{set: []string{"y"}},
+	}
+	for _, test := range tests {
+		loss, most, ok := inventory.assign(test.set, losses)
+		if ok != test.fits || !near(loss, test.loss) || !near(most, test.most) {
+			t.Errorf("%v: expected %g and %g (%v), got %g and %g (%v)", test.set, test.loss, test.most, test.fits, loss, most, ok)
+		}
+	}
+}
+
+func TestGreedy(t *testing.T) {
+	inventory := New(cluster().Nodes[:4])
+	losses := map[string][]float64{"x": {0, 0.4}, "y": {-1, 0}, "z": {0.2, 0.2}}
+	tests := []struct {
+		options Options
+		set     []string
+	}{
+		{options: Options{}, set: []string{"x", "y"}},
+		{options: Options{MaxLoss: 0.5}, set: []string{"x"}},
+		{options: Options{Images: 1}, set: []string{"x"}},
+		{options: Options{Images: 3}, set: []string{"x", "y", "z"}},
+	}
+	for _, test := range tests {
+		if set := inventory.greedy([]string{"x", "y", "z"}, losses, test.options); !reflect.DeepEqual(set, test.set) {
+			t.Errorf("%+v: expected %v, got %v", test.options, test.set, set)
+		}
+	}
+}
+
+// near compares losses, which are sums of fractions
+func near(a, b float64) bool {
+	return a-b < 1e-9 && b-a < 1e-9
+}
@@ -0,0 +1,237 @@
+package k8s
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vsoch/containerspec/compat"
+	"github.com/vsoch/containerspec/spec"
+	"github.com/vsoch/containerspec/utils"
+)
+
+// What an image needs becomes node affinity on the labels of NodeLabels. A
+// target runs on its descendants too, so we select any of them. Versions are
+// compared by major and minor numbers, and an alternative (e.g., a newer
+// major or the same major and a newer minor) is another term, because terms
+// are or'ed and the expressions of a term are and'ed.
+
+// ArchLabel is the well-known label of a node's architecture (its GOARCH)
+const ArchLabel = "kubernetes.io/arch"
+
+// Affinity is the affinity of a pod spec, as Kubernetes has it
+type Affinity struct {
+	NodeAffinity *NodeAffinity `json:"nodeAffinity,omitempty" yaml:"nodeAffinity,omitempty"`
+}
+
+// NodeAffinity requires nodes that match a selector
+type NodeAffinity struct {
+	Required *NodeSelector `json:"requiredDuringSchedulingIgnoredDuringExecution" yaml:"requiredDuringSchedulingIgnoredDuringExecution"`
+}
+
+// NodeSelector selects nodes that match any term
+type NodeSelector struct {
+	Terms []NodeSelectorTerm `json:"nodeSelectorTerms" yaml:"nodeSelectorTerms"`
+}
+
+// NodeSelectorTerm matches nodes that match every expression
+type NodeSelectorTerm struct {
+	MatchExpressions []Requirement `json:"matchExpressions" yaml:"matchExpressions"`
+}
+
+// Requirement is an expression on a label (In, NotIn, Exists,
+// DoesNotExist, Gt or Lt)
+type Requirement struct {
+	Key      string   `json:"key" yaml:"key"`
+	Operator string   `json:"operator" yaml:"operator"`
+	Values   []string `json:"values,omitempty" yaml:"values,omitempty"`
+}
+
+// alternatives are ways to meet one need, each a set of requirements
+type alternatives [][]Requirement
+
+// AffinityFor selects nodes that run a container: its architecture, its
+// target or a descendant, its CPU features, a glibc no newer than its own
+// if it binds host libraries, its minimum kernel, the same MPI (or none) and
+// its GPU with the compute capability it needs. It is nil if the container
+// doesn't need anything.
+func AffinityFor(container *compat.Container) *Affinity {
+	terms := [][]Requirement{{}}
+	for _, need := range needs(container) {
+		product := [][]Requirement{}
+		for _, term := range terms {
+			for _, alternative := range need {
+				product = append(product, append(append([]Requirement{}, term...), alternative...))
+			}
+		}
+		terms = product
+	}
+	if len(terms[0]) == 0 {
+		return nil
+	}
+
+	selector := NodeSelector{}
+	for _, term := range terms {
+		selector.Terms = append(selector.Terms, NodeSelectorTerm{MatchExpressions: term})
+	}
+	return &Affinity{NodeAffinity: &NodeAffinity{Required: &selector}}
+}
+
+// CheckArch makes sure the architecture of a container is known, since
+// without it we would select nodes of any architecture
+func CheckArch(container *compat.Container) error {
+	if goarchOf(container.Arch) == "" {
+		if container.Arch != "" {
+			return fmt.Errorf("the architecture %s doesn't have a Kubernetes name", container.Arch)
+		}
+		return fmt.Errorf("the architecture isn't known, give an image or labels with a target (labels generate --analyze)")
+	}
+	return nil
+}
+
+// SelectorFor selects nodes by equal labels only, as a nodeSelector does, so
+// it can't compare versions or select descendants of a target. It uses the
+// features of the target instead, and returns notes for what it can't select.
+func SelectorFor(container *compat.Container) (map[string]string, []string) {
+	selector := map[string]string{}
+	notes := []string{}
+	if goarch := goarchOf(container.Arch); goarch != "" {
+		selector[ArchLabel] = goarch
+	}
+	features := append([]string{}, container.Features...)
+	if arch, ok := spec.LookupMicroarchitecture(container.Target); ok {
+		features = append(features, targetFeatures(arch)...)
+	} else if container.Target != "" {
+		notes = append(notes, fmt.Sprintf("the target %s isn't in the CPU database", container.Target))
+	}
+	for _, feature := range features {
+		selector[Prefix+"cpu-feature."+labelValue(feature)] = "true"
+	}
+	if compat.BindsHostLibraries(container) && container.Glibc != "" {
+		notes = append(notes, fmt.Sprintf("nodes need glibc %s or older, which a node selector can't compare", container.Glibc))
+	}
+	if container.Kernel != "" {
+		notes = append(notes, fmt.Sprintf("nodes need kernel %s or newer, which a node selector can't compare", container.Kernel))
+	}
+	if compat.Needs(container.MPI) {
+		selector[Prefix+"mpi"] = labelValue(container.MPI)
+	}
+	if compat.Needs(container.GPU) {
+		selector[Prefix+"gpu."+labelValue(container.GPU)] = "true"
+		if container.GPU == "cuda" && container.CUDACapability != "" {
+			notes = append(notes, fmt.Sprintf("nodes need compute capability %s or newer, which a node selector can't compare", container.CUDACapability))
+		}
+	}
+	return selector, notes
+}
+
+// needs are the requirements of a container, each with its alternatives
+func needs(container *compat.Container) []alternatives {
+	result := []alternatives{}
+	if goarch := goarchOf(container.Arch); goarch != "" {
+		result = append(result, alternatives{{{Key: ArchLabel, Operator: "In", Values: []string{goarch}}}})
+	}
+	if arch, ok := spec.LookupMicroarchitecture(container.Target); ok && arch.Name != arch.Family().Name {
+		targets := []string{arch.Name}
+		for _, descendant := range arch.Descendants() {
+			targets = append(targets, descendant.Name)
+		}
+		sort.Strings(targets)
+		result = append(result, alternatives{{{Key: Prefix + "target", Operator: "In", Values: targets}}})
+	}
+	if len(container.Features) > 0 {
+		requirements := []Requirement{}
+		for _, feature := range container.Features {
+			requirements = append(requirements, Requirement{Key: Prefix + "cpu-feature." + labelValue(feature), Operator: "Exists"})
+		}
+		result = append(result, alternatives{requirements})
+	}
+	if compat.BindsHostLibraries(container) && container.Glibc != "" {
+		result = append(result, versionAtMost(Prefix+"glibc", container.Glibc))
+	}
+	if container.Kernel != "" {
+		result = append(result, versionAtLeast(Prefix+"kernel", container.Kernel))
+	}
+	if compat.Needs(container.MPI) {
+		result = append(result, alternatives{
+			{{Key: Prefix + "mpi", Operator: "In", Values: []string{labelValue(container.MPI)}}},
+			{{Key: Prefix + "mpi", Operator: "DoesNotExist"}},
+		})
+	}
+	if compat.Needs(container.GPU) {
+		gpu := []Requirement{{Key: Prefix + "gpu." + labelValue(container.GPU), Operator: "In", Values: []string{"true"}}}
+		if container.GPU == "cuda" && container.CUDACapability != "" {
+			capability := alternatives{}
+			for _, alternative := range versionAtLeast(Prefix+"cuda.capability", container.CUDACapability) {
+				capability = append(capability, append(append([]Requirement{}, gpu...), alternative...))
+			}
+			result = append(result, capability)
+		} else {
+			result = append(result, alternatives{gpu})
+		}
+	}
+	return result
+}
+
+// versionAtLeast selects nodes with a version label at least a version: a
+// newer major, or the same major and at least the minor
+func versionAtLeast(label, version string) alternatives {
+	major, minor := majorMinor(version)
+	if minor == 0 {
+		return alternatives{{{Key: label + ".major", Operator: "Gt", Values: []string{strconv.Itoa(major - 1)}}}}
+	}
+	return alternatives{
+		{{Key: label + ".major", Operator: "Gt", Values: []string{strconv.Itoa(major)}}},
+		{
+			{Key: label + ".major", Operator: "In", Values: []string{strconv.Itoa(major)}},
+			{Key: label + ".minor", Operator: "Gt", Values: []string{strconv.Itoa(minor - 1)}},
+		},
+	}
+}
+
+// versionAtMost selects nodes with a version label at most a version: an
+// older major, or the same major and at most the minor
+func versionAtMost(label, version string) alternatives {
+	major, minor := majorMinor(version)
+	return alternatives{
+		{{Key: label + ".major", Operator: "Lt", Values: []string{strconv.Itoa(major)}}},
+		{
+			{Key: label + ".major", Operator: "In", Values: []string{strconv.Itoa(major)}},
+			{Key: label + ".minor", Operator: "Lt", Values: []string{strconv.Itoa(minor + 1)}},
+		},
+	}
+}
+
+// majorMinor returns the first two numbers of a version
+func majorMinor(version string) (int, int) {
+	parts := strings.Split(version, ".")
+	major, _ := strconv.Atoi(numberRegex.FindString(parts[0]))
+	minor := 0
+	if len(parts) > 1 {
+		minor, _ = strconv.Atoi(numberRegex.FindString(parts[1]))
+	}
+	return major, minor
+}
+
+// goarchOf is the GOARCH of an architecture family, the value of ArchLabel
+func goarchOf(family string) string {
+	if platform, ok := spec.PlatformOf(family); ok {
+		return platform.GOARCH
+	}
+	return ""
+}
+
+// targetFeatures are the features of a target and its ancestors
+func targetFeatures(arch spec.Microarchitecture) []string {
+	features := []string{}
+	for _, a := range append([]spec.Microarchitecture{arch}, arch.Ancestors()...) {
+		for _, feature := range a.Features {
+			if !utils.IncludesString(feature, features) {
+				features = append(features, feature)
+			}
+		}
+	}
+	sort.Strings(features)
+	return features
+}
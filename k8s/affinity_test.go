@@ -0,0 +1,177 @@
+package k8s
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/vsoch/containerspec/compat"
+	"github.com/vsoch/containerspec/spec"
+	"github.com/vsoch/containerspec/utils"
+)
+
+// schedules evaluates node affinity on the labels of a node, as the
+// scheduler does: any term, with every expression of the term
+func schedules(affinity *Affinity, labels map[string]string) bool {
+	for _, term := range affinity.NodeAffinity.Required.Terms {
+		matched := true
+		for _, requirement := range term.MatchExpressions {
+			value, ok := labels[requirement.Key]
+			switch requirement.Operator {
+			case "In":
+				matched = matched && ok && utils.IncludesString(value, requirement.Values)
+			case "NotIn":
+				matched = matched && !(ok && utils.IncludesString(value, requirement.Values))
+			case "Exists":
+				matched = matched && ok
+			case "DoesNotExist":
+				matched = matched && !ok
+			case "Gt", "Lt":
+				number, err := strconv.Atoi(value)
+				bound, _ := strconv.Atoi(requirement.Values[0])
+				if requirement.Operator == "Gt" {
+					matched = matched && ok && err == nil && number > bound
+				} else {
+					matched = matched && ok && err == nil && number < bound
+				}
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeLabels are the labels of a node, with the architecture kubelet sets
+func nodeLabels(host *spec.Host) map[string]string {
+	labels := NodeLabels(host)
+	labels[ArchLabel] = goarchOf(host.Arch)
+	return labels
+}
+
+func TestVersionAlternatives(t *testing.T) {
+	tests := []struct {
+		version  string
+		atLeast  alternatives
+		atMost   alternatives
+		versions map[string][2]bool
+	}{
+		{
+			version: "2.28",
+			atLeast: alternatives{
+				{{Key: "v.major", Operator: "Gt", Values: []string{"2"}}},
+				{{Key: "v.major", Operator: "In", Values: []string{"2"}}, {Key: "v.minor", Operator: "Gt", Values: []string{"27"}}},
+			},
+			atMost: alternatives{
+				{{Key: "v.major", Operator: "Lt", Values: []string{"2"}}},
+				{{Key: "v.major", Operator: "In", Values: []string{"2"}}, {Key: "v.minor", Operator: "Lt", Values: []string{"29"}}},
+			},
+			// Each version is at least and at most 2.28
+			versions: map[string][2]bool{
+				"2.17": {false, true}, "2.28": {true, true}, "2.28.1": {true, true},
+				"2.35": {true, false}, "3.0": {true, false}, "1.40": {false, true},
+			},
+		},
+		{
+			version: "5",
+			atLeast: alternatives{{{Key: "v.major", Operator: "Gt", Values: []string{"4"}}}},
+			atMost: alternatives{
+				{{Key: "v.major", Operator: "Lt", Values: []string{"5"}}},
+				{{Key: "v.major", Operator: "In", Values: []string{"5"}}, {Key: "v.minor", Operator: "Lt", Values: []string{"1"}}},
+			},
+			versions: map[string][2]bool{
+				"4.18": {false, true}, "5.0": {true, true}, "5.14": {true, false}, "6.1": {true, false},
+			},
+		},
+	}
+	for _, test := range tests {
+		atLeast, atMost := versionAtLeast("v", test.version), versionAtMost("v", test.version)
+		if !reflect.DeepEqual(atLeast, test.atLeast) {
+			t.Errorf("%s: expected at least %v, got %v", test.version, test.atLeast, atLeast)
+		}
+		if !reflect.DeepEqual(atMost, test.atMost) {
+			t.Errorf("%s: expected at most %v, got %v", test.version, test.atMost, atMost)
+		}
+		for version, want := range test.versions {
+			labels := map[string]string{}
+			setVersion(func(name, value string) { labels[name] = value }, "v", version)
+			for i, a := range []alternatives{atLeast, atMost} {
+				selector := NodeSelector{}
+				for _, term := range a {
+					selector.Terms = append(selector.Terms, NodeSelectorTerm{MatchExpressions: term})
+				}
+				if got := schedules(&Affinity{NodeAffinity: &NodeAffinity{Required: &selector}}, labels); got != want[i] {
+					t.Errorf("%s: expected %s to match %v, got %v", []string{"at least", "at most"}[i]+" "+test.version, version, want[i], got)
+				}
+			}
+		}
+	}
+}
+
+func TestAffinityFor(t *testing.T) {
+	container := &compat.Container{Arch: "x86_64", Target: "haswell", Glibc: "2.28", Kernel: "4.18", MPI: "openmpi", GPU: "cuda", CUDACapability: "7.0"}
+	affinity := AffinityFor(container)
+	if affinity == nil {
+		t.Fatal("expected affinity")
+	}
+
+	node := func(change func(*spec.Host)) *spec.Host {
+		host := &spec.Host{
+			Arch: "x86_64", Target: "skylake", Glibc: "2.17", Kernel: "5.14.0",
+			MPI: &spec.MPI{Family: "openmpi", Version: "4.1.5"}, GPU: []string{"cuda"}, CUDACapability: "8.0",
+		}
+		change(host)
+		return host
+	}
+	tests := []struct {
+		name      string
+		host      *spec.Host
+		schedules bool
+	}{
+		{name: "descendant of the target", host: node(func(h *spec.Host) {}), schedules: true},
+		{name: "the target", host: node(func(h *spec.Host) { h.Target = "haswell" }), schedules: true},
+		{name: "older target", host: node(func(h *spec.Host) { h.Target = "ivybridge" }), schedules: false},
+		{name: "other architecture", host: node(func(h *spec.Host) { h.Arch, h.Target = "aarch64", "neoverse_v1" }), schedules: false},
+		{name: "same glibc", host: node(func(h *spec.Host) { h.Glibc = "2.28" }), schedules: true},
+		{name: "newer glibc", host: node(func(h *spec.Host) { h.Glibc = "2.34" }), schedules: false},
+		{name: "older kernel", host: node(func(h *spec.Host) { h.Kernel = "3.10.0" }), schedules: false},
+		{name: "no mpi", host: node(func(h *spec.Host) { h.MPI = nil }), schedules: true},
+		{name: "other mpi", host: node(func(h *spec.Host) { h.MPI.Family = "mpich" }), schedules: false},
+		{name: "older compute capability", host: node(func(h *spec.Host) { h.CUDACapability = "6.1" }), schedules: false},
+		{name: "no gpu", host: node(func(h *spec.Host) { h.GPU, h.CUDACapability = nil, "" }), schedules: false},
+	}
+	for _, test := range tests {
+		if got := schedules(affinity, nodeLabels(test.host)); got != test.schedules {
+			t.Errorf("%s: expected %v, got %v", test.name, test.schedules, got)
+		}
+	}
+}
+
+func TestCheckArch(t *testing.T) {
+	tests := []struct {
+		container *compat.Container
+		ok        bool
+	}{
+		{container: compat.FromLabels(map[string]string{"org.supercontainers.target": "zen2"}), ok: true},
+		{container: compat.FromLabels(map[string]string{"org.supercontainers.glibc": "2.28", "org.supercontainers.os": "ubuntu"}), ok: false},
+		{container: &compat.Container{Arch: "aarch64"}, ok: true},
+		{container: &compat.Container{Arch: "sparc"}, ok: false},
+	}
+	for _, test := range tests {
+		if err := CheckArch(test.container); (err == nil) != test.ok {
+			t.Errorf("%+v: expected ok %v, got %v", test.container, test.ok, err)
+		}
+	}
+}
+
+func TestSelectorFor(t *testing.T) {
+	selector, notes := SelectorFor(&compat.Container{Arch: "aarch64", Features: []string{"sve"}, Kernel: "5.4", MPI: "unknown"})
+	want := map[string]string{ArchLabel: "arm64", Prefix + "cpu-feature.sve": "true"}
+	if !reflect.DeepEqual(selector, want) {
+		t.Errorf("expected selector %v, got %v", want, selector)
+	}
+	if len(notes) != 1 {
+		t.Errorf("expected a note for the kernel, got %v", notes)
+	}
+}
@@ -0,0 +1,107 @@
+package k8s
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/vsoch/containerspec/spec"
+)
+
+// Kubernetes schedules on node labels, so a host spec becomes labels that
+// kubectl label or Node Feature Discovery (NFD) can set. They are in the NFD
+// namespace, so NFD accepts them from a local feature file without settings.
+// Versions are also split into major and minor, because node affinity only
+// compares integers (Gt and Lt).
+
+// Prefix of the labels
+const Prefix = "feature.node.kubernetes.io/supercontainers."
+
+// FeatureFile is where NFD reads local features from
+const FeatureFile = "/etc/kubernetes/node-feature-discovery/features.d/containerspec"
+
+var (
+	// A label value is at most 63 characters, alphanumeric at the ends
+	invalidValue = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+	numberRegex  = regexp.MustCompile(`^[0-9]+`)
+)
+
+// NodeLabels are the labels of a node with a host spec
+func NodeLabels(host *spec.Host) map[string]string {
+	labels := map[string]string{}
+	set := func(name, value string) {
+		if value = labelValue(value); value != "" {
+			labels[Prefix+name] = value
+		}
+	}
+	set("arch", host.Arch)
+	set("target", host.Target)
+	set("vendor", host.Vendor)
+	for _, feature := range host.Features {
+		set("cpu-feature."+labelValue(feature), "true")
+	}
+	setVersion(set, "glibc", host.Glibc)
+	setVersion(set, "kernel", host.Kernel)
+	if host.MPI != nil {
+		set("mpi", host.MPI.Family)
+		set("mpi.version", host.MPI.Version)
+	}
+	for _, gpu := range host.GPU {
+		set("gpu."+labelValue(gpu), "true")
+	}
+	setVersion(set, "cuda.capability", host.CUDACapability)
+	return labels
+}
+
+// setVersion sets a version, and its major and minor numbers
+func setVersion(set func(string, string), name, version string) {
+	if version == "" {
+		return
+	}
+	set(name, version)
+	parts := strings.Split(version, ".")
+	set(name+".major", numberRegex.FindString(parts[0]))
+	if len(parts) > 1 {
+		set(name+".minor", numberRegex.FindString(parts[1]))
+	}
+}
+
+// labelValue makes a value valid for a label
+func labelValue(value string) string {
+	value = invalidValue.ReplaceAllString(value, "-")
+	if len(value) > 63 {
+		value = value[:63]
+	}
+	return strings.Trim(value, "._-")
+}
+
+// FormatLabels writes labels as sorted key=value lines, which is what kubectl
+// label takes and an NFD feature file has
+func FormatLabels(labels map[string]string) string {
+	keys := []string{}
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var builder strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&builder, "%s=%s\n", key, labels[key])
+	}
+	return builder.String()
+}
+
+// WriteFeatureFile writes labels to an NFD feature file. NFD may read it at
+// any time, so we write another file and rename it.
+func WriteFeatureFile(path string, labels map[string]string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	temporary := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err := os.WriteFile(temporary, []byte(FormatLabels(labels)), 0644); err != nil {
+		return err
+	}
+	return os.Rename(temporary, path)
+}
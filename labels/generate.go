@@ -0,0 +1,67 @@
+package labels
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/vsoch/containerspec/spec"
+	"github.com/vsoch/containerspec/utils"
+)
+
+// GPU runtimes in order of preference when more than one is found
+var gpuPreference = []string{"cuda", "rocm", "opencl"}
+
+// Generate returns supercontainers labels for what was detected in a
+// container root file system. Values that don't validate are left out.
+func Generate(info *spec.Rootfs) map[string]string {
+	values := map[string]string{
+		"org.supercontainers.target":     info.Target,
+		"org.supercontainers.glibc":      info.Glibc,
+		"org.supercontainers.mpi":        info.MPI,
+		"org.supercontainers.os":         info.OS,
+		"org.supercontainers.os.version": info.OSVersion,
+	}
+	for _, gpu := range gpuPreference {
+		if utils.IncludesString(gpu, info.GPU) {
+			values["org.supercontainers.gpu"] = gpu
+			break
+		}
+	}
+
+	generated := map[string]string{}
+	for key, value := range values {
+		if value != "" && Validate(key, value) == nil {
+			generated[key] = value
+		}
+	}
+	return generated
+}
+
+// Dockerfile formats labels as LABEL lines, sorted by key
+func Dockerfile(generated map[string]string) string {
+	lines := []string{}
+	for _, key := range sortedKeys(generated) {
+		lines = append(lines, fmt.Sprintf("LABEL %s=%q", key, generated[key]))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Annotations formats labels as an OCI annotations JSON object
+func Annotations(generated map[string]string) (string, error) {
+	content, err := json.MarshalIndent(generated, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := []string{}
+	for _, key := range Keys() {
+		if _, ok := values[key]; ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
@@ -1,5 +1,15 @@
 package labels
 
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/vsoch/containerspec/spec"
+	"github.com/vsoch/containerspec/utils"
+)
+
 // Labels for the supercontainers metadata
 
 type Label struct {
@@ -7,24 +17,211 @@ type Label struct {
 	Value       string
 	Allowed     []string
 	Description string
+
+	// Multiple values are given as a comma separated list
+	List bool
+
+	// Required labels must be present on every image (see lint)
+	Required bool
+
+	// Validate checks a value beyond what Allowed can express
+	Validate func(value string) error
 }
 
+var (
+	versionRegex    = regexp.MustCompile(`^[0-9]+\.[0-9]+(\.[0-9]+)?$`)
+	capabilityRegex = regexp.MustCompile(`^[0-9]+\.[0-9]+$`)
+	gfxRegex        = regexp.MustCompile(`^gfx[0-9a-f]{3,4}$`)
+	osVersionRegex  = regexp.MustCompile(`^[0-9]+(\.[0-9]+)*$`)
+)
+
+// Providers that can be named as libfabric:<provider>
+var libfabricProviders = []string{"cxi", "efa", "gni", "opx", "psm2", "psm3", "shm", "sockets", "tcp", "udp", "verbs"}
+
 var Labels = map[string]Label{
 	"org.supercontainers.mpi": {
 		Key:         "org.supercontainers.mpi",
+		Required:    true,
 		Allowed:     []string{"mpich", "openmpi", "unknown"},
 		Description: "Required MPI support, ABI compatibility",
 	},
 
 	"org.supercontainers.gpu": {
 		Key:         "org.supercontainers.gpu",
+		Required:    true,
 		Allowed:     []string{"cuda", "opencl", "rocm", "unknown"},
 		Description: "Required GPU library support",
 	},
 
-	// TODO this can be validated by regex for semver when parsed
 	"org.supercontainers.glibc": {
-		Key:         "org.supercontainers.gpu",
+		Key:         "org.supercontainers.glibc",
+		Required:    true,
 		Description: "Specific version of GLIBC, in Semantic format XX.YY.Z",
+		Validate:    validateVersion,
+	},
+
+	"org.supercontainers.target": {
+		Key:         "org.supercontainers.target",
+		Required:    true,
+		Description: "Target microarchitecture the image was built for, from the CPU database",
+		Validate:    validateTarget,
+	},
+
+	"org.supercontainers.features": {
+		Key:         "org.supercontainers.features",
+		Description: "Required CPU features, comma separated (e.g., avx2,fma)",
+		List:        true,
+		Validate:    validateFeature,
+	},
+
+	"org.supercontainers.kernel": {
+		Key:         "org.supercontainers.kernel",
+		Description: "Minimum Linux kernel version, XX.YY or XX.YY.Z",
+		Validate:    validateVersion,
+	},
+
+	"org.supercontainers.interconnect": {
+		Key:         "org.supercontainers.interconnect",
+		Description: "Required interconnect support, comma separated (ucx, verbs, libfabric or libfabric:<provider>)",
+		List:        true,
+		Validate:    validateInterconnect,
+	},
+
+	"org.supercontainers.cuda.capability": {
+		Key:         "org.supercontainers.cuda.capability",
+		Description: "Minimum CUDA compute capability, XX.Y (e.g., 7.0)",
+		Validate: func(value string) error {
+			if !capabilityRegex.MatchString(value) {
+				return fmt.Errorf("%s is not a compute capability in the format XX.Y", value)
+			}
+			return nil
+		},
+	},
+
+	"org.supercontainers.rocm.gfx": {
+		Key:         "org.supercontainers.rocm.gfx",
+		Description: "ROCm gfx targets the image has code objects for, comma separated (e.g., gfx90a,gfx942)",
+		List:        true,
+		Validate: func(value string) error {
+			if !gfxRegex.MatchString(value) {
+				return fmt.Errorf("%s is not a ROCm gfx target (e.g., gfx90a)", value)
+			}
+			return nil
+		},
+	},
+
+	"org.supercontainers.os": {
+		Key:         "org.supercontainers.os",
+		Allowed:     []string{"almalinux", "alpine", "amzn", "centos", "debian", "fedora", "opensuse-leap", "rhel", "rocky", "sles", "ubuntu", "unknown"},
+		Description: "Operating system distribution, the ID from /etc/os-release",
+	},
+
+	"org.supercontainers.os.version": {
+		Key:         "org.supercontainers.os.version",
+		Description: "Operating system version, the VERSION_ID from /etc/os-release",
+		Validate: func(value string) error {
+			if !osVersionRegex.MatchString(value) {
+				return fmt.Errorf("%s is not a distribution version (e.g., 8 or 20.04)", value)
+			}
+			return nil
+		},
 	},
 }
+
+// Check determines if a value is valid for the label
+func (l Label) Check(value string) error {
+	values := []string{strings.TrimSpace(value)}
+	if l.List {
+		values = Split(value)
+	}
+	if len(values) == 0 || values[0] == "" {
+		return fmt.Errorf("%s cannot be empty", l.Key)
+	}
+	for _, v := range values {
+		if len(l.Allowed) > 0 && !utils.IncludesString(v, l.Allowed) {
+			return fmt.Errorf("%s is not an allowed value for %s, choices are %s", v, l.Key, strings.Join(l.Allowed, ","))
+		}
+		if l.Validate != nil {
+			if err := l.Validate(v); err != nil {
+				return fmt.Errorf("%s: %s", l.Key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Validate checks a key and value against the known supercontainers labels
+func Validate(key, value string) error {
+	label, ok := Labels[key]
+	if !ok {
+		return fmt.Errorf("%s is not a known supercontainers label", key)
+	}
+	return label.Check(value)
+}
+
+// Keys returns the known label keys in sorted order
+func Keys() []string {
+	keys := []string{}
+	for key := range Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// RequiredKeys returns the keys of labels every image must have, sorted
+func RequiredKeys() []string {
+	keys := []string{}
+	for _, key := range Keys() {
+		if Labels[key].Required {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Split a comma separated label value into trimmed, non-empty values
+func Split(value string) []string {
+	values := []string{}
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func validateVersion(value string) error {
+	if !versionRegex.MatchString(value) {
+		return fmt.Errorf("%s is not a version in the format XX.YY or XX.YY.Z", value)
+	}
+	return nil
+}
+
+func validateTarget(value string) error {
+	if _, ok := spec.LookupMicroarchitecture(value); !ok {
+		return fmt.Errorf("%s is not a microarchitecture in the CPU database", value)
+	}
+	return nil
+}
+
+func validateFeature(value string) error {
+	if !spec.KnownFeature(value) {
+		return fmt.Errorf("%s is not a CPU feature in the CPU database", value)
+	}
+	return nil
+}
+
+func validateInterconnect(value string) error {
+	switch {
+	case value == "ucx", value == "verbs", value == "libfabric", value == "unknown":
+		return nil
+	case strings.HasPrefix(value, "libfabric:"):
+		provider := strings.TrimPrefix(value, "libfabric:")
+		if utils.IncludesString(provider, libfabricProviders) {
+			return nil
+		}
+		return fmt.Errorf("%s is not a known libfabric provider, choices are %s", provider, strings.Join(libfabricProviders, ","))
+	}
+	return fmt.Errorf("%s is not a known interconnect (ucx, verbs, libfabric, libfabric:<provider>)", value)
+}
@@ -0,0 +1,285 @@
+package libs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+
+	"github.com/vsoch/containerspec/rootfs"
+)
+
+// ld.so doesn't search the ld.so.conf directories, ldconfig does: it writes
+// every library it finds to etc/ld.so.cache, and the loader only looks there.
+// See sysdeps/generic/dl-cache.h in glibc for the formats.
+
+const (
+	// The old format (libc5 era) is a header and a table of entries
+	cacheMagicOld   = "ld.so-1.7.0"
+	cacheHeaderOld  = 16
+	cacheEntrySzOld = 12
+
+	// The new format (glibc >= 2.2) adds an OS version and hardware
+	// capabilities. Until glibc 2.32 it was appended to the old one (compat)
+	cacheMagicNew   = "glibc-ld.so.cache1.1"
+	cacheHeaderNew  = 48
+	cacheEntrySzNew = 24
+
+	// Endianness is recorded in the flags of the new header (glibc >= 2.33)
+	cacheEndianMask   = 3
+	cacheEndianLittle = 2
+	cacheEndianBig    = 3
+
+	// Extensions after the strings hold the generator and glibc-hwcaps names
+	cacheExtensionMagic = 0xeaa42174
+	cacheTagGenerator   = 0
+	cacheTagHWCaps      = 1
+
+	// An entry with this hwcap (in the high bits) is in a glibc-hwcaps
+	// subdirectory, and the low 32 bits index the names of the extension
+	cacheHWCapExtension = uint64(1) << 62
+)
+
+// CacheEntry is a library in ld.so.cache
+type CacheEntry struct {
+	Name      string `json:"name"`
+	Path      string `json:"path"`
+	Flags     int32  `json:"flags"`
+	OSVersion uint32 `json:"os_version,omitempty"`
+
+	// HWCap is the legacy hardware capability mask, and HWCaps the
+	// glibc-hwcaps subdirectory (e.g., x86-64-v3) the library is in
+	HWCap  uint64 `json:"hwcap,omitempty"`
+	HWCaps string `json:"hwcaps,omitempty"`
+}
+
+// Cache is a parsed ld.so.cache
+type Cache struct {
+	// Format is old, new or compat (old followed by new)
+	Format    string       `json:"format"`
+	Generator string       `json:"generator,omitempty"`
+	Entries   []CacheEntry `json:"entries"`
+
+	index map[string][]int
+}
+
+// ReadCache parses etc/ld.so.cache of a root file system
+func ReadCache(fsys fs.FS) (*Cache, error) {
+	file, err := rootfs.Open(fsys, "etc/ld.so.cache")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCache(content)
+}
+
+// ParseCache parses the old, new or compat format of ld.so.cache. Entries
+// are in the order ldconfig wrote them, the order the loader prefers them.
+func ParseCache(content []byte) (*Cache, error) {
+	cache := Cache{}
+	switch {
+	case bytes.HasPrefix(content, []byte(cacheMagicOld)):
+		order, nlibs, err := oldCacheHeader(content)
+		if err != nil {
+			return nil, err
+		}
+		// A new cache follows the old one, aligned for its 64 bit hwcap
+		offset := align(cacheHeaderOld+nlibs*cacheEntrySzOld, 8)
+		if offset < len(content) && bytes.HasPrefix(content[offset:], []byte(cacheMagicNew)) {
+			cache.Format = "compat"
+			err = cache.parseNew(content, offset)
+		} else {
+			cache.Format = "old"
+			err = cache.parseOld(content, order, nlibs)
+		}
+		if err != nil {
+			return nil, err
+		}
+	case bytes.HasPrefix(content, []byte(cacheMagicNew)):
+		cache.Format = "new"
+		if err := cache.parseNew(content, 0); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("not an ld.so.cache file")
+	}
+
+	cache.index = map[string][]int{}
+	for i, entry := range cache.Entries {
+		cache.index[entry.Name] = append(cache.index[entry.Name], i)
+	}
+	return &cache, nil
+}
+
+// oldCacheHeader reads the number of entries of the old format, which has
+// no byte order: it is the one where the entries fit in the file
+func oldCacheHeader(content []byte) (binary.ByteOrder, int, error) {
+	if len(content) < cacheHeaderOld {
+		return nil, 0, fmt.Errorf("ld.so.cache is truncated")
+	}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		nlibs := int(order.Uint32(content[12:]))
+		if nlibs <= (len(content)-cacheHeaderOld)/cacheEntrySzOld {
+			return order, nlibs, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("ld.so.cache has more entries than fit in the file")
+}
+
+// parseOld reads entries with names relative to the strings after them
+func (c *Cache) parseOld(content []byte, order binary.ByteOrder, nlibs int) error {
+	table := content[cacheHeaderOld+nlibs*cacheEntrySzOld:]
+	for i := 0; i < nlibs; i++ {
+		entry := content[cacheHeaderOld+i*cacheEntrySzOld:]
+		name, err := cacheString(table, order.Uint32(entry[4:]))
+		if err != nil {
+			return err
+		}
+		value, err := cacheString(table, order.Uint32(entry[8:]))
+		if err != nil {
+			return err
+		}
+		c.Entries = append(c.Entries, CacheEntry{
+			Name:  name,
+			Path:  value,
+			Flags: int32(order.Uint32(entry)),
+		})
+	}
+	return nil
+}
+
+// parseNew reads the new cache at an offset of the file, where names are
+// relative to the start of the new header
+func (c *Cache) parseNew(file []byte, offset int) error {
+	content := file[offset:]
+	if len(content) < cacheHeaderNew {
+		return fmt.Errorf("ld.so.cache is truncated")
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	switch content[28] & cacheEndianMask {
+	case cacheEndianBig:
+		order = binary.BigEndian
+	case cacheEndianLittle:
+	default:
+		// Written before glibc 2.33, use the order where the entries fit
+		if int(order.Uint32(content[20:])) > (len(content)-cacheHeaderNew)/cacheEntrySzNew {
+			order = binary.BigEndian
+		}
+	}
+	nlibs := int(order.Uint32(content[20:]))
+	if nlibs > (len(content)-cacheHeaderNew)/cacheEntrySzNew {
+		return fmt.Errorf("ld.so.cache has more entries than fit in the file")
+	}
+
+	hwcaps, err := c.parseExtensions(file, content, order)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < nlibs; i++ {
+		entry := content[cacheHeaderNew+i*cacheEntrySzNew:]
+		name, err := cacheString(content, order.Uint32(entry[4:]))
+		if err != nil {
+			return err
+		}
+		value, err := cacheString(content, order.Uint32(entry[8:]))
+		if err != nil {
+			return err
+		}
+		parsed := CacheEntry{
+			Name:      name,
+			Path:      value,
+			Flags:     int32(order.Uint32(entry)),
+			OSVersion: order.Uint32(entry[12:]),
+			HWCap:     order.Uint64(entry[16:]),
+		}
+		if parsed.HWCap>>32 == cacheHWCapExtension>>32 {
+			index := int(uint32(parsed.HWCap))
+			if index >= len(hwcaps) {
+				return fmt.Errorf("ld.so.cache entry %s has an unknown glibc-hwcaps index %d", name, index)
+			}
+			parsed.HWCaps = hwcaps[index]
+			parsed.HWCap = 0
+		}
+		c.Entries = append(c.Entries, parsed)
+	}
+	return nil
+}
+
+// parseExtensions reads the generator and the names of the glibc-hwcaps
+// subdirectories (glibc >= 2.33), if there are extensions. Extensions are
+// at offsets of the file, but names are in the strings of the new cache.
+func (c *Cache) parseExtensions(file, content []byte, order binary.ByteOrder) ([]string, error) {
+	offset := int(order.Uint32(content[32:]))
+	if offset == 0 || offset+8 > len(file) || order.Uint32(file[offset:]) != cacheExtensionMagic {
+		return nil, nil
+	}
+	count := int(order.Uint32(file[offset+4:]))
+	if count > (len(file)-offset-8)/16 {
+		return nil, fmt.Errorf("ld.so.cache has more extensions than fit in the file")
+	}
+	hwcaps := []string{}
+	for i := 0; i < count; i++ {
+		section := file[offset+8+i*16:]
+		start, size := int(order.Uint32(section[8:])), int(order.Uint32(section[12:]))
+		if start+size > len(file) {
+			return nil, fmt.Errorf("ld.so.cache extension is truncated")
+		}
+		switch order.Uint32(section) {
+		case cacheTagGenerator:
+			c.Generator = string(file[start : start+size])
+		case cacheTagHWCaps:
+			for j := 0; j+4 <= size; j += 4 {
+				name, err := cacheString(content, order.Uint32(file[start+j:]))
+				if err != nil {
+					return nil, err
+				}
+				hwcaps = append(hwcaps, name)
+			}
+		}
+	}
+	return hwcaps, nil
+}
+
+// cacheString reads a null terminated string at an offset
+func cacheString(content []byte, offset uint32) (string, error) {
+	if int(offset) >= len(content) {
+		return "", fmt.Errorf("ld.so.cache string at %d is out of bounds", offset)
+	}
+	value := content[offset:]
+	if end := bytes.IndexByte(value, 0); end >= 0 {
+		value = value[:end]
+	}
+	return string(value), nil
+}
+
+// align rounds an offset up to a multiple of size
+func align(offset, size int) int {
+	return (offset + size - 1) &^ (size - 1)
+}
+
+// Lookup returns the entries for a library name in the order the loader
+// tries them: the glibc-hwcaps subdirectories the CPU supports (hwcaps, in
+// order of preference), then libraries that don't need any capability.
+// Entries with legacy hwcap bits can't be checked without the CPU, and are
+// skipped as newer loaders do.
+func (c *Cache) Lookup(name string, hwcaps []string) []CacheEntry {
+	entries := []CacheEntry{}
+	for _, subdir := range hwcaps {
+		for _, i := range c.index[name] {
+			if c.Entries[i].HWCaps == subdir {
+				entries = append(entries, c.Entries[i])
+			}
+		}
+	}
+	for _, i := range c.index[name] {
+		if c.Entries[i].HWCaps == "" && c.Entries[i].HWCap == 0 {
+			entries = append(entries, c.Entries[i])
+		}
+	}
+	return entries
+}
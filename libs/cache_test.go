@@ -0,0 +1,233 @@
+package libs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// cacheStrings lays out null terminated strings after a table, with
+// offsets from base (the start of the table for the old format, of the
+// header for the new one)
+type cacheStrings struct {
+	base    int
+	data    bytes.Buffer
+	offsets map[string]uint32
+}
+
+func (s *cacheStrings) offset(value string) uint32 {
+	if s.offsets == nil {
+		s.offsets = map[string]uint32{}
+	}
+	if offset, ok := s.offsets[value]; ok {
+		return offset
+	}
+	s.offsets[value] = uint32(s.base + s.data.Len())
+	s.data.WriteString(value + "\x00")
+	return s.offsets[value]
+}
+
+// oldCache writes entries in the old format
+func oldCache(order binary.ByteOrder, entries []CacheEntry) []byte {
+	var cache bytes.Buffer
+	cache.WriteString(cacheMagicOld + "\x00")
+	binary.Write(&cache, order, uint32(len(entries)))
+	text := cacheStrings{}
+	for _, entry := range entries {
+		binary.Write(&cache, order, []uint32{uint32(entry.Flags), text.offset(entry.Name), text.offset(entry.Path)})
+	}
+	cache.Write(text.data.Bytes())
+	return cache.Bytes()
+}
+
+// newCache writes entries in the new format, to start at offset of a file,
+// with extensions for a generator and glibc-hwcaps unless both are empty
+func newCache(order binary.ByteOrder, flags uint8, offset int, entries []CacheEntry, generator string, hwcaps []string) []byte {
+	text := cacheStrings{base: cacheHeaderNew + len(entries)*cacheEntrySzNew}
+	var table bytes.Buffer
+	for _, entry := range entries {
+		hwcap := entry.HWCap
+		for i, subdir := range hwcaps {
+			if entry.HWCaps == subdir {
+				hwcap = cacheHWCapExtension | uint64(i)
+			}
+		}
+		binary.Write(&table, order, []uint32{uint32(entry.Flags), text.offset(entry.Name), text.offset(entry.Path), entry.OSVersion})
+		binary.Write(&table, order, hwcap)
+	}
+	names := []uint32{}
+	for _, subdir := range hwcaps {
+		names = append(names, text.offset(subdir))
+	}
+
+	end := align(text.base+text.data.Len(), 4)
+	extensionOffset := 0
+	var extensions bytes.Buffer
+	if generator != "" || len(hwcaps) > 0 {
+		extensionOffset = offset + end
+		data := offset + end + 8 + 2*16
+		binary.Write(&extensions, order, []uint32{cacheExtensionMagic, 2})
+		binary.Write(&extensions, order, []uint32{cacheTagGenerator, 0, uint32(data), uint32(len(generator))})
+		binary.Write(&extensions, order, []uint32{cacheTagHWCaps, 0, uint32(data + len(generator)), uint32(4 * len(names))})
+		extensions.WriteString(generator)
+		binary.Write(&extensions, order, names)
+	}
+
+	var cache bytes.Buffer
+	cache.WriteString(cacheMagicNew)
+	binary.Write(&cache, order, []uint32{uint32(len(entries)), uint32(text.data.Len())})
+	cache.Write([]byte{flags, 0, 0, 0})
+	binary.Write(&cache, order, []uint32{uint32(extensionOffset), 0, 0, 0})
+	cache.Write(table.Bytes())
+	cache.Write(text.data.Bytes())
+	cache.Write(make([]byte, end-cache.Len()))
+	cache.Write(extensions.Bytes())
+	return cache.Bytes()
+}
+
+// compatCache writes the old header and entries followed by the new cache,
+// which holds the strings of both
+func compatCache(order binary.ByteOrder, entries []CacheEntry) []byte {
+	old := oldCache(order, entries)[:cacheHeaderOld+len(entries)*cacheEntrySzOld]
+	cache := append(old, make([]byte, align(len(old), 8)-len(old))...)
+	return append(cache, newCache(order, 0, len(cache), entries, "", nil)...)
+}
+
+var cacheEntries = []CacheEntry{
+	{Name: "libz.so.1", Path: "/lib/x86_64-linux-gnu/libz.so.1", Flags: 0x303},
+	{Name: "libc.so.6", Path: "/lib/x86_64-linux-gnu/libc.so.6", Flags: 0x303},
+	{Name: "libm.so.6", Path: "/lib/x86_64-linux-gnu/libm.so.6", Flags: 0x303},
+}
+
+func TestParseCache(t *testing.T) {
+	hwcapEntries := []CacheEntry{
+		{Name: "libz.so.1", Path: "/usr/lib64/glibc-hwcaps/x86-64-v3/libz.so.1", Flags: 0x303, HWCaps: "x86-64-v3"},
+		{Name: "libz.so.1", Path: "/usr/lib64/glibc-hwcaps/x86-64-v2/libz.so.1", Flags: 0x303, HWCaps: "x86-64-v2"},
+		{Name: "libz.so.1", Path: "/usr/lib64/tls/libz.so.1", Flags: 0x303, HWCap: 1 << 3},
+		{Name: "libz.so.1", Path: "/usr/lib64/libz.so.1", Flags: 0x303, OSVersion: 0x030200},
+	}
+	tests := []struct {
+		name      string
+		content   []byte
+		format    string
+		generator string
+		entries   []CacheEntry
+	}{
+		{name: "old", content: oldCache(binary.LittleEndian, cacheEntries), format: "old", entries: cacheEntries},
+		{name: "old big endian", content: oldCache(binary.BigEndian, cacheEntries), format: "old", entries: cacheEntries},
+		{name: "compat", content: compatCache(binary.LittleEndian, cacheEntries), format: "compat", entries: cacheEntries},
+		{name: "new", content: newCache(binary.LittleEndian, 0, 0, cacheEntries, "", nil), format: "new", entries: cacheEntries},
+		{
+			name:      "new with extensions",
+			content:   newCache(binary.LittleEndian, cacheEndianLittle, 0, hwcapEntries, "ldconfig (GNU libc) 2.35", []string{"x86-64-v3", "x86-64-v2"}),
+			format:    "new",
+			generator: "ldconfig (GNU libc) 2.35",
+			entries:   hwcapEntries,
+		},
+		{
+			name:      "new big endian",
+			content:   newCache(binary.BigEndian, cacheEndianBig, 0, hwcapEntries, "ldconfig", []string{"x86-64-v3", "x86-64-v2"}),
+			format:    "new",
+			generator: "ldconfig",
+			entries:   hwcapEntries,
+		},
+		{name: "new big endian before the flags", content: newCache(binary.BigEndian, 0, 0, cacheEntries, "", nil), format: "new", entries: cacheEntries},
+	}
+	for _, test := range tests {
+		cache, err := ParseCache(test.content)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if cache.Format != test.format || cache.Generator != test.generator {
+			t.Errorf("%s: expected format %s by %q, got %s by %q", test.name, test.format, test.generator, cache.Format, cache.Generator)
+		}
+		if !reflect.DeepEqual(cache.Entries, test.entries) {
+			t.Errorf("%s: expected entries %v, got %v", test.name, test.entries, cache.Entries)
+		}
+	}
+}
+
+func TestParseCacheErrors(t *testing.T) {
+	valid := newCache(binary.LittleEndian, cacheEndianLittle, 0, []CacheEntry{
+		{Name: "libz.so.1", Path: "/usr/lib64/glibc-hwcaps/x86-64-v3/libz.so.1", HWCaps: "x86-64-v3"},
+	}, "ldconfig", []string{"x86-64-v3"})
+	change := func(content []byte, offset int, value uint32) []byte {
+		content = bytes.Clone(content)
+		binary.LittleEndian.PutUint32(content[offset:], value)
+		return content
+	}
+	extension := int(binary.LittleEndian.Uint32(valid[32:]))
+	tests := []struct {
+		name    string
+		content []byte
+		err     string
+	}{
+		{name: "not a cache", content: []byte("#!/bin/sh\n"), err: "not an ld.so.cache"},
+		{name: "truncated old header", content: []byte(cacheMagicOld), err: "truncated"},
+		{name: "truncated new header", content: valid[:40], err: "truncated"},
+		{name: "old entries past the end", content: change(oldCache(binary.LittleEndian, cacheEntries), 12, 1000), err: "more entries"},
+		{name: "old string out of bounds", content: change(oldCache(binary.LittleEndian, cacheEntries), 16+4, 1<<20), err: "out of bounds"},
+		{name: "new entries past the end", content: change(valid, 20, 1000), err: "more entries"},
+		{name: "new string out of bounds", content: change(valid, cacheHeaderNew+8, 0xffffffff), err: "out of bounds"},
+		{name: "extensions past the end", content: change(valid, extension+4, 1<<30), err: "more extensions"},
+		{name: "extension out of bounds", content: change(valid, extension+8+12, 0xfffffff0), err: "extension is truncated"},
+		{name: "unknown glibc-hwcaps", content: change(valid, cacheHeaderNew+16, 5), err: "unknown glibc-hwcaps index 5"},
+	}
+	for _, test := range tests {
+		_, err := ParseCache(test.content)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected an error with %q, got %v", test.name, test.err, err)
+		}
+	}
+
+	// Every truncation of every format fails or parses, without a panic
+	for _, content := range [][]byte{valid, oldCache(binary.BigEndian, cacheEntries), compatCache(binary.LittleEndian, cacheEntries)} {
+		for size := range content {
+			ParseCache(content[:size])
+		}
+	}
+}
+
+func TestCacheLookup(t *testing.T) {
+	cache, err := ReadCache(fstest.MapFS{
+		"etc/ld.so.cache": {Data: newCache(binary.LittleEndian, cacheEndianLittle, 0, []CacheEntry{
+			{Name: "libz.so.1", Path: "/usr/lib64/glibc-hwcaps/x86-64-v2/libz.so.1", HWCaps: "x86-64-v2"},
+			{Name: "libz.so.1", Path: "/usr/lib64/glibc-hwcaps/x86-64-v3/libz.so.1", HWCaps: "x86-64-v3"},
+			{Name: "libz.so.1", Path: "/usr/lib64/tls/libz.so.1", HWCap: 1 << 3},
+			{Name: "libz.so.1", Path: "/usr/lib64/libz.so.1"},
+		}, "", []string{"x86-64-v2", "x86-64-v3"})},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		hwcaps []string
+		paths  []string
+	}{
+		{hwcaps: nil, paths: []string{"/usr/lib64/libz.so.1"}},
+		{hwcaps: []string{"x86-64-v2"}, paths: []string{"/usr/lib64/glibc-hwcaps/x86-64-v2/libz.so.1", "/usr/lib64/libz.so.1"}},
+		{
+			hwcaps: []string{"x86-64-v3", "x86-64-v2"},
+			paths:  []string{"/usr/lib64/glibc-hwcaps/x86-64-v3/libz.so.1", "/usr/lib64/glibc-hwcaps/x86-64-v2/libz.so.1", "/usr/lib64/libz.so.1"},
+		},
+	}
+	for _, test := range tests {
+		paths := []string{}
+		for _, entry := range cache.Lookup("libz.so.1", test.hwcaps) {
+			paths = append(paths, entry.Path)
+		}
+		if !reflect.DeepEqual(paths, test.paths) {
+			t.Errorf("%v: expected %v, got %v", test.hwcaps, test.paths, paths)
+		}
+	}
+	if entries := cache.Lookup("libc.so.6", nil); len(entries) != 0 {
+		t.Errorf("expected no entries for a missing library, got %v", entries)
+	}
+	if _, err := ReadCache(fstest.MapFS{}); err == nil {
+		t.Error("expected an error without a cache")
+	}
+}
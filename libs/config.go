@@ -0,0 +1,77 @@
+package libs
+
+import (
+	"bufio"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/vsoch/containerspec/rootfs"
+)
+
+// maxIncludes limits how deeply ld.so.conf files can include each other
+const maxIncludes = 10
+
+// ReadConfig returns the library directories listed in etc/ld.so.conf and
+// the files it includes, in order and without duplicates. Missing files are
+// not an error, many minimal images don't have one.
+func ReadConfig(fsys fs.FS) []string {
+	dirs := []string{}
+	seen := map[string]bool{}
+	readConfig(fsys, "etc/ld.so.conf", 0, func(dir string) {
+		dir = rootfs.Clean(dir)
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	})
+	return dirs
+}
+
+// readConfig parses one file: a directory per line (or separated by spaces,
+// commas or colons), "include" with glob patterns, and comments. hwcap lines
+// are from old glibc versions and ignored.
+func readConfig(fsys fs.FS, name string, depth int, add func(string)) {
+	if depth > maxIncludes {
+		return
+	}
+	file, err := rootfs.Open(fsys, name)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ' ' || r == '\t' || r == ',' || r == ':'
+		})
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "include":
+			for _, pattern := range fields[1:] {
+				// Relative patterns are relative to the including file
+				if !strings.HasPrefix(pattern, "/") {
+					pattern = path.Join(path.Dir(name), pattern)
+				}
+				matches, _ := fs.Glob(fsys, rootfs.Clean(pattern))
+				sort.Strings(matches)
+				for _, match := range matches {
+					readConfig(fsys, match, depth+1, add)
+				}
+			}
+		case "hwcap":
+		default:
+			for _, dir := range fields {
+				add(dir)
+			}
+		}
+	}
+}
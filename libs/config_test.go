@@ -0,0 +1,53 @@
+package libs
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestReadConfig(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		dirs  []string
+	}{
+		{name: "no configuration", dirs: []string{}},
+		{
+			name: "directories and comments",
+			files: map[string]string{
+				"etc/ld.so.conf": "/usr/local/lib # local\n\n/opt/a, /opt/b:/opt/c\t/opt/d\nhwcap 0 nosegneg\n/usr/local/lib/\n",
+			},
+			dirs: []string{"usr/local/lib", "opt/a", "opt/b", "opt/c", "opt/d"},
+		},
+		{
+			name: "includes in order of the glob",
+			files: map[string]string{
+				"etc/ld.so.conf":                     "include /etc/ld.so.conf.d/*.conf\n/opt/last\n",
+				"etc/ld.so.conf.d/b-mpi.conf":        "/opt/mpi/lib\n",
+				"etc/ld.so.conf.d/a-cuda.conf":       "/usr/local/cuda/lib64\n",
+				"etc/ld.so.conf.d/x86_64-linux.conf": "# Multiarch support\n/usr/local/lib/x86_64-linux-gnu\n/lib/x86_64-linux-gnu\n",
+				"etc/ld.so.conf.d/README":            "/not/a/conf\n",
+			},
+			dirs: []string{"usr/local/cuda/lib64", "opt/mpi/lib", "usr/local/lib/x86_64-linux-gnu", "lib/x86_64-linux-gnu", "opt/last"},
+		},
+		{
+			name: "relative includes and loops",
+			files: map[string]string{
+				"etc/ld.so.conf":       "include conf.d/*.conf other.conf\n",
+				"etc/conf.d/self.conf": "/opt/self\ninclude self.conf\n",
+				"etc/other.conf":       "/opt/other\ninclude missing.conf\n",
+			},
+			dirs: []string{"opt/self", "opt/other"},
+		},
+	}
+	for _, test := range tests {
+		fsys := fstest.MapFS{}
+		for name, content := range test.files {
+			fsys[name] = &fstest.MapFile{Data: []byte(content)}
+		}
+		if dirs := ReadConfig(fsys); !reflect.DeepEqual(dirs, test.dirs) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.dirs, dirs)
+		}
+	}
+}
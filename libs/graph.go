@@ -0,0 +1,103 @@
+package libs
+
+import (
+	"sort"
+)
+
+// Graph is the libraries an executable or library loads, in load order
+type Graph struct {
+	Root    string    `json:"root"`
+	Objects []*Object `json:"objects"`
+	Missing []Missing `json:"missing,omitempty"`
+}
+
+// Missing is a library that isn't found, and the objects that need it.
+// Uncached is set when it's in an ld.so.conf directory but not in the
+// cache, and ldconfig needs to be run.
+type Missing struct {
+	Name     string   `json:"name"`
+	NeededBy []string `json:"needed_by"`
+	Uncached string   `json:"uncached,omitempty"`
+}
+
+// Object returns the object in the graph at a path, if it's loaded
+func (g *Graph) Object(name string) (*Object, bool) {
+	for _, object := range g.Objects {
+		if object.Path == name {
+			return object, true
+		}
+	}
+	return nil, false
+}
+
+// Graph resolves the full dependency graph of an ELF file. Libraries are
+// loaded breadth first, like ld.so, and a needed name that matches the
+// soname of a library already loaded isn't searched for again.
+func (r *Resolver) Graph(name string) (*Graph, error) {
+	root, err := r.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	graph := Graph{Root: root.Path, Objects: []*Object{}}
+	missing := map[string][]string{}
+	uncached := map[string]string{}
+
+	// Objects are cached by the resolver, but dependencies depend on who
+	// loaded them, so each graph has its own copies
+	type node struct {
+		object  *Object
+		loaders []*Object
+	}
+	loaded := map[string]*Object{}
+	byName := map[string]string{}
+	add := func(object *Object) *Object {
+		copied := *object
+		copied.Dependencies = map[string]string{}
+		loaded[copied.Path] = &copied
+		if copied.Soname != "" {
+			byName[copied.Soname] = copied.Path
+		}
+		graph.Objects = append(graph.Objects, &copied)
+		return &copied
+	}
+
+	queue := []node{{object: add(root)}}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		loaders := append(append([]*Object{}, current.loaders...), current.object)
+
+		for _, needed := range current.object.Needed {
+			if found, ok := byName[needed]; ok {
+				current.object.Dependencies[needed] = found
+				continue
+			}
+			found, ok := r.Find(needed, current.object, current.loaders)
+			if !ok {
+				missing[needed] = append(missing[needed], current.object.Path)
+				if found, ok := r.FindUncached(needed, current.object); ok {
+					uncached[needed] = found
+				}
+				continue
+			}
+			current.object.Dependencies[needed] = found
+			byName[needed] = found
+			if _, ok := loaded[found]; ok {
+				continue
+			}
+			library, err := r.Open(found)
+			if err != nil {
+				return nil, err
+			}
+			queue = append(queue, node{object: add(library), loaders: loaders})
+		}
+	}
+
+	for name, neededBy := range missing {
+		graph.Missing = append(graph.Missing, Missing{Name: name, NeededBy: neededBy, Uncached: uncached[name]})
+	}
+	sort.Slice(graph.Missing, func(i, j int) bool {
+		return graph.Missing[i].Name < graph.Missing[j].Name
+	})
+	return &graph, nil
+}
@@ -0,0 +1,233 @@
+package libs
+
+import (
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/vsoch/containerspec/rootfs"
+	"github.com/vsoch/containerspec/utils"
+)
+
+// Host libraries (e.g., libmpi, libcuda, libfabric) are bind mounted into
+// containers so they use the host's drivers and network. They only work if
+// everything they load, and everything that loads them, agrees.
+
+var glibcRegex = regexp.MustCompile(`^GLIBC_([0-9]+(\.[0-9]+)*)$`)
+
+// Bind is a host library to bind mount into the container
+type Bind struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// ParseBind parses source[:target] as in singularity --bind, where the
+// target defaults to the same path as the source
+func ParseBind(value string) Bind {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) == 1 || parts[1] == "" {
+		return Bind{Source: parts[0], Target: parts[0]}
+	}
+	return Bind{Source: parts[0], Target: parts[1]}
+}
+
+// Conflict is a symbol version an object needs that the library it loads
+// doesn't define
+type Conflict struct {
+	Object  string `json:"object"`
+	Library string `json:"library"`
+	Version string `json:"version"`
+}
+
+// GlibcCheck compares the glibc the host libraries need to the container's
+type GlibcCheck struct {
+	Required  string `json:"required"`
+	Container string `json:"container"`
+	OK        bool   `json:"ok"`
+}
+
+// Impact is what happens to library resolution when host libraries are bound
+type Impact struct {
+	Binds []Bind `json:"binds"`
+
+	// Affected are container files that load a bound library
+	Affected []string `json:"affected,omitempty"`
+
+	// Missing are libraries bound libraries need that the container lacks
+	Missing   []Missing   `json:"missing,omitempty"`
+	Conflicts []Conflict  `json:"conflicts,omitempty"`
+	Glibc     *GlibcCheck `json:"glibc,omitempty"`
+}
+
+// OK is true if the bound libraries should work in the container
+func (i *Impact) OK() bool {
+	return len(i.Missing) == 0 && len(i.Conflicts) == 0 && (i.Glibc == nil || i.Glibc.OK)
+}
+
+// BindImpact simulates binding host libraries into a root file system. We
+// resolve the libraries the bound ones load and the container files that
+// load them, and check symbol versions on every edge that crosses between
+// host and container. libraryPath is searched like LD_LIBRARY_PATH, e.g.,
+// /.singularity.d/libs where Singularity binds libraries with --nv.
+func BindImpact(fsys fs.FS, binds []Bind, libraryPath []string) (*Impact, error) {
+	targets := map[string]string{}
+	for _, bind := range binds {
+		targets[bind.Target] = bind.Source
+	}
+	bound, err := rootfs.Bind(fsys, targets)
+	if err != nil {
+		return nil, err
+	}
+	resolver := NewResolver(bound)
+	resolver.LibraryPath = libraryPath
+
+	// Bound libraries are known by their file and soname
+	roots := []string{}
+	names := map[string]bool{}
+	boundObjects := []*Object{}
+	for _, bind := range binds {
+		object, err := resolver.Open(bind.Target)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, object.Path)
+		names[path.Base(bind.Target)] = true
+		if object.Soname != "" {
+			names[object.Soname] = true
+		}
+		boundObjects = append(boundObjects, object)
+	}
+
+	impact := Impact{Binds: binds}
+	err = rootfs.WalkFiles(fsys, func(name string, d fs.DirEntry) error {
+		object, err := resolver.Open(name)
+		if err != nil || bound.Bound(name) {
+			return nil
+		}
+		for _, needed := range object.Needed {
+			if names[needed] {
+				impact.Affected = append(impact.Affected, name)
+				roots = append(roots, name)
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The same file can be reached through symlinked directories (/lib)
+	real := func(name string) string {
+		if resolved, err := rootfs.Resolve(bound, name); err == nil {
+			return resolved
+		}
+		return name
+	}
+	missing := map[string]map[string]bool{}
+	uncached := map[string]string{}
+	conflicts := map[Conflict]bool{}
+	for _, root := range roots {
+		graph, err := resolver.Graph(root)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range graph.Missing {
+			for _, by := range m.NeededBy {
+				if bound.Bound(by) {
+					if missing[m.Name] == nil {
+						missing[m.Name] = map[string]bool{}
+					}
+					missing[m.Name][real(by)] = true
+					if m.Uncached != "" {
+						uncached[m.Name] = m.Uncached
+					}
+				}
+			}
+		}
+		for _, object := range graph.Objects {
+			for needed, found := range object.Dependencies {
+				if !bound.Bound(object.Path) && !bound.Bound(found) {
+					continue
+				}
+				// Like the loader, versions aren't checked against a library
+				// that doesn't define any
+				library, _ := graph.Object(found)
+				if library == nil || len(library.defines) == 0 {
+					continue
+				}
+				for _, version := range object.needs[needed] {
+					if !library.defines[version] {
+						conflicts[Conflict{Object: real(object.Path), Library: real(found), Version: version}] = true
+					}
+				}
+			}
+		}
+	}
+
+	for name, by := range missing {
+		impact.Missing = append(impact.Missing, Missing{Name: name, NeededBy: sortedKeys(by), Uncached: uncached[name]})
+	}
+	sort.Slice(impact.Missing, func(i, j int) bool { return impact.Missing[i].Name < impact.Missing[j].Name })
+	for conflict := range conflicts {
+		impact.Conflicts = append(impact.Conflicts, conflict)
+	}
+	sort.Slice(impact.Conflicts, func(i, j int) bool {
+		a, b := impact.Conflicts[i], impact.Conflicts[j]
+		if a.Object != b.Object {
+			return a.Object < b.Object
+		}
+		if a.Library != b.Library {
+			return a.Library < b.Library
+		}
+		return a.Version < b.Version
+	})
+	impact.Glibc = glibcCheck(resolver, boundObjects)
+	return &impact, nil
+}
+
+// glibcCheck compares the highest GLIBC version the bound libraries need to
+// the highest the container's libc defines
+func glibcCheck(resolver *Resolver, objects []*Object) *GlibcCheck {
+	check := GlibcCheck{}
+	var libc *Object
+	for _, object := range objects {
+		for _, versions := range object.needs {
+			for _, version := range versions {
+				if match := glibcRegex.FindStringSubmatch(version); match != nil {
+					if utils.CompareVersions(match[1], check.Required) > 0 {
+						check.Required = match[1]
+					}
+				}
+			}
+		}
+		if libc == nil {
+			if found, ok := resolver.Find("libc.so.6", object, nil); ok {
+				libc, _ = resolver.Open(found)
+			}
+		}
+	}
+	if check.Required == "" || libc == nil {
+		return nil
+	}
+	for version := range libc.defines {
+		if match := glibcRegex.FindStringSubmatch(version); match != nil {
+			if utils.CompareVersions(match[1], check.Container) > 0 {
+				check.Container = match[1]
+			}
+		}
+	}
+	check.OK = utils.CompareVersions(check.Required, check.Container) <= 0
+	return &check
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := []string{}
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
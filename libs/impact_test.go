@@ -0,0 +1,119 @@
+package libs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/vsoch/containerspec/internal/elftest"
+)
+
+// hostLibrary writes a host library to bind to a temporary directory
+func hostLibrary(t *testing.T, name string, file elftest.File) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, file.Bytes(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// impactFS is a container with MPI and glibc 2.28
+func impactFS() fstest.MapFS {
+	return fstest.MapFS{
+		"lib64":               symlink("usr/lib64"),
+		"usr/lib64/libc.so.6": library(elftest.File{Soname: "libc.so.6", Defines: []string{"GLIBC_2.2.5", "GLIBC_2.17", "GLIBC_2.28"}}),
+		"usr/lib64/libm.so.6": library(elftest.File{Soname: "libm.so.6"}),
+		"usr/lib64/libfabric.so.1": library(elftest.File{
+			Soname: "libfabric.so.1", Needed: []string{"libc.so.6"}, Defines: []string{"FABRIC_1.0", "FABRIC_1.3"},
+		}),
+		"usr/lib64/libmpi.so.40": library(elftest.File{Soname: "libmpi.so.40", Defines: []string{"OMPI_4.0"}}),
+		"usr/bin/app": library(elftest.File{
+			Needed: []string{"libmpi.so.40", "libc.so.6"},
+			Needs:  []elftest.Need{{Library: "libmpi.so.40", Versions: []string{"OMPI_4.0"}}, {Library: "libc.so.6", Versions: []string{"GLIBC_2.17"}}},
+		}),
+		"usr/bin/tool": library(elftest.File{Needed: []string{"libc.so.6"}}),
+
+		// ldconfig wasn't run after PMIx was installed
+		"etc/ld.so.conf":            &fstest.MapFile{Data: []byte("/opt/pmix/lib\n")},
+		"opt/pmix/lib/libpmix.so.2": library(elftest.File{Soname: "libpmix.so.2"}),
+	}
+}
+
+func TestBindImpact(t *testing.T) {
+	mpi := hostLibrary(t, "libmpi.so.40.30.0", elftest.File{
+		Soname:  "libmpi.so.40",
+		Needed:  []string{"libfabric.so.1", "libpmix.so.2", "libc.so.6"},
+		Needs:   []elftest.Need{{Library: "libfabric.so.1", Versions: []string{"FABRIC_1.0", "FABRIC_1.5"}}, {Library: "libc.so.6", Versions: []string{"GLIBC_2.17", "GLIBC_2.34"}}},
+		Defines: []string{"OMPI_5.0"},
+	})
+	impact, err := BindImpact(impactFS(), []Bind{ParseBind(mpi + ":/lib64/libmpi.so.40")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(impact.Affected, []string{"usr/bin/app"}) {
+		t.Errorf("expected the app to load the bound library, got %v", impact.Affected)
+	}
+	missing := []Missing{{Name: "libpmix.so.2", NeededBy: []string{"usr/lib64/libmpi.so.40"}, Uncached: "opt/pmix/lib/libpmix.so.2"}}
+	if !reflect.DeepEqual(impact.Missing, missing) {
+		t.Errorf("expected missing %v, got %v", missing, impact.Missing)
+	}
+	conflicts := []Conflict{
+		{Object: "usr/bin/app", Library: "usr/lib64/libmpi.so.40", Version: "OMPI_4.0"},
+		{Object: "usr/lib64/libmpi.so.40", Library: "usr/lib64/libc.so.6", Version: "GLIBC_2.34"},
+		{Object: "usr/lib64/libmpi.so.40", Library: "usr/lib64/libfabric.so.1", Version: "FABRIC_1.5"},
+	}
+	if !reflect.DeepEqual(impact.Conflicts, conflicts) {
+		t.Errorf("expected conflicts %v, got %v", conflicts, impact.Conflicts)
+	}
+	if glibc := (GlibcCheck{Required: "2.34", Container: "2.28"}); impact.Glibc == nil || *impact.Glibc != glibc {
+		t.Errorf("expected glibc check %v, got %v", glibc, impact.Glibc)
+	}
+	if impact.OK() {
+		t.Error("expected the impact not to be ok")
+	}
+}
+
+func TestBindImpactOK(t *testing.T) {
+	// libm defines no versions, so the versions needed from it aren't checked
+	cuda := hostLibrary(t, "libcuda.so.1", elftest.File{
+		Soname: "libcuda.so.1",
+		Needed: []string{"libm.so.6", "libc.so.6"},
+		Needs:  []elftest.Need{{Library: "libm.so.6", Versions: []string{"GLIBC_2.27"}}, {Library: "libc.so.6", Versions: []string{"GLIBC_2.2.5", "GLIBC_2.17"}}},
+	})
+	impact, err := BindImpact(impactFS(), []Bind{ParseBind(cuda + ":/usr/lib64/libcuda.so.1")}, []string{"/.singularity.d/libs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(impact.Affected) != 0 || len(impact.Missing) != 0 || len(impact.Conflicts) != 0 {
+		t.Errorf("expected no affected files or problems, got %+v", impact)
+	}
+	if impact.Glibc == nil || !impact.Glibc.OK || impact.Glibc.Required != "2.27" {
+		t.Errorf("expected glibc 2.27 to be ok, got %v", impact.Glibc)
+	}
+	if !impact.OK() {
+		t.Error("expected the impact to be ok")
+	}
+
+	if _, err := BindImpact(impactFS(), []Bind{ParseBind("/does/not/exist.so")}, nil); err == nil {
+		t.Error("expected an error binding a missing host file")
+	}
+}
+
+func TestParseBind(t *testing.T) {
+	tests := []struct {
+		value string
+		bind  Bind
+	}{
+		{value: "/usr/lib64/libcuda.so.1", bind: Bind{Source: "/usr/lib64/libcuda.so.1", Target: "/usr/lib64/libcuda.so.1"}},
+		{value: "/host/libmpi.so:/usr/lib/libmpi.so", bind: Bind{Source: "/host/libmpi.so", Target: "/usr/lib/libmpi.so"}},
+		{value: "/host/libmpi.so:", bind: Bind{Source: "/host/libmpi.so", Target: "/host/libmpi.so"}},
+	}
+	for _, test := range tests {
+		if bind := ParseBind(test.value); bind != test.bind {
+			t.Errorf("%s: expected %v, got %v", test.value, test.bind, bind)
+		}
+	}
+}
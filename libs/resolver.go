@@ -0,0 +1,261 @@
+package libs
+
+import (
+	"debug/elf"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/vsoch/containerspec/binaries"
+	"github.com/vsoch/containerspec/rootfs"
+	"github.com/vsoch/containerspec/spec"
+)
+
+// To answer "can I bind mount library X and it will still work?" we find
+// shared libraries the way the dynamic loader (ld.so) does, inside a root
+// file system and without running anything.
+
+// multiarchTriplets are the Debian multiarch directories of each family
+var multiarchTriplets = map[string]string{
+	"x86_64":  "x86_64-linux-gnu",
+	"x86":     "i386-linux-gnu",
+	"aarch64": "aarch64-linux-gnu",
+	"arm":     "arm-linux-gnueabihf",
+	"ppc64le": "powerpc64le-linux-gnu",
+	"ppc64":   "powerpc64-linux-gnu",
+}
+
+// Object is an ELF executable or shared library in a root file system
+type Object struct {
+	Path    string   `json:"path"`
+	Soname  string   `json:"soname,omitempty"`
+	Needed  []string `json:"needed,omitempty"`
+	RPath   []string `json:"rpath,omitempty"`
+	RunPath []string `json:"runpath,omitempty"`
+
+	// Dependencies maps each needed name to the path it resolved to
+	Dependencies map[string]string `json:"dependencies,omitempty"`
+
+	// Symbol versions needed from each library, and defined by this one
+	needs   map[string][]string
+	defines map[string]bool
+
+	origin  string
+	family  string
+	class   elf.Class
+	machine elf.Machine
+}
+
+// Family returns the architecture family of the object in the CPU database
+func (o *Object) Family() string {
+	return o.family
+}
+
+// Resolver finds shared libraries in a root file system
+type Resolver struct {
+	fsys fs.FS
+
+	// LibraryPath is searched like LD_LIBRARY_PATH, after DT_RPATH
+	LibraryPath []string
+
+	// Cache is etc/ld.so.cache, which ldconfig writes from the ld.so.conf
+	// directories (Dirs). The loader never reads ld.so.conf, so Dirs are
+	// only used to tell why a library isn't found.
+	Cache *Cache
+	Dirs  []string
+
+	// HWCaps are the glibc-hwcaps subdirectories (e.g., x86-64-v3) the CPU
+	// supports, in order of preference. Without them we resolve baseline
+	// libraries from the cache.
+	HWCaps []string
+
+	objects map[string]*Object
+}
+
+// NewResolver reads the loader configuration of a root file system
+func NewResolver(fsys fs.FS) *Resolver {
+	resolver := Resolver{
+		fsys:    fsys,
+		Dirs:    ReadConfig(fsys),
+		objects: map[string]*Object{},
+	}
+	// Many images don't have a cache, which is fine
+	resolver.Cache, _ = ReadCache(fsys)
+	return &resolver
+}
+
+// Open reads the dynamic section of an ELF file in the root, caching it
+func (r *Resolver) Open(name string) (*Object, error) {
+	name = rootfs.Clean(name)
+	if object, ok := r.objects[name]; ok {
+		return object, nil
+	}
+	file, err := binaries.OpenELF(r.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// $ORIGIN is the directory the object really is in
+	resolved, err := rootfs.Resolve(r.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	object := Object{
+		Path:    name,
+		origin:  "/" + path.Dir(resolved),
+		family:  spec.MachineFamily(file.Machine, file.ByteOrder),
+		class:   file.Class,
+		machine: file.Machine,
+	}
+	if sonames, err := file.DynString(elf.DT_SONAME); err == nil && len(sonames) > 0 {
+		object.Soname = sonames[0]
+	}
+	object.Needed, _ = file.DynString(elf.DT_NEEDED)
+	object.RPath = searchPath(file.File, elf.DT_RPATH)
+	object.RunPath = searchPath(file.File, elf.DT_RUNPATH)
+	object.needs, object.defines = symbolVersions(file.File)
+	r.objects[name] = &object
+	return &object, nil
+}
+
+// symbolVersions reads the versions needed (.gnu.version_r) by library and
+// the versions defined (.gnu.version_d), not counting the base version
+func symbolVersions(file *elf.File) (map[string][]string, map[string]bool) {
+	needs := map[string][]string{}
+	defines := map[string]bool{}
+	if needed, err := file.DynamicVersionNeeds(); err == nil {
+		for _, need := range needed {
+			for _, dep := range need.Needs {
+				needs[need.Name] = append(needs[need.Name], dep.Dep)
+			}
+		}
+	}
+	if versions, err := file.DynamicVersions(); err == nil {
+		for _, version := range versions {
+			if version.Flags&elf.VER_FLG_BASE == 0 {
+				defines[version.Name] = true
+			}
+		}
+	}
+	return needs, defines
+}
+
+// searchPath splits DT_RPATH or DT_RUNPATH into directories
+func searchPath(file *elf.File, tag elf.DynTag) []string {
+	values, err := file.DynString(tag)
+	if err != nil {
+		return nil
+	}
+	dirs := []string{}
+	for _, value := range values {
+		for _, dir := range strings.Split(value, ":") {
+			if dir != "" {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	return dirs
+}
+
+// Find returns the path of a library needed by an object, searching as
+// ld.so does: DT_RPATH of the object and the objects that loaded it (unless
+// the object has DT_RUNPATH), LibraryPath, DT_RUNPATH, ld.so.cache, then the
+// default directories. loaders is the chain of objects that loaded the
+// object, the executable first. Only libraries of the same class and machine
+// as the object are considered.
+func (r *Resolver) Find(name string, object *Object, loaders []*Object) (string, bool) {
+	if strings.Contains(name, "/") {
+		return r.candidate(name, object)
+	}
+
+	dirs := []string{}
+	if len(object.RunPath) == 0 {
+		dirs = append(dirs, expand(object.RPath, object)...)
+		for i := len(loaders) - 1; i >= 0; i-- {
+			dirs = append(dirs, expand(loaders[i].RPath, loaders[i])...)
+		}
+	}
+	dirs = append(dirs, r.LibraryPath...)
+	dirs = append(dirs, expand(object.RunPath, object)...)
+	if found, ok := r.search(name, object, dirs); ok {
+		return found, true
+	}
+
+	// Stale entries (the file is gone) are skipped, as the loader does
+	if r.Cache != nil {
+		for _, entry := range r.Cache.Lookup(name, r.HWCaps) {
+			if found, ok := r.candidate(entry.Path, object); ok {
+				return found, true
+			}
+		}
+	}
+	return r.search(name, object, defaultDirs(object))
+}
+
+// FindUncached returns the path of a library Find doesn't find that is in
+// an ld.so.conf directory, so running ldconfig would make it found
+func (r *Resolver) FindUncached(name string, object *Object) (string, bool) {
+	if strings.Contains(name, "/") {
+		return "", false
+	}
+	return r.search(name, object, r.Dirs)
+}
+
+// search looks for a library in directories, in order
+func (r *Resolver) search(name string, object *Object, dirs []string) (string, bool) {
+	for _, dir := range dirs {
+		if found, ok := r.candidate(path.Join(dir, name), object); ok {
+			return found, true
+		}
+	}
+	return "", false
+}
+
+// candidate checks that a library exists and can be loaded by the object
+func (r *Resolver) candidate(name string, object *Object) (string, bool) {
+	name = rootfs.Clean(name)
+	if !rootfs.Exists(r.fsys, name) {
+		return "", false
+	}
+	library, err := r.Open(name)
+	if err != nil || library.class != object.class || library.machine != object.machine {
+		return "", false
+	}
+	return name, true
+}
+
+// expand substitutes $ORIGIN (the directory of the object), $LIB and
+// $PLATFORM in search path directories
+func expand(dirs []string, object *Object) []string {
+	lib := "lib"
+	if object.class == elf.ELFCLASS64 {
+		lib = "lib64"
+	}
+	replacer := strings.NewReplacer(
+		"${ORIGIN}", object.origin,
+		"$ORIGIN", object.origin,
+		"${LIB}", lib,
+		"$LIB", lib,
+		"${PLATFORM}", object.Family(),
+		"$PLATFORM", object.Family(),
+	)
+	expanded := []string{}
+	for _, dir := range dirs {
+		expanded = append(expanded, replacer.Replace(dir))
+	}
+	return expanded
+}
+
+// defaultDirs are the trusted directories the loader always searches last,
+// with multiarch (Debian) and lib64 (Red Hat) variants
+func defaultDirs(object *Object) []string {
+	dirs := []string{}
+	if triplet, ok := multiarchTriplets[object.Family()]; ok {
+		dirs = append(dirs, "lib/"+triplet, "usr/lib/"+triplet)
+	}
+	if object.class == elf.ELFCLASS64 {
+		dirs = append(dirs, "lib64", "usr/lib64")
+	}
+	return append(dirs, "lib", "usr/lib")
+}
@@ -0,0 +1,158 @@
+package libs
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"io/fs"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/vsoch/containerspec/internal/elftest"
+)
+
+// library is an ELF file for a root file system, x86_64 unless it says
+// otherwise
+func library(file elftest.File) *fstest.MapFile {
+	return &fstest.MapFile{Data: file.Bytes(), Mode: 0755}
+}
+
+// symlink is a symbolic link for a root file system
+func symlink(target string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(target), Mode: fs.ModeSymlink | 0777}
+}
+
+// resolverFS is a root file system with libraries found every way the
+// loader finds them
+func resolverFS() fstest.MapFS {
+	return fstest.MapFS{
+		"usr/bin/app": library(elftest.File{
+			Needed: []string{"librpath.so", "libloader.so", "libcached.so.1", "libc.so.6", "libconf.so.1", "libz.so.1"},
+			RPath:  "$ORIGIN/../lib/app",
+		}),
+		"bin": symlink("usr/bin"),
+
+		// libloader.so has no RUNPATH, so the RPATH of the app applies to
+		// what it needs, and librunpath.so has one, so it doesn't
+		"usr/lib/app/librpath.so":     library(elftest.File{Soname: "librpath.so"}),
+		"usr/lib/app/libloader.so":    library(elftest.File{Soname: "libloader.so", Needed: []string{"libapp.so", "librunpath.so"}}),
+		"usr/lib/app/libapp.so":       library(elftest.File{Soname: "libapp.so"}),
+		"usr/lib/app/librunpath.so":   library(elftest.File{Soname: "librunpath.so", Needed: []string{"libapp.so.2"}, RunPath: "/opt/run:$ORIGIN/run"}),
+		"usr/lib/app/libapp.so.2":     library(elftest.File{Soname: "libapp.so.2"}),
+		"usr/lib/app/run/libapp.so.2": library(elftest.File{Soname: "libapp.so.2"}),
+
+		// The cache has a stale entry first
+		"etc/ld.so.cache": &fstest.MapFile{Data: newCache(binary.LittleEndian, cacheEndianLittle, 0, []CacheEntry{
+			{Name: "libcached.so.1", Path: "/opt/gone/libcached.so.1"},
+			{Name: "libcached.so.1", Path: "/opt/cached/libcached.so.1"},
+		}, "", nil)},
+		"opt/cached/libcached.so.1": library(elftest.File{Soname: "libcached.so.1"}),
+
+		// Default directories, where a library for another machine is skipped
+		"lib/x86_64-linux-gnu/libc.so.6": library(elftest.File{Soname: "libc.so.6"}),
+		"usr/lib64/libz.so.1":            library(elftest.File{Soname: "libz.so.1", Machine: elf.EM_AARCH64}),
+		"usr/lib/libz.so.1":              library(elftest.File{Soname: "libz.so.1"}),
+
+		// ld.so.conf directories aren't searched, ldconfig needs to be run
+		"etc/ld.so.conf":          &fstest.MapFile{Data: []byte("include /etc/ld.so.conf.d/*.conf\n")},
+		"etc/ld.so.conf.d/a.conf": &fstest.MapFile{Data: []byte("/opt/conf\n/opt/cached\n")},
+		"opt/conf/libconf.so.1":   library(elftest.File{Soname: "libconf.so.1"}),
+	}
+}
+
+func TestResolverOpen(t *testing.T) {
+	resolver := NewResolver(resolverFS())
+	object, err := resolver.Open("/bin/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if object.Path != "bin/app" || object.origin != "/usr/bin" || object.Family() != "x86_64" {
+		t.Errorf("expected bin/app in /usr/bin for x86_64, got %s in %s for %s", object.Path, object.origin, object.Family())
+	}
+	if !reflect.DeepEqual(object.RPath, []string{"$ORIGIN/../lib/app"}) || len(object.Needed) != 6 {
+		t.Errorf("expected the rpath and needed libraries, got %v and %v", object.RPath, object.Needed)
+	}
+	library, err := resolver.Open("usr/lib/app/librunpath.so")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if library.Soname != "librunpath.so" || !reflect.DeepEqual(library.RunPath, []string{"/opt/run", "$ORIGIN/run"}) {
+		t.Errorf("expected the soname and runpath, got %s and %v", library.Soname, library.RunPath)
+	}
+	if !reflect.DeepEqual(resolver.Dirs, []string{"opt/conf", "opt/cached"}) || resolver.Cache == nil {
+		t.Errorf("expected the ld.so.conf directories and the cache, got %v and %v", resolver.Dirs, resolver.Cache)
+	}
+	if _, err := resolver.Open("etc/ld.so.conf"); err == nil {
+		t.Error("expected an error opening a file that isn't ELF")
+	}
+}
+
+func TestResolverFind(t *testing.T) {
+	resolver := NewResolver(resolverFS())
+	open := func(name string) *Object {
+		object, err := resolver.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return object
+	}
+	app, loader, runpath := open("bin/app"), open("usr/lib/app/libloader.so"), open("usr/lib/app/librunpath.so")
+
+	tests := []struct {
+		name    string
+		needed  string
+		object  *Object
+		loaders []*Object
+		found   string
+	}{
+		{name: "rpath with $ORIGIN", needed: "librpath.so", object: app, found: "usr/lib/app/librpath.so"},
+		{name: "rpath of the loader", needed: "libapp.so", object: loader, loaders: []*Object{app}, found: "usr/lib/app/libapp.so"},
+		{name: "no rpath without the loader", needed: "libapp.so", object: loader},
+		{name: "runpath ignores the rpath of the loader", needed: "libapp.so.2", object: runpath, loaders: []*Object{app, loader}, found: "usr/lib/app/run/libapp.so.2"},
+		{name: "cache skips stale entries", needed: "libcached.so.1", object: app, found: "opt/cached/libcached.so.1"},
+		{name: "multiarch default directory", needed: "libc.so.6", object: app, found: "lib/x86_64-linux-gnu/libc.so.6"},
+		{name: "library for another machine", needed: "libz.so.1", object: app, found: "usr/lib/libz.so.1"},
+		{name: "ld.so.conf directory isn't searched", needed: "libconf.so.1", object: app},
+		{name: "path", needed: "/opt/conf/libconf.so.1", object: app, found: "opt/conf/libconf.so.1"},
+	}
+	for _, test := range tests {
+		found, ok := resolver.Find(test.needed, test.object, test.loaders)
+		if found != test.found || ok != (test.found != "") {
+			t.Errorf("%s: expected %q, got %q (%v)", test.name, test.found, found, ok)
+		}
+	}
+
+	resolver.LibraryPath = []string{"opt/conf"}
+	if found, ok := resolver.Find("libconf.so.1", app, nil); !ok || found != "opt/conf/libconf.so.1" {
+		t.Errorf("expected the library path to be searched, got %q", found)
+	}
+	if found, ok := resolver.FindUncached("libconf.so.1", app); !ok || found != "opt/conf/libconf.so.1" {
+		t.Errorf("expected the library in an ld.so.conf directory, got %q", found)
+	}
+}
+
+func TestResolverGraph(t *testing.T) {
+	graph, err := NewResolver(resolverFS()).Graph("bin/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := []string{}
+	for _, object := range graph.Objects {
+		paths = append(paths, object.Path)
+	}
+	want := []string{
+		"bin/app", "usr/lib/app/librpath.so", "usr/lib/app/libloader.so", "opt/cached/libcached.so.1",
+		"lib/x86_64-linux-gnu/libc.so.6", "usr/lib/libz.so.1", "usr/lib/app/libapp.so", "usr/lib/app/librunpath.so",
+		"usr/lib/app/run/libapp.so.2",
+	}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("expected objects in load order %v, got %v", want, paths)
+	}
+	missing := []Missing{{Name: "libconf.so.1", NeededBy: []string{"bin/app"}, Uncached: "opt/conf/libconf.so.1"}}
+	if !reflect.DeepEqual(graph.Missing, missing) {
+		t.Errorf("expected missing %v, got %v", missing, graph.Missing)
+	}
+	if loader, ok := graph.Object("usr/lib/app/libloader.so"); !ok || loader.Dependencies["libapp.so"] != "usr/lib/app/libapp.so" {
+		t.Errorf("expected the dependencies of the loader, got %v", loader)
+	}
+}
@@ -0,0 +1,180 @@
+package lint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vsoch/containerspec/dockerfile"
+	"github.com/vsoch/containerspec/labels"
+)
+
+// Lint a Dockerfile for the supercontainers label policy
+
+const labelPrefix = "org.supercontainers."
+
+// Severity of a finding, named as in SARIF
+type Severity string
+
+const (
+	Error   Severity = "error"
+	Warning Severity = "warning"
+)
+
+// Rule describes one check
+type Rule struct {
+	ID          string
+	Description string
+	Severity    Severity
+}
+
+// Finding is one problem in a Dockerfile
+type Finding struct {
+	Rule     string
+	Severity Severity
+	Message  string
+	Path     string
+	Line     int
+}
+
+// Rules that Lint checks, in the order they are reported
+var Rules = []Rule{
+	{
+		ID:          "missing-label",
+		Description: "A required supercontainers label is not set for the final image",
+		Severity:    Error,
+	},
+	{
+		ID:          "invalid-label",
+		Description: "A supercontainers label is unknown or has a value that is not allowed",
+		Severity:    Error,
+	},
+	{
+		ID:          "unpinned-from",
+		Description: "A base image is not pinned to a digest",
+		Severity:    Warning,
+	},
+	{
+		ID:          "label-not-final-stage",
+		Description: "A supercontainers label is set in a build stage that does not end up in the final image",
+		Severity:    Warning,
+	},
+}
+
+// Options for linting
+type Options struct {
+
+	// Required label keys, defaults to labels.RequiredKeys()
+	Required []string
+}
+
+// Lint checks a parsed Dockerfile and returns findings sorted by line
+func Lint(d *dockerfile.Dockerfile, options Options) []Finding {
+	l := linter{dockerfile: d}
+	final := d.Final()
+	if final == nil {
+		return l.findings
+	}
+
+	required := options.Required
+	if required == nil {
+		required = labels.RequiredKeys()
+	}
+
+	// Labels set in the final stage or a stage it is built from
+	inherited := map[int]bool{}
+	present := map[string]bool{}
+	for _, stage := range d.Inherited(final) {
+		inherited[stage.Index] = true
+		for _, label := range stage.Labels {
+			present[label.Key] = true
+		}
+	}
+	for _, key := range required {
+		if !present[key] {
+			l.add("missing-label", final.Line, "required label %s is not set", key)
+		}
+	}
+
+	for _, stage := range d.Stages {
+		l.checkFrom(stage)
+		for _, label := range stage.Labels {
+			if !strings.HasPrefix(label.Key, labelPrefix) {
+				continue
+			}
+			// A value from a build argument without a default is only
+			// known at build time, so it can't be checked
+			if value, ok := stage.Expand(label.Value); ok {
+				if err := labels.Validate(label.Key, value); err != nil {
+					l.add("invalid-label", label.Line, "%s", err)
+				}
+			}
+			if !inherited[stage.Index] {
+				l.add("label-not-final-stage", label.Line, "label %s is set in stage %s, which is not part of the final image", label.Key, stageName(stage))
+			}
+		}
+	}
+
+	sort.SliceStable(l.findings, func(i, j int) bool {
+		return l.findings[i].Line < l.findings[j].Line
+	})
+	return l.findings
+}
+
+// Failed determines if any finding is an error
+func Failed(findings []Finding) bool {
+	for _, finding := range findings {
+		if finding.Severity == Error {
+			return true
+		}
+	}
+	return false
+}
+
+// linter collects findings for one Dockerfile
+type linter struct {
+	dockerfile *dockerfile.Dockerfile
+	findings   []Finding
+}
+
+func (l *linter) add(rule string, line int, format string, args ...interface{}) {
+	l.findings = append(l.findings, Finding{
+		Rule:     rule,
+		Severity: lookupRule(rule).Severity,
+		Message:  fmt.Sprintf(format, args...),
+		Path:     l.dockerfile.Path,
+		Line:     line,
+	})
+}
+
+// checkFrom reports a base image that isn't pinned to a digest
+func (l *linter) checkFrom(stage *dockerfile.Stage) {
+	// scratch and previous stages have nothing to pin, and we can't
+	// reason about an image given by a build argument without a default
+	image, ok := l.dockerfile.Expand(stage.Image)
+	if !ok || image == "" || strings.EqualFold(image, "scratch") {
+		return
+	}
+	if l.dockerfile.Stage(image, stage.Index) != nil {
+		return
+	}
+	if !strings.Contains(image, "@") {
+		l.add("unpinned-from", stage.Line, "base image %s is not pinned to a digest", image)
+	}
+}
+
+func lookupRule(id string) Rule {
+	for _, rule := range Rules {
+		if rule.ID == id {
+			return rule
+		}
+	}
+	return Rule{ID: id, Severity: Error}
+}
+
+func stageName(stage *dockerfile.Stage) string {
+	if stage.Name != "" {
+		return stage.Name
+	}
+	return fmt.Sprint(stage.Index)
+}
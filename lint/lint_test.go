@@ -0,0 +1,113 @@
+package lint
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vsoch/containerspec/dockerfile"
+)
+
+// finding is the rule and line of a finding, what tests compare
+type finding struct {
+	rule string
+	line int
+}
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		findings []finding
+	}{
+		{
+			name: "all labels",
+			content: `FROM ubuntu@sha256:0000000000000000000000000000000000000000000000000000000000000000
+LABEL org.supercontainers.mpi=openmpi org.supercontainers.gpu=cuda \
+      org.supercontainers.glibc=2.35 org.supercontainers.target=zen2`,
+		},
+		{
+			name:     "missing labels and unpinned base",
+			content:  "FROM ubuntu:22.04\nLABEL org.supercontainers.mpi=openmpi",
+			findings: []finding{{"missing-label", 1}, {"missing-label", 1}, {"missing-label", 1}, {"unpinned-from", 1}},
+		},
+		{
+			name: "invalid values",
+			content: `FROM scratch
+LABEL org.supercontainers.mpi=lam org.supercontainers.gpu=cuda
+LABEL org.supercontainers.glibc=two org.supercontainers.target=zen2 org.supercontainers.color=blue`,
+			findings: []finding{{"invalid-label", 2}, {"invalid-label", 3}, {"invalid-label", 3}},
+		},
+		{
+			name: "values from arguments with defaults",
+			content: `ARG BASE=ubuntu
+FROM $BASE
+ARG MPI=lam
+ARG GLIBC=2.35
+LABEL org.supercontainers.mpi=${MPI} org.supercontainers.gpu=cuda
+LABEL org.supercontainers.glibc=$GLIBC org.supercontainers.target=${TARGET:-zen2}`,
+			findings: []finding{{"unpinned-from", 2}, {"invalid-label", 5}},
+		},
+		{
+			name: "values from arguments without defaults are not checked",
+			content: `ARG BASE
+FROM $BASE
+ARG TARGET
+ARG GLIBC
+LABEL org.supercontainers.mpi=openmpi org.supercontainers.gpu=cuda
+LABEL org.supercontainers.glibc=$GLIBC org.supercontainers.target=${TARGET}`,
+		},
+		{
+			name: "labels in a stage that isn't final",
+			content: `FROM scratch AS build
+LABEL org.supercontainers.mpi=openmpi org.supercontainers.gpu=cuda
+FROM build AS base
+LABEL org.supercontainers.glibc=2.35
+FROM scratch AS test
+LABEL org.supercontainers.target=zen2
+FROM base
+LABEL org.supercontainers.target=zen2`,
+			findings: []finding{{"label-not-final-stage", 6}},
+		},
+		{
+			name: "labels in a heredoc",
+			content: `FROM scratch
+LABEL org.supercontainers.mpi=openmpi org.supercontainers.gpu=cuda
+COPY <<EOF /Dockerfile
+FROM ubuntu
+LABEL org.supercontainers.mpi=lam
+EOF
+LABEL org.supercontainers.glibc=2.35 org.supercontainers.target=zen2`,
+		},
+	}
+	for _, test := range tests {
+		d, err := dockerfile.Parse(strings.NewReader(test.content))
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		found := []finding{}
+		for _, f := range Lint(d, Options{}) {
+			found = append(found, finding{f.Rule, f.Line})
+		}
+		if test.findings == nil {
+			test.findings = []finding{}
+		}
+		if !reflect.DeepEqual(found, test.findings) {
+			t.Errorf("%s: expected findings %v, got %v", test.name, test.findings, found)
+		}
+	}
+}
+
+func TestFailed(t *testing.T) {
+	d, err := dockerfile.Parse(strings.NewReader("FROM ubuntu\nLABEL org.supercontainers.mpi=openmpi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if findings := Lint(d, Options{Required: []string{"org.supercontainers.mpi"}}); Failed(findings) {
+		t.Errorf("expected an unpinned base image to be a warning, got %v", findings)
+	}
+	if findings := Lint(d, Options{}); !Failed(findings) {
+		t.Errorf("expected missing labels to fail, got %v", findings)
+	}
+}
@@ -0,0 +1,160 @@
+package rootfs
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"time"
+)
+
+// BindFS is a root file system with host files bind mounted into it, so we
+// can see what the container would load without mounting anything
+type BindFS struct {
+	base  fs.FS
+	files map[string]string
+	dirs  map[string]bool
+}
+
+// Bind returns base with host files (values) at container paths (keys).
+// Like a bind mount, a host symlink is followed, and symbolic links in the
+// container path are resolved within the root. Parent directories that
+// don't exist in the container are created.
+func Bind(base fs.FS, binds map[string]string) (*BindFS, error) {
+	b := BindFS{base: base, files: map[string]string{}, dirs: map[string]bool{}}
+	for target, source := range binds {
+		if _, err := os.Stat(source); err != nil {
+			return nil, err
+		}
+		resolved, err := resolve(base, target, true)
+		if err != nil {
+			return nil, err
+		}
+		b.files[resolved] = source
+		for dir := path.Dir(resolved); dir != "."; dir = path.Dir(dir) {
+			b.dirs[dir] = true
+		}
+	}
+	return &b, nil
+}
+
+// Bound determines if a path in the container is a bound host file
+func (b *BindFS) Bound(name string) bool {
+	resolved, err := Resolve(b, name)
+	if err != nil {
+		return false
+	}
+	_, ok := b.files[resolved]
+	return ok
+}
+
+// Lstat returns file info without following a symlink in the last component
+func (b *BindFS) Lstat(name string) (fs.FileInfo, error) {
+	if source, ok := b.files[name]; ok {
+		info, err := os.Stat(source)
+		if err != nil {
+			return nil, err
+		}
+		return boundInfo{FileInfo: info, name: path.Base(name)}, nil
+	}
+	info, err := fs.Lstat(b.base, name)
+	if err != nil && b.dirs[name] {
+		return dirInfo(path.Base(name)), nil
+	}
+	return info, err
+}
+
+// ReadLink returns the target of a symlink in the container
+func (b *BindFS) ReadLink(name string) (string, error) {
+	if _, ok := b.files[name]; ok {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return fs.ReadLink(b.base, name)
+}
+
+// Open opens a bound host file, a directory created for one, or a file of
+// the container
+func (b *BindFS) Open(name string) (fs.File, error) {
+	if source, ok := b.files[name]; ok {
+		return os.Open(source)
+	}
+	file, err := b.base.Open(name)
+	if err != nil && b.dirs[name] {
+		entries, err := b.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+		return &openDir{info: dirInfo(path.Base(name)), entries: entries}, nil
+	}
+	return file, err
+}
+
+// Stat returns file info, following symlinks within the root
+func (b *BindFS) Stat(name string) (fs.FileInfo, error) {
+	resolved, err := Resolve(b, name)
+	if err != nil {
+		return nil, err
+	}
+	return b.Lstat(resolved)
+}
+
+// ReadDir lists a directory of the container with bound files and
+// directories added
+func (b *BindFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	listed := map[string]fs.DirEntry{}
+	entries, err := fs.ReadDir(b.base, name)
+	if err != nil && !b.dirs[name] {
+		return nil, err
+	}
+	for _, entry := range entries {
+		listed[entry.Name()] = entry
+	}
+	for file := range b.files {
+		if path.Dir(file) == name {
+			if info, err := b.Lstat(file); err == nil {
+				listed[path.Base(file)] = fs.FileInfoToDirEntry(info)
+			}
+		}
+	}
+	for dir := range b.dirs {
+		if _, ok := listed[path.Base(dir)]; !ok && path.Dir(dir) == name {
+			listed[path.Base(dir)] = fs.FileInfoToDirEntry(dirInfo(path.Base(dir)))
+		}
+	}
+
+	names := []string{}
+	for name := range listed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	result := []fs.DirEntry{}
+	for _, name := range names {
+		result = append(result, listed[name])
+	}
+	return result, nil
+}
+
+// boundInfo is a host file with the name it is bound to
+type boundInfo struct {
+	fs.FileInfo
+	name string
+}
+
+func (b boundInfo) Name() string { return b.name }
+
+// dirInfo describes a directory created for a bind mount
+type dirInfo string
+
+func (d dirInfo) Name() string       { return string(d) }
+func (d dirInfo) Size() int64        { return 0 }
+func (d dirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0755 }
+func (d dirInfo) ModTime() time.Time { return time.Time{} }
+func (d dirInfo) IsDir() bool        { return true }
+func (d dirInfo) Sys() interface{}   { return nil }
+
+// Check that BindFS resolves symlinks like the other root file systems
+var (
+	_ fs.ReadLinkFS = (*BindFS)(nil)
+	_ fs.ReadDirFS  = (*BindFS)(nil)
+	_ fs.StatFS     = (*BindFS)(nil)
+)
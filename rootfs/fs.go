@@ -0,0 +1,471 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vsoch/containerspec/image"
+)
+
+// LayerFS is the merged file system of image layers, read lazily from the
+// layer tars without writing to disk. Layers are scanned once to build the
+// tree, and file content is streamed from the layer when a file is read.
+// A layer can only be read forward, so WalkFiles visits files in the order
+// of the layers, and going back to a file reads its layer again. It suits
+// walks and a few lookups; to read many files in any order, Unpack instead.
+type LayerFS struct {
+	root    *node
+	cursors []*cursor
+}
+
+// node is a file, directory or symlink in the merged tree
+type node struct {
+	name     string
+	mode     fs.FileMode
+	size     int64
+	modTime  time.Time
+	link     string
+	layer    int
+	offset   int64
+	children map[string]*node
+}
+
+// NewLayerFS indexes image layers into a merged file system
+func NewLayerFS(layers []image.Layer) (*LayerFS, error) {
+	l := LayerFS{root: newDir(".", -1)}
+	for i, layer := range layers {
+		l.cursors = append(l.cursors, &cursor{layer: layer})
+		if err := l.index(i, layer); err != nil {
+			return nil, fmt.Errorf("layer %s: %s", layer.Digest, err)
+		}
+	}
+	return &l, nil
+}
+
+// Close releases any layer streams still open
+func (l *LayerFS) Close() error {
+	for _, c := range l.cursors {
+		c.close()
+	}
+	return nil
+}
+
+func newDir(name string, layer int) *node {
+	return &node{name: name, mode: fs.ModeDir | 0755, layer: layer, children: map[string]*node{}}
+}
+
+// countingReader tracks the offset in the decompressed tar stream
+type countingReader struct {
+	reader io.Reader
+	offset int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.offset += int64(n)
+	return n, err
+}
+
+// index applies one layer to the tree
+func (l *LayerFS) index(layer int, content image.Layer) error {
+	stream, err := openLayer(content)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	counter := &countingReader{reader: stream}
+	reader := tar.NewReader(counter)
+	opaque := []string{}
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := Clean(header.Name)
+		if name == "." {
+			continue
+		}
+		if target, isOpaque, ok := whiteout(name); ok {
+			if isOpaque {
+				opaque = append(opaque, target)
+			} else if parent, base := l.lookupParent(target); parent != nil {
+				if existing, ok := parent.children[base]; ok && existing.layer < layer {
+					delete(parent.children, base)
+				}
+			}
+			continue
+		}
+
+		n := &node{
+			name:    path.Base(name),
+			mode:    header.FileInfo().Mode(),
+			size:    header.Size,
+			modTime: header.ModTime,
+			layer:   layer,
+			offset:  counter.offset,
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			n.children = map[string]*node{}
+		case tar.TypeSymlink:
+			n.link = header.Linkname
+			n.size = int64(len(header.Linkname))
+		case tar.TypeLink:
+			// A hard link shares the content of a file indexed earlier
+			parent, base := l.lookupParent(Clean(header.Linkname))
+			if parent == nil || parent.children[base] == nil {
+				continue
+			}
+			linked := parent.children[base]
+			n.mode, n.size, n.layer, n.offset = linked.mode, linked.size, linked.layer, linked.offset
+		case tar.TypeReg:
+		default:
+			continue
+		}
+		l.insert(name, n, layer)
+	}
+
+	for _, target := range opaque {
+		if parent, base := l.lookupParent(target); parent != nil && parent.children[base] != nil {
+			prune(parent.children[base], layer)
+		}
+	}
+	return nil
+}
+
+// insert adds a node, creating parent directories as needed. A directory
+// replacing a directory keeps its children.
+func (l *LayerFS) insert(name string, n *node, layer int) {
+	parent := l.root
+	parts := strings.Split(name, "/")
+	for _, part := range parts[:len(parts)-1] {
+		child, ok := parent.children[part]
+		if !ok || child.children == nil {
+			child = newDir(part, layer)
+			parent.children[part] = child
+		}
+		parent = child
+	}
+	if existing, ok := parent.children[n.name]; ok && existing.children != nil && n.children != nil {
+		n.children = existing.children
+	}
+	parent.children[n.name] = n
+}
+
+// prune removes content from lower layers under an opaque directory
+func prune(dir *node, layer int) {
+	for name, child := range dir.children {
+		if child.layer < layer {
+			delete(dir.children, name)
+		} else if child.children != nil {
+			prune(child, layer)
+		}
+	}
+}
+
+// lookupParent finds the directory holding name without following links
+func (l *LayerFS) lookupParent(name string) (*node, string) {
+	parts := strings.Split(name, "/")
+	parent := l.root
+	for _, part := range parts[:len(parts)-1] {
+		child, ok := parent.children[part]
+		if !ok || child.children == nil {
+			return nil, ""
+		}
+		parent = child
+	}
+	return parent, parts[len(parts)-1]
+}
+
+// lookup finds a node without following symlinks in any component
+func (l *LayerFS) lookup(name string) (*node, bool) {
+	if name == "." {
+		return l.root, true
+	}
+	parent, base := l.lookupParent(name)
+	if parent == nil {
+		return nil, false
+	}
+	n, ok := parent.children[base]
+	return n, ok
+}
+
+// Lstat returns file info without following a symlink in the last component
+func (l *LayerFS) Lstat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrInvalid}
+	}
+	dir, err := Resolve(l, path.Dir(name))
+	if err != nil {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrNotExist}
+	}
+	n, ok := l.lookup(Clean(path.Join(dir, path.Base(name))))
+	if !ok {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrNotExist}
+	}
+	return nodeInfo{n}, nil
+}
+
+// ReadLink returns the target of a symlink
+func (l *LayerFS) ReadLink(name string) (string, error) {
+	info, err := l.Lstat(name)
+	if err != nil {
+		return "", err
+	}
+	n := info.(nodeInfo).node
+	if n.mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return n.link, nil
+}
+
+// resolveNode follows symlinks within the root and returns the node
+func (l *LayerFS) resolveNode(op, name string) (*node, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	resolved, err := Resolve(l, name)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	n, ok := l.lookup(resolved)
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return n, nil
+}
+
+// Open opens a file or directory, following symlinks within the root
+func (l *LayerFS) Open(name string) (fs.File, error) {
+	n, err := l.resolveNode("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if n.children != nil {
+		return &openDir{info: nodeInfo{n}, entries: entries(n)}, nil
+	}
+	return &openFile{info: nodeInfo{n}, cursor: l.cursors[n.layer]}, nil
+}
+
+// ReadDir lists a directory sorted by name
+func (l *LayerFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	n, err := l.resolveNode("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if n.children == nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return entries(n), nil
+}
+
+// Stat returns file info, following symlinks within the root
+func (l *LayerFS) Stat(name string) (fs.FileInfo, error) {
+	n, err := l.resolveNode("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return nodeInfo{n}, nil
+}
+
+// walkFiles calls fn for every regular file, in the order of the layers and
+// of the files in each, so each layer is read once
+func (l *LayerFS) walkFiles(fn func(name string, d fs.DirEntry) error) error {
+	type file struct {
+		name string
+		node *node
+	}
+	files := []file{}
+	var collect func(dir *node, prefix string)
+	collect = func(dir *node, prefix string) {
+		for name, child := range dir.children {
+			name = path.Join(prefix, name)
+			switch {
+			case child.children != nil && !skipDirs[name]:
+				collect(child, name)
+			case child.mode.IsRegular():
+				files = append(files, file{name: name, node: child})
+			}
+		}
+	}
+	collect(l.root, "")
+	sort.Slice(files, func(i, j int) bool {
+		a, b := files[i].node, files[j].node
+		if a.layer != b.layer {
+			return a.layer < b.layer
+		}
+		if a.offset != b.offset {
+			return a.offset < b.offset
+		}
+		return files[i].name < files[j].name
+	})
+	for _, f := range files {
+		if err := fn(f.name, fs.FileInfoToDirEntry(nodeInfo{f.node})); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func entries(dir *node) []fs.DirEntry {
+	names := []string{}
+	for name := range dir.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := []fs.DirEntry{}
+	for _, name := range names {
+		list = append(list, fs.FileInfoToDirEntry(nodeInfo{dir.children[name]}))
+	}
+	return list
+}
+
+// maxRecent is how much of what a cursor just read it keeps
+const maxRecent = 1 << 20
+
+// cursor reads a layer forward, reopening it only to go backwards. Reading
+// files in the order they appear in the layer decompresses it just once.
+// What was just read is kept, so reading a file again (e.g., a hard link
+// to it, or its header after a peek) doesn't reopen the layer.
+type cursor struct {
+	mutex  sync.Mutex
+	layer  image.Layer
+	stream io.ReadCloser
+	offset int64
+
+	// recent is the content from start to offset
+	start  int64
+	recent []byte
+}
+
+// readAt reads layer content at an offset, which may be a short read
+func (c *cursor) readAt(p []byte, offset int64) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if offset >= c.start && offset < c.offset {
+		return copy(p, c.recent[offset-c.start:]), nil
+	}
+	if c.stream == nil || offset < c.offset {
+		c.closeStream()
+		stream, err := openLayer(c.layer)
+		if err != nil {
+			return 0, err
+		}
+		c.stream, c.offset = stream, 0
+	}
+	if offset > c.offset {
+		if _, err := io.CopyN(io.Discard, c.stream, offset-c.offset); err != nil {
+			c.closeStream()
+			return 0, err
+		}
+		c.offset, c.start, c.recent = offset, offset, c.recent[:0]
+	}
+
+	n, err := io.ReadFull(c.stream, p)
+	c.offset += int64(n)
+	if len(c.recent)+n > maxRecent {
+		c.start, c.recent = c.offset, c.recent[:0]
+	} else {
+		c.recent = append(c.recent, p[:n]...)
+	}
+	if err != nil {
+		c.closeStream()
+		return n, err
+	}
+	return n, nil
+}
+
+func (c *cursor) close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.closeStream()
+}
+
+func (c *cursor) closeStream() {
+	if c.stream != nil {
+		c.stream.Close()
+		c.stream = nil
+	}
+	c.offset, c.start, c.recent = 0, 0, c.recent[:0]
+}
+
+// nodeInfo implements fs.FileInfo
+type nodeInfo struct {
+	node *node
+}
+
+func (i nodeInfo) Name() string       { return i.node.name }
+func (i nodeInfo) Size() int64        { return i.node.size }
+func (i nodeInfo) Mode() fs.FileMode  { return i.node.mode }
+func (i nodeInfo) ModTime() time.Time { return i.node.modTime }
+func (i nodeInfo) IsDir() bool        { return i.node.children != nil }
+func (i nodeInfo) Sys() interface{}   { return nil }
+
+// openFile is a regular file, read from its layer as it is read
+type openFile struct {
+	info   nodeInfo
+	cursor *cursor
+	read   int64
+}
+
+func (f *openFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *openFile) Close() error               { return nil }
+
+func (f *openFile) Read(p []byte) (int, error) {
+	remaining := f.info.node.size - f.read
+	if remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := f.cursor.readAt(p, f.info.node.offset+f.read)
+	f.read += int64(n)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		err = fmt.Errorf("%s is truncated in its layer", f.info.node.name)
+	}
+	return n, err
+}
+
+// openDir is a directory that can be listed
+type openDir struct {
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *openDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *openDir) Close() error               { return nil }
+
+func (d *openDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: errors.New("is a directory")}
+}
+
+func (d *openDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	remaining := d.entries[d.offset:]
+	if count <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(remaining) {
+		count = len(remaining)
+	}
+	d.offset += count
+	return remaining[:count], nil
+}
@@ -0,0 +1,105 @@
+package rootfs
+
+import (
+	"io"
+	"io/fs"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/vsoch/containerspec/image"
+)
+
+// countOpens counts how many times a layer is read
+func countOpens(layer image.Layer, opens *int) image.Layer {
+	return image.NewLayer(layer.MediaType, layer.Digest, layer.Size, func() (io.ReadCloser, error) {
+		*opens++
+		return layer.Open()
+	})
+}
+
+func TestLayerFSWalkFiles(t *testing.T) {
+	// Files are in the reverse of lexical order in the layers, and a hard
+	// link goes back to a file already read
+	opens := []int{0, 0}
+	lower := countOpens(testLayer(t,
+		entry{name: "z/"},
+		entry{name: "z/last", content: strings.Repeat("z", 4096)},
+		entry{name: "m", content: "middle"},
+		entry{name: "a", content: "first"},
+		entry{name: "proc/"},
+		entry{name: "proc/cpuinfo", content: "virtual"},
+	), &opens[0])
+	upper := countOpens(testLayer(t,
+		entry{name: "y", content: "upper"},
+		entry{name: "b", hardlink: "y"},
+		entry{name: ".wh.m"},
+	), &opens[1])
+
+	layers, err := NewLayerFS([]image.Layer{lower, upper})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer layers.Close()
+
+	files := map[string]string{}
+	err = WalkFiles(layers, func(name string, d fs.DirEntry) error {
+		content, err := fs.ReadFile(layers, name)
+		files[name] = string(content)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"z/last": strings.Repeat("z", 4096), "a": "first", "y": "upper", "b": "upper"}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("expected %v, got %v", want, files)
+	}
+
+	// Each layer is read once to index it, and once for the walk
+	if !reflect.DeepEqual(opens, []int{2, 2}) {
+		t.Errorf("expected each layer to be opened twice, got %v", opens)
+	}
+}
+
+func TestLayerFSOpen(t *testing.T) {
+	layers, err := NewLayerFS([]image.Layer{lowerLayer(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer layers.Close()
+
+	// Files can be read in any order, and partly
+	for _, name := range []string{"opt/old", "opt/a", "lib/libfoo.so", "opt/old"} {
+		content, err := fs.ReadFile(layers, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := map[string]string{"opt/old": "old", "opt/a": "a", "lib/libfoo.so": "foo"}[name]; string(content) != want {
+			t.Errorf("%s: expected %q, got %q", name, want, content)
+		}
+	}
+	file, err := layers.Open("opt/old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peek := make([]byte, 2)
+	if _, err := io.ReadFull(file, peek); err != nil || string(peek) != "ol" {
+		t.Errorf("expected to read ol, got %q (%v)", peek, err)
+	}
+	file.Close()
+
+	entries, err := fs.ReadDir(layers, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	if want := []string{"lib", "opt", "usr"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("expected %v, got %v", want, names)
+	}
+}
@@ -0,0 +1,88 @@
+package rootfs
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/vsoch/containerspec/image"
+)
+
+// Layers are applied in order. A file named .wh.<name> removes <name> from
+// lower layers, and .wh..wh..opq makes its directory opaque, hiding all
+// lower layer content. See the OCI image-spec layer documentation.
+
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Decompress returns the tar stream of a layer, detecting gzip and zstd
+// compression from the content rather than trusting the media type
+func Decompress(reader io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(reader)
+	magic, err := buffered.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(buffered)
+	case bytes.HasPrefix(magic, zstdMagic):
+		decoder, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return io.NopCloser(buffered), nil
+}
+
+// openLayer opens a layer and returns the decompressed tar stream
+func openLayer(layer image.Layer) (io.ReadCloser, error) {
+	raw, err := layer.Open()
+	if err != nil {
+		return nil, err
+	}
+	stream, err := Decompress(raw)
+	if err != nil {
+		raw.Close()
+		return nil, fmt.Errorf("layer %s: %s", layer.Digest, err)
+	}
+	return layerStream{stream, raw}, nil
+}
+
+// layerStream closes both the decompressor and the underlying layer
+type layerStream struct {
+	io.ReadCloser
+	raw io.Closer
+}
+
+func (l layerStream) Close() error {
+	l.ReadCloser.Close()
+	return l.raw.Close()
+}
+
+// whiteout returns the path a whiteout entry removes, and whether it is an
+// opaque marker for its directory
+func whiteout(name string) (target string, opaque bool, ok bool) {
+	dir, base := path.Split(name)
+	dir = Clean(dir)
+	switch {
+	case base == whiteoutOpaque:
+		return dir, true, true
+	case strings.HasPrefix(base, whiteoutPrefix):
+		return Clean(path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))), false, true
+	}
+	return "", false, false
+}
@@ -0,0 +1,167 @@
+package rootfs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+// A container root file system is exposed as an fs.FS, so the same detection
+// works on a directory, a set of image layers, or a squashfs partition.
+// Symbolic links are resolved within the root: an absolute link target is
+// relative to the container root, never to the host.
+
+// maxLinks is the number of symlinks followed before giving up (as in Linux)
+const maxLinks = 40
+
+// ErrTooManyLinks is returned when a path cannot be resolved
+var ErrTooManyLinks = errors.New("too many levels of symbolic links")
+
+// Dir returns a file system for a container root unpacked on disk
+func Dir(root string) fs.FS {
+	return os.DirFS(root)
+}
+
+// Clean turns an absolute or relative container path into an fs.FS path
+func Clean(name string) string {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return "."
+	}
+	return strings.TrimPrefix(name, "/")
+}
+
+// Resolve follows symbolic links in every component of name, staying inside
+// the root, and returns the resolved fs.FS path
+func Resolve(fsys fs.FS, name string) (string, error) {
+	return resolve(fsys, name, false)
+}
+
+// resolve follows symbolic links, optionally allowing the path (and so
+// everything after the first missing component) to not exist yet
+func resolve(fsys fs.FS, name string, allowMissing bool) (string, error) {
+	if _, ok := fsys.(fs.ReadLinkFS); !ok {
+		return Clean(name), nil
+	}
+	resolved := []string{}
+	pending := strings.Split(Clean(name), "/")
+	links := 0
+	missing := false
+	for len(pending) > 0 {
+		part := pending[0]
+		pending = pending[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) > 0 {
+				resolved = resolved[:len(resolved)-1]
+			}
+			continue
+		}
+		if missing {
+			resolved = append(resolved, part)
+			continue
+		}
+		current := strings.Join(append(resolved, part), "/")
+		info, err := fs.Lstat(fsys, current)
+		if allowMissing && errors.Is(err, fs.ErrNotExist) {
+			missing = true
+			resolved = append(resolved, part)
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			resolved = append(resolved, part)
+			continue
+		}
+		links++
+		if links > maxLinks {
+			return "", &fs.PathError{Op: "resolve", Path: name, Err: ErrTooManyLinks}
+		}
+		target, err := fs.ReadLink(fsys, current)
+		if err != nil {
+			return "", err
+		}
+		if strings.HasPrefix(target, "/") {
+			resolved = []string{}
+		}
+		pending = append(strings.Split(target, "/"), pending...)
+	}
+	if len(resolved) == 0 {
+		return ".", nil
+	}
+	return strings.Join(resolved, "/"), nil
+}
+
+// Open opens a file after resolving symbolic links within the root
+func Open(fsys fs.FS, name string) (fs.File, error) {
+	resolved, err := Resolve(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	return fsys.Open(resolved)
+}
+
+// ReadFile reads a file after resolving symbolic links within the root
+func ReadFile(fsys fs.FS, name string) ([]byte, error) {
+	resolved, err := Resolve(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.ReadFile(fsys, resolved)
+}
+
+// Stat returns file info after resolving symbolic links within the root
+func Stat(fsys fs.FS, name string) (fs.FileInfo, error) {
+	resolved, err := Resolve(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Stat(fsys, resolved)
+}
+
+// Exists determines if a path exists in the root
+func Exists(fsys fs.FS, name string) bool {
+	_, err := Stat(fsys, name)
+	return err == nil
+}
+
+// skipDirs are virtual file systems that may be present in a live root
+var skipDirs = map[string]bool{"proc": true, "sys": true, "dev": true}
+
+// WalkFiles calls fn for every regular file in the root, not following
+// symbolic links, and skipping virtual file systems. Files are visited in
+// lexical order, except in a LayerFS where they are in the order of the
+// layers, so each is read once.
+func WalkFiles(fsys fs.FS, fn func(name string, d fs.DirEntry) error) error {
+	if layers, ok := fsys.(*LayerFS); ok {
+		return layers.walkFiles(fn)
+	}
+	return fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if name == "." {
+				return err
+			}
+			// Unreadable directories are skipped rather than failing the walk
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if skipDirs[name] {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		return fn(name, d)
+	})
+}
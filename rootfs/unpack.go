@@ -0,0 +1,186 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/vsoch/containerspec/image"
+)
+
+// Unpack extracts image layers in order to a directory, applying whiteouts.
+// We don't run as root, so ownership isn't preserved, device files are
+// skipped, and directories are kept writable by the owner.
+func Unpack(layers []image.Layer, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, layer := range layers {
+		if err := unpackLayer(layer, dir); err != nil {
+			return fmt.Errorf("layer %s: %s", layer.Digest, err)
+		}
+	}
+	return nil
+}
+
+// unpackLayer applies one layer on top of what is already in dir
+func unpackLayer(layer image.Layer, dir string) error {
+	stream, err := openLayer(layer)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	root := Dir(dir)
+	written := map[string]bool{}
+	opaque := []string{}
+
+	reader := tar.NewReader(stream)
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := Clean(header.Name)
+		if name == "." {
+			continue
+		}
+
+		// Whiteouts only hide lower layers, opaque directories are
+		// cleared once the whole layer is applied
+		if target, isOpaque, ok := whiteout(name); ok {
+			if isOpaque {
+				opaque = append(opaque, target)
+			} else if !written[target] {
+				if resolved, err := resolveEntry(root, target, true); err == nil {
+					os.RemoveAll(filepath.Join(dir, resolved))
+				}
+			}
+			continue
+		}
+
+		// Resolve the parent within the root so a symlink in the image
+		// can't redirect the write outside of it
+		parent, err := resolveCreate(root, path.Dir(name))
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, parent, path.Base(name))
+		if err := os.MkdirAll(filepath.Join(dir, parent), 0755); err != nil {
+			return err
+		}
+		if err := writeEntry(root, dir, target, header, reader); err != nil {
+			return err
+		}
+		written[name] = true
+	}
+
+	for _, target := range opaque {
+		if err := clearOpaque(dir, target, written); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeEntry creates one file, directory or link
+func writeEntry(root fs.FS, dir, target string, header *tar.Header, reader io.Reader) error {
+	mode := fs.FileMode(header.Mode).Perm()
+
+	// A directory replaces anything but a directory, everything else
+	// replaces what was there
+	if info, err := os.Lstat(target); err == nil {
+		if header.Typeflag != tar.TypeDir || !info.IsDir() {
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(target, mode|0700); err != nil {
+			return err
+		}
+		return os.Chmod(target, mode|0700)
+
+	case tar.TypeReg:
+		file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(file, reader); err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
+
+	case tar.TypeSymlink:
+		return os.Symlink(header.Linkname, target)
+
+	case tar.TypeLink:
+		linked, err := resolveEntry(root, header.Linkname, false)
+		if err != nil {
+			return err
+		}
+		return os.Link(filepath.Join(dir, linked), target)
+	}
+
+	// Devices and fifos can't be created without privileges
+	return nil
+}
+
+// clearOpaque removes everything under an opaque directory that wasn't
+// written by the current layer
+func clearOpaque(dir, opaque string, written map[string]bool) error {
+	resolved, err := resolveEntry(Dir(dir), opaque, true)
+	if err != nil {
+		return nil
+	}
+
+	// A symlink the layer didn't replace with a directory has nothing under
+	// it to clear
+	top := filepath.Join(dir, resolved)
+	if info, err := os.Lstat(top); err != nil || !info.IsDir() {
+		return nil
+	}
+	return filepath.WalkDir(top, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || name == top {
+			return nil
+		}
+		relative, _ := filepath.Rel(top, name)
+		if !written[Clean(path.Join(opaque, filepath.ToSlash(relative)))] {
+			if err := os.RemoveAll(name); err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+		}
+		return nil
+	})
+}
+
+// resolveEntry resolves symlinks in the parent of a path but not in its
+// last component, since a whiteout or hard link names the entry itself
+// (e.g., a link to remove, not the directory it points to)
+func resolveEntry(fsys fs.FS, name string, allowMissing bool) (string, error) {
+	parent, err := resolve(fsys, path.Dir(Clean(name)), allowMissing)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(parent, path.Base(Clean(name))), nil
+}
+
+// resolveCreate resolves symlinks in a path that may not exist yet
+func resolveCreate(fsys fs.FS, name string) (string, error) {
+	return resolve(fsys, name, true)
+}
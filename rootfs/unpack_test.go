@@ -0,0 +1,142 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vsoch/containerspec/image"
+)
+
+// entry is a file in a test layer: a directory if it ends in /, a symlink
+// or hard link if it has a link, and otherwise a file with content
+type entry struct {
+	name     string
+	content  string
+	symlink  string
+	hardlink string
+}
+
+// testLayer writes entries to an uncompressed layer
+func testLayer(t *testing.T, entries ...entry) image.Layer {
+	t.Helper()
+	var buffer bytes.Buffer
+	writer := tar.NewWriter(&buffer)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(e.content))}
+		switch {
+		case e.name[len(e.name)-1] == '/':
+			header.Typeflag, header.Mode, header.Size = tar.TypeDir, 0755, 0
+		case e.symlink != "":
+			header.Typeflag, header.Linkname, header.Size = tar.TypeSymlink, e.symlink, 0
+		case e.hardlink != "":
+			header.Typeflag, header.Linkname, header.Size = tar.TypeLink, e.hardlink, 0
+		}
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := writer.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	content := buffer.Bytes()
+	return image.NewLayer("application/vnd.oci.image.layer.v1.tar", "test", int64(len(content)), func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(content)), nil
+	})
+}
+
+// lowerLayer has a library directory and a lib symlink to it, as in
+// distributions with a merged /usr
+func lowerLayer(t *testing.T) image.Layer {
+	return testLayer(t,
+		entry{name: "usr/"},
+		entry{name: "usr/lib/"},
+		entry{name: "usr/lib/libfoo.so", content: "foo"},
+		entry{name: "lib", symlink: "usr/lib"},
+		entry{name: "opt/"},
+		entry{name: "opt/a", content: "a"},
+		entry{name: "opt/old", content: "old"},
+	)
+}
+
+func TestUnpackWhiteouts(t *testing.T) {
+	tests := []struct {
+		name    string
+		upper   []entry
+		exists  []string
+		missing []string
+		links   []string
+	}{
+		{
+			name:    "whiteout of a symlink removes the link",
+			upper:   []entry{{name: ".wh.lib"}},
+			exists:  []string{"usr/lib/libfoo.so"},
+			missing: []string{"lib"},
+		},
+		{
+			name:    "whiteout through a symlinked parent",
+			upper:   []entry{{name: "lib/.wh.libfoo.so"}},
+			exists:  []string{"usr/lib"},
+			missing: []string{"usr/lib/libfoo.so"},
+			links:   []string{"lib"},
+		},
+		{
+			name:   "opaque marker under a symlink keeps its target",
+			upper:  []entry{{name: "lib/.wh..wh..opq"}},
+			exists: []string{"usr/lib/libfoo.so"},
+			links:  []string{"lib"},
+		},
+		{
+			name:    "opaque directory replacing a symlink",
+			upper:   []entry{{name: "lib/"}, {name: "lib/.wh..wh..opq"}, {name: "lib/libbar.so", content: "bar"}},
+			exists:  []string{"usr/lib/libfoo.so", "lib/libbar.so"},
+			missing: []string{"lib/libfoo.so"},
+		},
+		{
+			name:    "opaque directory",
+			upper:   []entry{{name: "opt/"}, {name: "opt/.wh..wh..opq"}, {name: "opt/b", content: "b"}},
+			exists:  []string{"opt/b"},
+			missing: []string{"opt/a", "opt/old"},
+		},
+		{
+			name:    "whiteout of a file",
+			upper:   []entry{{name: "opt/.wh.old"}},
+			exists:  []string{"opt/a"},
+			missing: []string{"opt/old"},
+		},
+		{
+			name:   "hard link to a symlink links the symlink",
+			upper:  []entry{{name: "lib64", hardlink: "lib"}},
+			exists: []string{"usr/lib/libfoo.so"},
+			links:  []string{"lib", "lib64"},
+		},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		if err := Unpack([]image.Layer{lowerLayer(t), testLayer(t, test.upper...)}, dir); err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		for _, name := range test.exists {
+			if _, err := os.Lstat(filepath.Join(dir, name)); err != nil {
+				t.Errorf("%s: expected %s to exist: %s", test.name, name, err)
+			}
+		}
+		for _, name := range test.missing {
+			if _, err := os.Lstat(filepath.Join(dir, name)); err == nil {
+				t.Errorf("%s: expected %s to be removed", test.name, name)
+			}
+		}
+		for _, name := range test.links {
+			if info, err := os.Lstat(filepath.Join(dir, name)); err != nil || info.Mode()&os.ModeSymlink == 0 {
+				t.Errorf("%s: expected %s to be a symlink", test.name, name)
+			}
+		}
+	}
+}
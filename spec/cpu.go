@@ -1,6 +1,5 @@
 package spec
 
-/*
 var CpuArches = map[string]Microarchitecture{
 	"x86": {
 		Name:   "x86",
@@ -40,12 +39,12 @@ var CpuArches = map[string]Microarchitecture{
 		Compilers: map[string][]Compiler{
 			"gcc": {
 				{
-					Name:     "gcc",
+					Name:     "x86-64",
 					Versions: "4.2.0:",
 					Flags:    "-march={name} -mtune=generic",
 				},
 				{
-					Name:     "gcc",
+					Name:     "x86-64",
 					Versions: ":4.1.2",
 					Flags:    "-march={name} -mtune={name}",
 				},
@@ -2009,6 +2008,10 @@ var FeatureAliases = map[string]FeatureAlias{
 		Reason: "permits to refer to sse4_2 also as sse4.2",
 		AnyOf:  []string{"sse4_2"},
 	},
+	"sse3": {
+		Reason: "SSE3 is provided by every processor with SSSE3, but generic levels (e.g., x86_64_v2) don't list it",
+		AnyOf:  []string{"ssse3"},
+	},
 	"neon": {
 		Reason:   "NEON is required in all standard ARMv8 implementations",
 		Families: []string{"aarch64"},
@@ -2043,4 +2046,3 @@ var Conversions = map[string]Conversion{
 		"xsave":   "xsavec xsaveopt",
 	},
 }
-*/
@@ -4,25 +4,114 @@ import (
 	"bufio"
 	"log"
 	"os"
+	"regexp"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 
-	"github.com/archspec/archspec-go/archspec/cpu"
 	"github.com/vsoch/containerspec/utils"
 )
 
+// Linux names some features differently than the CPU database
+var linuxFeatures = map[string]string{
+	"pni": "sse3",
+}
+
+var powerRegex = regexp.MustCompile(`POWER([0-9]+)`)
+
 // Detect the host architecture (this maps to the detect command)
-func Detect() cpu.Microarchitecture {
+func Detect() Microarchitecture {
 
 	// Currently just support parsing Linux
-	if runtime.GOOS == "linux" {
-		//info := getInfoLinux()
-		// TODO: we want to map something from info here to cpu.TARGETS
-
-	} else {
+	if runtime.GOOS != "linux" {
 		log.Fatal("Currently only Linux is supporting, because Macs and Windows are terrible.")
 	}
-	return cpu.TARGETS["x86"]
+	info := getInfoLinux()
+	return detectMicroarchitecture(GoarchFamily(runtime.GOARCH), cpuVendor(info), cpuFeatures(info), cpuGeneration(info))
+}
+
+// detectMicroarchitecture chooses the most specific database entry of the
+// family, for the vendor or generic, whose features the processor has. POWER
+// entries have no features, so their generation can't be newer than the
+// processor's.
+func detectMicroarchitecture(family, vendor string, features []string, generation int) Microarchitecture {
+	host := map[string]bool{}
+	for _, feature := range features {
+		host[feature] = true
+	}
+	best, ok := CpuArches[family]
+	if !ok {
+		return Microarchitecture{Name: family, Vendor: "generic"}
+	}
+	for _, name := range SortedMicroarchitectures() {
+		arch := CpuArches[name]
+		if arch.Family().Name != family || (arch.Vendor != "generic" && arch.Vendor != vendor) {
+			continue
+		}
+		if arch.Generation > generation {
+			continue
+		}
+		supported := true
+		for _, feature := range arch.Features {
+			if !host[feature] {
+				supported = false
+				break
+			}
+		}
+		if !supported {
+			continue
+		}
+		if arch.Depth() > best.Depth() || (arch.Depth() == best.Depth() && len(arch.Features) > len(best.Features)) {
+			best = arch
+		}
+	}
+	return best
+}
+
+// cpuVendor returns the vendor of the processor as named in the database
+func cpuVendor(info map[string]string) string {
+	if vendor, ok := info["vendor_id"]; ok {
+		return vendor
+	}
+	if implementer, ok := info["CPU implementer"]; ok {
+		return Conversions["arm_vendors"][implementer]
+	}
+	if strings.Contains(info["cpu"], "POWER") {
+		return "IBM"
+	}
+	return ""
+}
+
+// cpuFeatures returns the sorted features of the processor (flags on x86,
+// Features on ARM), with Linux names converted
+func cpuFeatures(info map[string]string) []string {
+	value, ok := info["flags"]
+	if !ok {
+		value = info["Features"]
+	}
+	seen := map[string]bool{}
+	for _, feature := range strings.Fields(value) {
+		seen[feature] = true
+		if converted, ok := linuxFeatures[feature]; ok {
+			seen[converted] = true
+		}
+	}
+	features := []string{}
+	for feature := range seen {
+		features = append(features, feature)
+	}
+	sort.Strings(features)
+	return features
+}
+
+// cpuGeneration returns the generation of a POWER processor, or 0
+func cpuGeneration(info map[string]string) int {
+	if match := powerRegex.FindStringSubmatch(info["cpu"]); match != nil {
+		generation, _ := strconv.Atoi(match[1])
+		return generation
+	}
+	return 0
 }
 
 // Returns a raw info dictionary by parsing the first entry of /proc/cpuinfo
@@ -40,7 +129,7 @@ func getInfoLinux() map[string]string {
 	for scanner.Scan() {
 
 		// key, separator, value
-		values := strings.Split(scanner.Text(), ":")
+		values := strings.SplitN(scanner.Text(), ":", 2)
 
 		// If there's no separator and info was already populated
 		// according to what's written here:
@@ -49,7 +138,7 @@ func getInfoLinux() map[string]string {
 		//
 		// we are on a blank line separating two cpus. Exit early as
 		// we want to read just the first entry in /proc/cpuinfo
-		if len(values) == 0 && len(info) > 0 {
+		if strings.TrimSpace(values[0]) == "" && len(info) > 0 {
 			break
 		}
 		// The key is the first in the list of values
@@ -0,0 +1,172 @@
+package spec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/vsoch/containerspec/rootfs"
+	"github.com/vsoch/containerspec/utils"
+)
+
+// Host is what we detect about the machine a container would run on. It is
+// the other side of the supercontainers labels, and can be saved as json.
+type Host struct {
+	Arch     string   `json:"arch"`
+	Target   string   `json:"target,omitempty"`
+	Vendor   string   `json:"vendor,omitempty"`
+	Features []string `json:"features,omitempty"`
+	Glibc    string   `json:"glibc,omitempty"`
+	Kernel   string   `json:"kernel,omitempty"`
+	MPI      *MPI     `json:"mpi,omitempty"`
+	GPU      []string `json:"gpu,omitempty"`
+
+	// Platform names the target as OCI, Go and uname do
+	Platform *Platform `json:"platform,omitempty"`
+
+	// CUDACapability is the lowest compute capability of the GPUs
+	CUDACapability string `json:"cuda_capability,omitempty"`
+
+	// Properties are facts a site adds for its own rules (e.g., the UCX
+	// version its fabric needs), we don't detect them
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+// MPI is an MPI installation on the host, bound into containers that need it
+type MPI struct {
+	Family  string `json:"family"`
+	Version string `json:"version,omitempty"`
+}
+
+var (
+	kernelRegex     = regexp.MustCompile(`^[0-9]+(\.[0-9]+)*`)
+	mpiVersionRegex = regexp.MustCompile(`([0-9]+\.[0-9]+(\.[0-9]+)?)`)
+
+	// Where glibc is on Debian (multiarch), Red Hat (lib64) and others
+	hostLibc = []string{"lib/*-linux-gnu*/libc.so.6", "usr/lib/*-linux-gnu*/libc.so.6", "lib64/libc.so.6", "usr/lib64/libc.so.6", "lib/libc.so.6", "usr/lib/libc.so.6"}
+)
+
+// Commands that identify an MPI implementation, and print its version
+var mpiCommands = []struct {
+	Family  string
+	Command []string
+}{
+	{"openmpi", []string{"ompi_info", "--version"}},
+	{"mpich", []string{"mpichversion"}},
+}
+
+// DetectHost detects the processor, glibc, kernel, MPI and GPUs of the host
+func DetectHost() *Host {
+	arch := Detect()
+	info := getInfoLinux()
+	host := Host{
+		Arch:     arch.Family().Name,
+		Target:   arch.Name,
+		Vendor:   cpuVendor(info),
+		Features: cpuFeatures(info),
+		Glibc:    hostGlibc(rootfs.Dir("/")),
+		Kernel:   hostKernel(),
+		MPI:      hostMPI(),
+	}
+	if host.Arch == "" {
+		host.Arch = GoarchFamily(runtime.GOARCH)
+	}
+	if platform, ok := PlatformOf(host.Target); ok {
+		host.Platform = &platform
+	}
+	host.GPU, host.CUDACapability = hostGPU()
+	return &host
+}
+
+// ParseHost reads a host spec saved as json. The architecture can also be
+// spelled as uname -m prints it on any kernel (e.g., arm64 or i686).
+func ParseHost(content []byte) (*Host, error) {
+	host := Host{}
+	if err := json.Unmarshal(content, &host); err != nil {
+		return nil, err
+	}
+	if family, ok := UnameFamily(host.Arch); ok {
+		host.Arch = family
+	}
+	return &host, nil
+}
+
+// hostGlibc returns the version of the first glibc found
+func hostGlibc(fsys fs.FS) string {
+	for _, pattern := range hostLibc {
+		matches, _ := fs.Glob(fsys, pattern)
+		for _, match := range matches {
+			if version := glibcVersion(fsys, match); version != "" {
+				return version
+			}
+		}
+	}
+	return ""
+}
+
+// hostKernel returns the version of the running kernel, without the
+// distribution suffix (e.g., 5.14.0 for 5.14.0-362.el9.x86_64)
+func hostKernel() string {
+	content, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return ""
+	}
+	return kernelRegex.FindString(strings.TrimSpace(string(content)))
+}
+
+// hostMPI finds an MPI implementation on the PATH
+func hostMPI() *MPI {
+	for _, mpi := range mpiCommands {
+		if _, err := exec.LookPath(mpi.Command[0]); err != nil {
+			continue
+		}
+		found := MPI{Family: mpi.Family}
+		if output, err := exec.Command(mpi.Command[0], mpi.Command[1:]...).Output(); err == nil {
+			found.Version = mpiVersionRegex.FindString(string(output))
+		}
+		return &found
+	}
+	return nil
+}
+
+// hostGPU finds GPU drivers, and the compute capability of NVIDIA GPUs
+func hostGPU() ([]string, string) {
+	gpus := []string{}
+	capability := ""
+	if _, err := os.Stat("/proc/driver/nvidia/version"); err == nil {
+		gpus = append(gpus, "cuda")
+		capability = cudaCapability()
+	}
+	if _, err := os.Stat("/dev/kfd"); err == nil {
+		gpus = append(gpus, "rocm")
+	}
+	if matches, _ := fs.Glob(rootfs.Dir("/"), "etc/OpenCL/vendors/*.icd"); len(matches) > 0 {
+		gpus = append(gpus, "opencl")
+	}
+	sort.Strings(gpus)
+	return gpus, capability
+}
+
+// cudaCapability asks nvidia-smi for the lowest compute capability
+func cudaCapability() string {
+	output, err := exec.Command("nvidia-smi", "--query-gpu=compute_cap", "--format=csv,noheader").Output()
+	if err != nil {
+		return ""
+	}
+	lowest := ""
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		capability := strings.TrimSpace(scanner.Text())
+		if lowest == "" || utils.CompareVersions(capability, lowest) < 0 {
+			lowest = capability
+		}
+	}
+	return lowest
+}
@@ -0,0 +1,300 @@
+package spec
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/vsoch/containerspec/utils"
+)
+
+// Compiler describes how a compiler version range targets a microarchitecture
+type Compiler struct {
+	Name     string
+	Versions string
+	Flags    string
+	Family   []string
+	Warnings []string
+}
+
+// Microarchitecture is one entry in the CPU database (CpuArches)
+type Microarchitecture struct {
+	Name       string
+	From       []string
+	Vendor     string
+	Features   []string
+	Generation int
+	Compilers  map[string][]Compiler
+}
+
+// FeatureAlias lets a feature be satisfied by other features or families
+type FeatureAlias struct {
+	Reason   string
+	AnyOf    []string
+	Families []string
+}
+
+// Conversion maps vendor or platform specific names to archspec names
+type Conversion map[string]string
+
+// The database entries don't all repeat their key as a name, so fill it in
+func init() {
+	for name, arch := range CpuArches {
+		arch.Name = name
+		CpuArches[name] = arch
+	}
+}
+
+// LookupMicroarchitecture returns the database entry for a name, if it exists
+func LookupMicroarchitecture(name string) (Microarchitecture, bool) {
+	arch, ok := CpuArches[strings.TrimSpace(name)]
+	return arch, ok
+}
+
+// Parents returns the direct parents of the microarchitecture
+func (m Microarchitecture) Parents() []Microarchitecture {
+	parents := []Microarchitecture{}
+	for _, name := range m.From {
+		if parent, ok := CpuArches[name]; ok {
+			parents = append(parents, parent)
+		}
+	}
+	return parents
+}
+
+// Ancestors returns all ancestors, nearest first, without duplicates
+func (m Microarchitecture) Ancestors() []Microarchitecture {
+	ancestors := []Microarchitecture{}
+	seen := map[string]bool{}
+	queue := m.Parents()
+	for len(queue) > 0 {
+		arch := queue[0]
+		queue = queue[1:]
+		if seen[arch.Name] {
+			continue
+		}
+		seen[arch.Name] = true
+		ancestors = append(ancestors, arch)
+		queue = append(queue, arch.Parents()...)
+	}
+	return ancestors
+}
+
+// Distance is the number of steps between two microarchitectures through
+// their closest common ancestor, e.g., 1 from haswell to its parent ivybridge.
+// It is false if they aren't in the same family.
+func (m Microarchitecture) Distance(other Microarchitecture) (int, bool) {
+	mine, theirs := m.steps(), other.steps()
+	distance := -1
+	for name, a := range mine {
+		if b, ok := theirs[name]; ok && (distance < 0 || a+b < distance) {
+			distance = a + b
+		}
+	}
+	return distance, distance >= 0
+}
+
+// steps maps the microarchitecture and its ancestors to the fewest steps up
+// the graph to reach them
+func (m Microarchitecture) steps() map[string]int {
+	steps := map[string]int{m.Name: 0}
+	queue := []Microarchitecture{m}
+	for len(queue) > 0 {
+		arch := queue[0]
+		queue = queue[1:]
+		for _, parent := range arch.Parents() {
+			if _, ok := steps[parent.Name]; !ok {
+				steps[parent.Name] = steps[arch.Name] + 1
+				queue = append(queue, parent)
+			}
+		}
+	}
+	return steps
+}
+
+// Descendants returns every microarchitecture that has this one as an ancestor
+func (m Microarchitecture) Descendants() []Microarchitecture {
+	descendants := []Microarchitecture{}
+	for _, name := range SortedMicroarchitectures() {
+		arch := CpuArches[name]
+		if arch.Name != m.Name && m.CompatibleWith(arch) {
+			descendants = append(descendants, arch)
+		}
+	}
+	return descendants
+}
+
+// Family returns the generic architecture (e.g., x86_64) of the microarchitecture
+func (m Microarchitecture) Family() Microarchitecture {
+	if len(m.From) == 0 {
+		return m
+	}
+	for _, arch := range m.Ancestors() {
+		if len(arch.From) == 0 {
+			return arch
+		}
+	}
+	return m
+}
+
+// Depth is the length of the longest path to the family, a proxy for how specific it is
+func (m Microarchitecture) Depth() int {
+	depth := 0
+	for _, parent := range m.Parents() {
+		if d := parent.Depth() + 1; d > depth {
+			depth = d
+		}
+	}
+	return depth
+}
+
+// Supports determines if a feature is provided, directly or by an alias
+func (m Microarchitecture) Supports(feature string) bool {
+	for _, f := range m.Features {
+		if f == feature {
+			return true
+		}
+	}
+	alias, ok := FeatureAliases[feature]
+	if !ok {
+		return false
+	}
+	result := true
+	if len(alias.AnyOf) > 0 {
+		found := false
+		for _, f := range alias.AnyOf {
+			if m.Supports(f) {
+				found = true
+				break
+			}
+		}
+		result = result && found
+	}
+	if len(alias.Families) > 0 {
+		found := false
+		for _, family := range alias.Families {
+			if m.Family().Name == family {
+				found = true
+				break
+			}
+		}
+		result = result && found
+	}
+	return result
+}
+
+// CompatibleWith returns true if binaries built for m can run on other,
+// meaning m is other or one of its ancestors
+func (m Microarchitecture) CompatibleWith(other Microarchitecture) bool {
+	if m.Name == other.Name {
+		return true
+	}
+	for _, arch := range other.Ancestors() {
+		if arch.Name == m.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// CompilerFlags returns how the newest versions of a compiler target the
+// microarchitecture, with the name in the flags filled in (e.g., for gcc and
+// x86_64_v2, -march=x86-64-v2 -mtune=generic from 11.1)
+func (m Microarchitecture) CompilerFlags(compiler string) (Compiler, bool) {
+	best, found := Compiler{}, false
+	for _, entry := range m.Compilers[compiler] {
+		if len(entry.Family) > 0 && !utils.IncludesString(m.Family().Name, entry.Family) {
+			continue
+		}
+		since, _, _ := strings.Cut(entry.Versions, ":")
+		bestSince, _, _ := strings.Cut(best.Versions, ":")
+		if !found || utils.CompareVersions(since, bestSince) > 0 {
+			best, found = entry, true
+		}
+	}
+	if !found {
+		return Compiler{}, false
+	}
+	name := best.Name
+	if name == "" {
+		name = m.Name
+	}
+	best.Flags = strings.ReplaceAll(best.Flags, "{name}", name)
+	return best, true
+}
+
+// Requires describes the compiler versions of an entry, e.g., >=11.1 for
+// 11.1: or >=4.6,<=11.0 for 4.6:11.0, and nothing for any version
+func (c Compiler) Requires() string {
+	since, until, _ := strings.Cut(c.Versions, ":")
+	switch {
+	case since != "" && until != "":
+		return ">=" + since + ",<=" + until
+	case since != "":
+		return ">=" + since
+	case until != "":
+		return "<=" + until
+	}
+	return ""
+}
+
+// SortedMicroarchitectures returns database names in a stable order
+func SortedMicroarchitectures() []string {
+	names := []string{}
+	for name := range CpuArches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// KnownFeature determines if any database entry (or alias) names the feature
+func KnownFeature(feature string) bool {
+	if _, ok := FeatureAliases[feature]; ok {
+		return true
+	}
+	for _, arch := range CpuArches {
+		for _, f := range arch.Features {
+			if f == feature {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// LowestMicroarchitecture returns the least specific entry of a family that
+// supports every feature, preferring generic targets (e.g., x86_64_v3) to
+// vendor ones so binaries built for it run on the most hosts
+func LowestMicroarchitecture(family string, features []string) (Microarchitecture, bool) {
+	candidates := []Microarchitecture{}
+	for _, name := range SortedMicroarchitectures() {
+		arch := CpuArches[name]
+		if arch.Family().Name != family {
+			continue
+		}
+		supported := true
+		for _, feature := range features {
+			if !arch.Supports(feature) {
+				supported = false
+				break
+			}
+		}
+		if supported {
+			candidates = append(candidates, arch)
+		}
+	}
+	if len(candidates) == 0 {
+		return Microarchitecture{}, false
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if (a.Vendor == "generic") != (b.Vendor == "generic") {
+			return a.Vendor == "generic"
+		}
+		if a.Depth() != b.Depth() {
+			return a.Depth() < b.Depth()
+		}
+		return len(a.Features) < len(b.Features)
+	})
+	return candidates[0], true
+}
@@ -0,0 +1,217 @@
+package spec
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The same target is spelled linux/amd64/v3 (OCI), GOARCH=amd64 GOAMD64=v3
+// (Go), x86_64 (uname -m) and x86_64_v3 (the CPU database). OCI and Go only
+// know generic levels, so a vendor target (e.g., haswell) maps to the most
+// specific level it includes.
+
+// Platform is a target named as OCI, Go and uname do
+type Platform struct {
+	OCI     string `json:"oci"`
+	GOARCH  string `json:"goarch"`
+	GOAMD64 string `json:"goamd64,omitempty"`
+	GOARM   string `json:"goarm,omitempty"`
+	GOPPC64 string `json:"goppc64,omitempty"`
+	Uname   string `json:"uname"`
+}
+
+// goarchFamilies maps GOARCH (also the OCI architecture) to families
+var goarchFamilies = map[string]string{
+	"amd64":    "x86_64",
+	"386":      "x86",
+	"arm64":    "aarch64",
+	"arm":      "arm",
+	"ppc64le":  "ppc64le",
+	"ppc64":    "ppc64",
+	"sparc64":  "sparc64",
+	"riscv64":  "riscv64",
+	"s390x":    "s390x",
+	"loong64":  "loong64",
+	"mips64le": "mips64le",
+}
+
+// unameFamilies maps uname -m to families, including names other kernels use
+var unameFamilies = map[string]string{
+	"x86_64":  "x86_64",
+	"amd64":   "x86_64",
+	"i386":    "x86",
+	"i486":    "x86",
+	"i586":    "x86",
+	"i686":    "x86",
+	"aarch64": "aarch64",
+	"arm64":   "aarch64",
+	"armv6l":  "arm",
+	"armv7l":  "arm",
+	"armv8l":  "arm",
+	"ppc64le": "ppc64le",
+	"ppc64":   "ppc64",
+	"ppc":     "ppc",
+	"sparc64": "sparc64",
+	"riscv64": "riscv64",
+	"s390x":   "s390x",
+}
+
+// familyUname is what uname -m prints for a family
+var familyUname = map[string]string{
+	"x86_64":  "x86_64",
+	"x86":     "i686",
+	"aarch64": "aarch64",
+	"arm":     "armv7l",
+	"ppc64le": "ppc64le",
+	"ppc64":   "ppc64",
+}
+
+// amd64Levels are the GOAMD64 values and OCI variants of the generic levels
+var amd64Levels = map[string]string{
+	"v1": "x86_64",
+	"v2": "x86_64_v2",
+	"v3": "x86_64_v3",
+	"v4": "x86_64_v4",
+}
+
+// armLevels are the GOARM values, the OCI variants are v5 to v7. The CPU
+// database has one 32 bit ARM target, so they all map to it.
+var armLevels = map[string]bool{
+	"5": true,
+	"6": true,
+	"7": true,
+}
+
+// GoarchFamily maps a GOARCH to an architecture family in the CPU database
+func GoarchFamily(goarch string) string {
+	if family, ok := goarchFamilies[goarch]; ok {
+		return family
+	}
+	return goarch
+}
+
+// UnameFamily maps uname -m to an architecture family in the CPU database
+func UnameFamily(machine string) (string, bool) {
+	family, ok := unameFamilies[machine]
+	return family, ok
+}
+
+// TargetOfOCI maps an OCI platform, os/arch[/variant] (e.g., linux/amd64/v3),
+// to a target. Variants we don't know (e.g., arm64 v8.2) are the family,
+// except for 32 bit ARM (see TargetOfGo).
+func TargetOfOCI(value string) (string, error) {
+	parts := strings.Split(value, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("%s is not a platform in the format os/arch[/variant]", value)
+	}
+	variant := ""
+	if len(parts) == 3 {
+		variant = parts[2]
+	}
+	target, ok := TargetOfGo(parts[1], variant)
+	if !ok {
+		if _, known := CpuArches[GoarchFamily(parts[1])]; known {
+			return "", fmt.Errorf("%s is not a variant of %s", variant, parts[1])
+		}
+		return "", fmt.Errorf("%s is not an architecture in the CPU database", parts[1])
+	}
+	return target, nil
+}
+
+// TargetOfGo maps GOARCH and its level (GOAMD64, GOARM or GOPPC64) or OCI
+// variant to a target, e.g., amd64 and v3 to x86_64_v3, or ppc64le and
+// power9 to power9le. ARM levels other than GOARM's aren't a target, and
+// other levels the CPU database doesn't have are the family.
+func TargetOfGo(goarch, level string) (string, bool) {
+	family := GoarchFamily(goarch)
+	if _, ok := CpuArches[family]; !ok {
+		return "", false
+	}
+	switch family {
+	case "x86_64":
+		if target, ok := amd64Levels[level]; ok {
+			return target, true
+		}
+	case "arm":
+		// GOARM can also give the float ABI, e.g., 7,softfloat
+		level, _, _ = strings.Cut(strings.TrimPrefix(level, "v"), ",")
+		if level != "" && !armLevels[level] {
+			return "", false
+		}
+	case "ppc64le", "ppc64":
+		name := level
+		if family == "ppc64le" {
+			name += "le"
+		}
+		if arch, ok := CpuArches[name]; ok && arch.Family().Name == family {
+			return name, true
+		}
+	}
+	return family, true
+}
+
+// PlatformOf names a target as OCI, Go and uname do. The level is the most
+// specific generic one the target includes (e.g., v3 for haswell), or for
+// POWER the newest generation.
+func PlatformOf(target string) (Platform, bool) {
+	arch, ok := LookupMicroarchitecture(target)
+	if !ok {
+		return Platform{}, false
+	}
+	family := arch.Family().Name
+
+	// The Intel 32 bit entries descend from i686, not x86
+	if family == "i686" {
+		family = "x86"
+	}
+	platform := Platform{Uname: familyUname[family]}
+	for goarch, f := range goarchFamilies {
+		if f == family {
+			platform.GOARCH = goarch
+		}
+	}
+	if platform.GOARCH == "" {
+		return Platform{}, false
+	}
+	platform.OCI = "linux/" + platform.GOARCH
+
+	switch family {
+	case "x86_64":
+		platform.GOAMD64 = amd64Level(arch)
+		if platform.GOAMD64 != "v1" {
+			platform.OCI += "/" + platform.GOAMD64
+		}
+	case "aarch64":
+		platform.OCI += "/v8"
+	case "arm":
+		platform.GOARM = "7"
+		platform.OCI += "/v7"
+	case "ppc64le", "ppc64":
+		generation := arch.Generation
+		for _, ancestor := range arch.Ancestors() {
+			if ancestor.Generation > generation {
+				generation = ancestor.Generation
+			}
+		}
+		if generation >= 8 {
+			platform.GOPPC64 = fmt.Sprintf("power%d", generation)
+		}
+	}
+	return platform, true
+}
+
+// amd64Level returns the most specific generic level a target includes
+func amd64Level(arch Microarchitecture) string {
+	levels := []string{}
+	for level := range amd64Levels {
+		levels = append(levels, level)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(levels)))
+	for _, level := range levels {
+		if generic := CpuArches[amd64Levels[level]]; generic.CompatibleWith(arch) {
+			return level
+		}
+	}
+	return "v1"
+}
@@ -0,0 +1,116 @@
+package spec
+
+import (
+	"testing"
+)
+
+func TestPlatformOf(t *testing.T) {
+	tests := []struct {
+		target   string
+		platform Platform
+	}{
+		{target: "x86_64_v3", platform: Platform{OCI: "linux/amd64/v3", GOARCH: "amd64", GOAMD64: "v3", Uname: "x86_64"}},
+		{target: "haswell", platform: Platform{OCI: "linux/amd64/v3", GOARCH: "amd64", GOAMD64: "v3", Uname: "x86_64"}},
+		{target: "skylake_avx512", platform: Platform{OCI: "linux/amd64/v4", GOARCH: "amd64", GOAMD64: "v4", Uname: "x86_64"}},
+		{target: "x86_64", platform: Platform{OCI: "linux/amd64", GOARCH: "amd64", GOAMD64: "v1", Uname: "x86_64"}},
+		{target: "power9le", platform: Platform{OCI: "linux/ppc64le", GOARCH: "ppc64le", GOPPC64: "power9", Uname: "ppc64le"}},
+		{target: "aarch64", platform: Platform{OCI: "linux/arm64/v8", GOARCH: "arm64", Uname: "aarch64"}},
+		{target: "graviton2", platform: Platform{OCI: "linux/arm64/v8", GOARCH: "arm64", Uname: "aarch64"}},
+		{target: "arm", platform: Platform{OCI: "linux/arm/v7", GOARCH: "arm", GOARM: "7", Uname: "armv7l"}},
+	}
+	for _, test := range tests {
+		platform, ok := PlatformOf(test.target)
+		if !ok || platform != test.platform {
+			t.Errorf("%s: expected %+v, got %+v (%v)", test.target, test.platform, platform, ok)
+		}
+	}
+	if _, ok := PlatformOf("ppc"); ok {
+		t.Error("expected no platform for a family without a GOARCH in the database")
+	}
+}
+
+func TestTargetOfOCI(t *testing.T) {
+	tests := []struct {
+		platform string
+		target   string
+	}{
+		{platform: "linux/amd64/v3", target: "x86_64_v3"},
+		{platform: "linux/amd64", target: "x86_64"},
+		{platform: "linux/amd64/v9", target: "x86_64"},
+		{platform: "linux/ppc64le/power9", target: "power9le"},
+		{platform: "linux/ppc64/power8", target: "power8"},
+		{platform: "linux/arm64/v8", target: "aarch64"},
+		{platform: "linux/arm64/v8.2", target: "aarch64"},
+		{platform: "linux/arm/v7", target: "arm"},
+		{platform: "linux/arm/v5", target: "arm"},
+		{platform: "linux/arm/v9"},
+		{platform: "linux/mips/v1"},
+		{platform: "amd64"},
+	}
+	for _, test := range tests {
+		target, err := TargetOfOCI(test.platform)
+		if target != test.target || (err != nil) != (test.target == "") {
+			t.Errorf("%s: expected %q, got %q (%v)", test.platform, test.target, target, err)
+		}
+	}
+}
+
+func TestTargetOfGo(t *testing.T) {
+	tests := []struct {
+		goarch string
+		level  string
+		target string
+	}{
+		{goarch: "amd64", level: "v4", target: "x86_64_v4"},
+		{goarch: "amd64", target: "x86_64"},
+		{goarch: "ppc64le", level: "power8", target: "power8le"},
+		{goarch: "ppc64le", level: "power10", target: "ppc64le"},
+		{goarch: "arm", level: "6", target: "arm"},
+		{goarch: "arm", level: "7,softfloat", target: "arm"},
+		{goarch: "arm", level: "8"},
+		{goarch: "wasm"},
+	}
+	for _, test := range tests {
+		target, ok := TargetOfGo(test.goarch, test.level)
+		if target != test.target || ok != (test.target != "") {
+			t.Errorf("%s %s: expected %q, got %q (%v)", test.goarch, test.level, test.target, target, ok)
+		}
+	}
+
+	// A target's platform maps back to its generic level
+	for _, target := range []string{"x86_64", "x86_64_v2", "x86_64_v3", "x86_64_v4", "power8le", "power9le", "power9", "aarch64", "arm"} {
+		platform, _ := PlatformOf(target)
+		level := platform.GOAMD64 + platform.GOARM + platform.GOPPC64
+		if back, ok := TargetOfGo(platform.GOARCH, level); !ok || back != target {
+			t.Errorf("%s: expected %s/%s to map back, got %q", target, platform.GOARCH, level, back)
+		}
+
+		// OCI doesn't have POWER variants
+		if back, err := TargetOfOCI(platform.OCI); err != nil || (back != target && platform.GOPPC64 == "") {
+			t.Errorf("%s: expected %s to map back, got %q (%v)", target, platform.OCI, back, err)
+		}
+	}
+}
+
+func TestParseHost(t *testing.T) {
+	tests := []struct {
+		content string
+		arch    string
+	}{
+		{content: `{"arch": "x86_64", "target": "haswell"}`, arch: "x86_64"},
+		{content: `{"arch": "amd64"}`, arch: "x86_64"},
+		{content: `{"arch": "arm64"}`, arch: "aarch64"},
+		{content: `{"arch": "armv7l"}`, arch: "arm"},
+		{content: `{"arch": "i686"}`, arch: "x86"},
+		{content: `{"arch": "mips"}`, arch: "mips"},
+	}
+	for _, test := range tests {
+		host, err := ParseHost([]byte(test.content))
+		if err != nil || host.Arch != test.arch {
+			t.Errorf("%s: expected %s, got %v (%v)", test.content, test.arch, host, err)
+		}
+	}
+	if _, err := ParseHost([]byte("{")); err == nil {
+		t.Error("expected an error for a host spec that isn't json")
+	}
+}
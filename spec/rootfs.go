@@ -0,0 +1,197 @@
+package spec
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/vsoch/containerspec/rootfs"
+)
+
+// Rootfs is what we can detect by inspecting a container root file system.
+// The target is left for binaries.Analyze, since the ELF headers only give
+// the architecture family.
+type Rootfs struct {
+	Arch      string   `json:"arch,omitempty"`
+	Target    string   `json:"target,omitempty"`
+	Glibc     string   `json:"glibc,omitempty"`
+	MPI       string   `json:"mpi,omitempty"`
+	GPU       []string `json:"gpu,omitempty"`
+	OS        string   `json:"os,omitempty"`
+	OSVersion string   `json:"os_version,omitempty"`
+}
+
+var (
+	// libc.so in /usr/lib is a linker script, not the library
+	libcRegex         = regexp.MustCompile(`^libc(\.so\.6|-[0-9]+\.[0-9]+\.so)$`)
+	libcFileRegex     = regexp.MustCompile(`^libc-([0-9]+\.[0-9]+)\.so$`)
+	libcReleaseRegex  = regexp.MustCompile(`release version ([0-9]+\.[0-9]+)`)
+	osReleaseLocation = []string{"etc/os-release", "usr/lib/os-release"}
+)
+
+// Library prefixes that identify an MPI implementation. The MPICH ABI
+// (soname libmpi.so.12) is shared by MPICH, Intel MPI and MVAPICH.
+var mpiLibraries = map[string]string{
+	"libmpich.so":     "mpich",
+	"libmpi.so.12":    "mpich",
+	"libopen-pal.so":  "openmpi",
+	"libopen-rte.so":  "openmpi",
+	"libmpi.so.40":    "openmpi",
+	"libmpi.so.20":    "openmpi",
+	"libmpi_mpifh.so": "openmpi",
+}
+
+// Library prefixes that identify a GPU runtime
+var gpuLibraries = map[string]string{
+	"libcudart.so":        "cuda",
+	"libcuda.so":          "cuda",
+	"libcublas.so":        "cuda",
+	"libamdhip64.so":      "rocm",
+	"libhsa-runtime64.so": "rocm",
+	"librocblas.so":       "rocm",
+	"libOpenCL.so":        "opencl",
+}
+
+// DetectRootfs inspects a container root file system (e.g., rootfs.Dir)
+func DetectRootfs(fsys fs.FS) (*Rootfs, error) {
+	info := Rootfs{}
+	machines := map[string]int{}
+	gpus := map[string]bool{}
+	libc := ""
+
+	err := rootfs.WalkFiles(fsys, func(name string, d fs.DirEntry) error {
+		base := path.Base(name)
+		if libcRegex.MatchString(base) && libc == "" {
+			libc = name
+		}
+		for prefix, family := range mpiLibraries {
+			if strings.HasPrefix(base, prefix) && info.MPI == "" {
+				info.MPI = family
+			}
+		}
+		for prefix, gpu := range gpuLibraries {
+			if strings.HasPrefix(base, prefix) {
+				gpus[gpu] = true
+			}
+		}
+		if family := elfFamily(fsys, name); family != "" {
+			machines[family]++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The architecture is the one most binaries are built for
+	count := 0
+	for family, n := range machines {
+		if n > count || (n == count && family < info.Arch) {
+			info.Arch, count = family, n
+		}
+	}
+
+	if libc != "" {
+		info.Glibc = glibcVersion(fsys, libc)
+	}
+	for gpu := range gpus {
+		info.GPU = append(info.GPU, gpu)
+	}
+	sort.Strings(info.GPU)
+	info.OS, info.OSVersion = osRelease(fsys)
+	return &info, nil
+}
+
+// elfFamily reads the ELF header of a file and returns its architecture
+// family in the CPU database, or an empty string if it isn't an ELF file
+func elfFamily(fsys fs.FS, name string) string {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	header := make([]byte, 20)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return ""
+	}
+	if !bytes.Equal(header[:4], []byte(elf.ELFMAG)) {
+		return ""
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if elf.Data(header[elf.EI_DATA]) == elf.ELFDATA2MSB {
+		order = binary.BigEndian
+	}
+	return MachineFamily(elf.Machine(order.Uint16(header[18:20])), order)
+}
+
+// MachineFamily maps an ELF machine to an architecture family in the CPU database
+func MachineFamily(machine elf.Machine, order binary.ByteOrder) string {
+	switch machine {
+	case elf.EM_X86_64:
+		return "x86_64"
+	case elf.EM_386:
+		return "x86"
+	case elf.EM_AARCH64:
+		return "aarch64"
+	case elf.EM_ARM:
+		return "arm"
+	case elf.EM_PPC64:
+		if order == binary.LittleEndian {
+			return "ppc64le"
+		}
+		return "ppc64"
+	case elf.EM_PPC:
+		return "ppc"
+	case elf.EM_SPARCV9:
+		return "sparc64"
+	case elf.EM_SPARC:
+		return "sparc"
+	}
+	return ""
+}
+
+// glibcVersion finds the release version of a GNU libc shared object
+func glibcVersion(fsys fs.FS, name string) string {
+	if match := libcFileRegex.FindStringSubmatch(path.Base(name)); match != nil {
+		return match[1]
+	}
+	content, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return ""
+	}
+	// The banner is "GNU C Library ... stable release version 2.35."
+	if match := libcReleaseRegex.FindSubmatch(content); match != nil {
+		return string(match[1])
+	}
+	return ""
+}
+
+// osRelease returns the distribution ID and VERSION_ID from os-release
+func osRelease(fsys fs.FS) (string, string) {
+	for _, location := range osReleaseLocation {
+		file, err := rootfs.Open(fsys, location)
+		if err != nil {
+			continue
+		}
+		defer file.Close()
+
+		values := map[string]string{}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			pair := strings.SplitN(scanner.Text(), "=", 2)
+			if len(pair) == 2 {
+				values[pair[0]] = strings.Trim(pair[1], `"'`)
+			}
+		}
+		return values["ID"], values["VERSION_ID"]
+	}
+	return "", ""
+}
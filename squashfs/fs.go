@@ -0,0 +1,291 @@
+package squashfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/vsoch/containerspec/rootfs"
+)
+
+// lookup finds an inode without following symlinks in any component
+func (f *FS) lookup(name string) (*inode, error) {
+	n := f.root
+	if name == "." {
+		return n, nil
+	}
+	for _, part := range strings.Split(name, "/") {
+		if !n.isDir() {
+			return nil, fs.ErrNotExist
+		}
+		entries, err := f.readDir(n)
+		if err != nil {
+			return nil, err
+		}
+		found := false
+		for _, entry := range entries {
+			if entry.name == part {
+				if n, err = f.readInode(entry.ref); err != nil {
+					return nil, err
+				}
+				n.name = part
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fs.ErrNotExist
+		}
+	}
+	return n, nil
+}
+
+// Lstat returns file info without following a symlink in the last component
+func (f *FS) Lstat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrInvalid}
+	}
+	dir, err := rootfs.Resolve(f, path.Dir(name))
+	if err != nil {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: err}
+	}
+	n, err := f.lookup(rootfs.Clean(path.Join(dir, path.Base(name))))
+	if err != nil {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: err}
+	}
+	return fileInfo{n}, nil
+}
+
+// ReadLink returns the target of a symlink
+func (f *FS) ReadLink(name string) (string, error) {
+	info, err := f.Lstat(name)
+	if err != nil {
+		return "", err
+	}
+	if info.Mode()&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return info.(fileInfo).inode.target, nil
+}
+
+// resolve follows symlinks within the root and returns the inode
+func (f *FS) resolve(op, name string) (*inode, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	resolved, err := rootfs.Resolve(f, name)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	n, err := f.lookup(resolved)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	if name != "." {
+		n.name = path.Base(name)
+	}
+	return n, nil
+}
+
+// Open opens a file or directory, following symlinks within the root
+func (f *FS) Open(name string) (fs.File, error) {
+	n, err := f.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if n.isDir() {
+		entries, err := f.entries(n)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &openDir{info: fileInfo{n}, entries: entries}, nil
+	}
+	file := &openFile{fsys: f, inode: n}
+	file.SectionReader = io.NewSectionReader(file, 0, int64(n.size))
+	return file, nil
+}
+
+// ReadDir lists a directory sorted by name
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	n, err := f.resolve("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !n.isDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return f.entries(n)
+}
+
+// Stat returns file info, following symlinks within the root
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	n, err := f.resolve("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{n}, nil
+}
+
+// entries returns directory entries, which squashfs stores sorted by name
+func (f *FS) entries(n *inode) ([]fs.DirEntry, error) {
+	entries, err := f.readDir(n)
+	if err != nil {
+		return nil, err
+	}
+	list := []fs.DirEntry{}
+	for _, entry := range entries {
+		list = append(list, dirEntry{fsys: f, dirent: entry})
+	}
+	return list, nil
+}
+
+// dirEntry reads the inode only when Info is called
+type dirEntry struct {
+	fsys   *FS
+	dirent dirent
+}
+
+func (d dirEntry) Name() string      { return d.dirent.name }
+func (d dirEntry) IsDir() bool       { return d.Type().IsDir() }
+func (d dirEntry) Type() fs.FileMode { return direntType(d.dirent.kind) }
+
+func (d dirEntry) Info() (fs.FileInfo, error) {
+	n, err := d.fsys.readInode(d.dirent.ref)
+	if err != nil {
+		return nil, err
+	}
+	n.name = d.dirent.name
+	return fileInfo{n}, nil
+}
+
+// fileInfo implements fs.FileInfo
+type fileInfo struct {
+	inode *inode
+}
+
+func (i fileInfo) Name() string       { return i.inode.name }
+func (i fileInfo) Size() int64        { return int64(i.inode.size) }
+func (i fileInfo) Mode() fs.FileMode  { return i.inode.mode }
+func (i fileInfo) ModTime() time.Time { return i.inode.modTime }
+func (i fileInfo) IsDir() bool        { return i.inode.isDir() }
+func (i fileInfo) Sys() interface{}   { return nil }
+
+// openFile reads data blocks as needed, keeping the last one
+type openFile struct {
+	*io.SectionReader
+	fsys  *FS
+	inode *inode
+
+	block     int
+	blockData []byte
+}
+
+func (o *openFile) Stat() (fs.FileInfo, error) { return fileInfo{o.inode}, nil }
+func (o *openFile) Close() error               { return nil }
+
+// ReadAt implements io.ReaderAt over the file's blocks and fragment
+func (o *openFile) ReadAt(p []byte, offset int64) (int, error) {
+	size := int64(o.inode.size)
+	if offset >= size {
+		return 0, io.EOF
+	}
+	blockSize := int64(o.fsys.super.BlockSize)
+	read := 0
+	for read < len(p) && offset < size {
+		index := int(offset / blockSize)
+		data, err := o.readBlock(index)
+		if err != nil {
+			return read, err
+		}
+		within := offset - int64(index)*blockSize
+		if within >= int64(len(data)) {
+			return read, io.ErrUnexpectedEOF
+		}
+		n := copy(p[read:], data[within:])
+		read += n
+		offset += int64(n)
+	}
+	if read < len(p) {
+		return read, io.EOF
+	}
+	return read, nil
+}
+
+// readBlock returns the decompressed content of block index of the file
+func (o *openFile) readBlock(index int) ([]byte, error) {
+	if o.blockData != nil && o.block == index {
+		return o.blockData, nil
+	}
+	n := o.inode
+	blockSize := int64(o.fsys.super.BlockSize)
+	length := int64(n.size) - int64(index)*blockSize
+	if length > blockSize {
+		length = blockSize
+	}
+
+	var data []byte
+	if index < len(n.blockSizes) {
+		position := int64(n.blocksStart)
+		for _, size := range n.blockSizes[:index] {
+			position += int64(size &^ blockUncompBit)
+		}
+		if n.blockSizes[index] == 0 {
+			// A sparse block is all zeros
+			data = make([]byte, length)
+		} else {
+			var err error
+			if data, err = o.fsys.readBlock(position, n.blockSizes[index]); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		entry, err := o.fsys.readFragment(n.fragment)
+		if err != nil {
+			return nil, err
+		}
+		block, err := o.fsys.readBlock(int64(entry.Start), entry.Size)
+		if err != nil {
+			return nil, err
+		}
+		end := int64(n.fragmentOffset) + length
+		if end > int64(len(block)) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		data = block[n.fragmentOffset:end]
+	}
+	o.block, o.blockData = index, data
+	return data, nil
+}
+
+// openDir is a directory that can be listed
+type openDir struct {
+	info    fileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *openDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *openDir) Close() error               { return nil }
+
+func (d *openDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: errors.New("is a directory")}
+}
+
+func (d *openDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	remaining := d.entries[d.offset:]
+	if count <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(remaining) {
+		count = len(remaining)
+	}
+	d.offset += count
+	return remaining[:count], nil
+}
@@ -0,0 +1,286 @@
+package squashfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+)
+
+// Inode types, basic and extended
+const (
+	typeDir        = 1
+	typeFile       = 2
+	typeSymlink    = 3
+	typeBlock      = 4
+	typeChar       = 5
+	typeFifo       = 6
+	typeSocket     = 7
+	typeExtDir     = 8
+	typeExtFile    = 9
+	typeExtSymlink = 10
+	typeExtBlock   = 11
+	typeExtChar    = 12
+	typeExtFifo    = 13
+	typeExtSocket  = 14
+)
+
+// inodeHeader is common to every inode
+type inodeHeader struct {
+	Type        uint16
+	Permissions uint16
+	UID         uint16
+	GID         uint16
+	ModTime     uint32
+	Number      uint32
+}
+
+// inode holds what we need of any inode type
+type inode struct {
+	name    string
+	mode    fs.FileMode
+	modTime time.Time
+
+	// Directories list entries in the directory table
+	dirBlock  uint32
+	dirOffset uint16
+	dirSize   uint32
+
+	// Files are data blocks, then the tail in a fragment
+	blocksStart    uint64
+	size           uint64
+	fragment       uint32
+	fragmentOffset uint32
+	blockSizes     []uint32
+
+	// Symlinks
+	target string
+}
+
+func (n *inode) isDir() bool {
+	return n.mode.IsDir()
+}
+
+// readInode reads an inode given a reference: the metadata block position
+// relative to the inode table, and the offset in that block
+func (f *FS) readInode(ref uint64) (*inode, error) {
+	reader, err := f.metadata(int64(f.super.InodeTable+ref>>16), int(ref&0xffff))
+	if err != nil {
+		return nil, err
+	}
+	var header inodeHeader
+	if err := binary.Read(reader, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+	n := inode{
+		mode:     permissions(header.Permissions),
+		modTime:  time.Unix(int64(header.ModTime), 0),
+		fragment: noFragment,
+	}
+
+	switch header.Type {
+	case typeDir:
+		var dir struct {
+			Block       uint32
+			LinkCount   uint32
+			Size        uint16
+			Offset      uint16
+			ParentInode uint32
+		}
+		err = binary.Read(reader, binary.LittleEndian, &dir)
+		n.mode |= fs.ModeDir
+		n.dirBlock, n.dirOffset, n.dirSize = dir.Block, dir.Offset, uint32(dir.Size)
+
+	case typeExtDir:
+		var dir struct {
+			LinkCount   uint32
+			Size        uint32
+			Block       uint32
+			ParentInode uint32
+			IndexCount  uint16
+			Offset      uint16
+			Xattr       uint32
+		}
+		err = binary.Read(reader, binary.LittleEndian, &dir)
+		n.mode |= fs.ModeDir
+		n.dirBlock, n.dirOffset, n.dirSize = dir.Block, dir.Offset, dir.Size
+
+	case typeFile:
+		var file struct {
+			BlocksStart    uint32
+			Fragment       uint32
+			FragmentOffset uint32
+			Size           uint32
+		}
+		if err = binary.Read(reader, binary.LittleEndian, &file); err == nil {
+			n.blocksStart, n.size = uint64(file.BlocksStart), uint64(file.Size)
+			n.fragment, n.fragmentOffset = file.Fragment, file.FragmentOffset
+			err = f.readBlockSizes(reader, &n)
+		}
+
+	case typeExtFile:
+		var file struct {
+			BlocksStart    uint64
+			Size           uint64
+			Sparse         uint64
+			LinkCount      uint32
+			Fragment       uint32
+			FragmentOffset uint32
+			Xattr          uint32
+		}
+		if err = binary.Read(reader, binary.LittleEndian, &file); err == nil {
+			n.blocksStart, n.size = file.BlocksStart, file.Size
+			n.fragment, n.fragmentOffset = file.Fragment, file.FragmentOffset
+			err = f.readBlockSizes(reader, &n)
+		}
+
+	case typeSymlink, typeExtSymlink:
+		var link struct {
+			LinkCount  uint32
+			TargetSize uint32
+		}
+		if err = binary.Read(reader, binary.LittleEndian, &link); err == nil {
+			if err = f.checkSize("symlink target", uint64(link.TargetSize)); err != nil {
+				break
+			}
+			target := make([]byte, link.TargetSize)
+			_, err = io.ReadFull(reader, target)
+			n.target = string(target)
+			n.size = uint64(link.TargetSize)
+		}
+		n.mode |= fs.ModeSymlink
+
+	case typeBlock, typeExtBlock:
+		n.mode |= fs.ModeDevice
+	case typeChar, typeExtChar:
+		n.mode |= fs.ModeDevice | fs.ModeCharDevice
+	case typeFifo, typeExtFifo:
+		n.mode |= fs.ModeNamedPipe
+	case typeSocket, typeExtSocket:
+		n.mode |= fs.ModeSocket
+	default:
+		return nil, fmt.Errorf("unknown inode type %d", header.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading inode %d: %s", header.Number, err)
+	}
+	return &n, nil
+}
+
+// readBlockSizes reads the sizes of full data blocks, the tail of the file
+// is in a fragment unless there is none
+func (f *FS) readBlockSizes(reader io.Reader, n *inode) error {
+	blockSize := uint64(f.super.BlockSize)
+	count := n.size / blockSize
+	if n.fragment == noFragment && n.size%blockSize != 0 {
+		count++
+	}
+	if err := f.checkSize("block list", count*4); err != nil {
+		return err
+	}
+	n.blockSizes = make([]uint32, count)
+	return binary.Read(reader, binary.LittleEndian, n.blockSizes)
+}
+
+// permissions converts unix permission bits to an fs.FileMode
+func permissions(bits uint16) fs.FileMode {
+	mode := fs.FileMode(bits & 0777)
+	if bits&04000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if bits&02000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if bits&01000 != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode
+}
+
+// dirent is an entry in the directory table
+type dirent struct {
+	name string
+	ref  uint64
+	kind uint16
+}
+
+// direntType maps a directory entry type to fs.FileMode type bits
+func direntType(kind uint16) fs.FileMode {
+	switch kind {
+	case typeDir, typeExtDir:
+		return fs.ModeDir
+	case typeSymlink, typeExtSymlink:
+		return fs.ModeSymlink
+	case typeBlock, typeExtBlock:
+		return fs.ModeDevice
+	case typeChar, typeExtChar:
+		return fs.ModeDevice | fs.ModeCharDevice
+	case typeFifo, typeExtFifo:
+		return fs.ModeNamedPipe
+	case typeSocket, typeExtSocket:
+		return fs.ModeSocket
+	}
+	return 0
+}
+
+// readDir lists a directory inode, caching the result
+func (f *FS) readDir(n *inode) ([]dirent, error) {
+
+	// The size includes 3 bytes for the implicit . and .. entries. An empty
+	// directory takes no space, so its position is another's: don't cache it
+	if n.dirSize <= 3 {
+		return []dirent{}, nil
+	}
+	key := uint64(n.dirBlock)<<16 | uint64(n.dirOffset)
+	f.mutex.Lock()
+	entries, ok := f.directories[key]
+	f.mutex.Unlock()
+	if ok {
+		return entries, nil
+	}
+
+	entries = []dirent{}
+	reader, err := f.metadata(int64(f.super.DirectoryTable)+int64(n.dirBlock), int(n.dirOffset))
+	if err != nil {
+		return nil, err
+	}
+	remaining := int64(n.dirSize) - 3
+	for remaining > 0 {
+		var header struct {
+			Count  uint32
+			Start  uint32
+			Number uint32
+		}
+		if err := binary.Read(reader, binary.LittleEndian, &header); err != nil {
+			return nil, err
+		}
+		remaining -= 12
+		for i := uint32(0); i <= header.Count; i++ {
+			var entry struct {
+				Offset      uint16
+				InodeOffset int16
+				Type        uint16
+				NameSize    uint16
+			}
+			if err := binary.Read(reader, binary.LittleEndian, &entry); err != nil {
+				return nil, err
+			}
+			name := make([]byte, int(entry.NameSize)+1)
+			if _, err := io.ReadFull(reader, name); err != nil {
+				return nil, err
+			}
+			remaining -= 8 + int64(len(name))
+			entries = append(entries, dirent{
+				name: string(name),
+				ref:  uint64(header.Start)<<16 | uint64(entry.Offset),
+				kind: entry.Type,
+			})
+		}
+	}
+
+	f.mutex.Lock()
+	f.directories[key] = entries
+	f.mutex.Unlock()
+	return entries, nil
+}
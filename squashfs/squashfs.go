@@ -0,0 +1,341 @@
+package squashfs
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// A read-only squashfs 4.0 reader, so we can inspect squashfs images and SIF
+// partitions without mounting them (which needs privileges we don't have on
+// login nodes). See https://dr-emann.github.io/squashfs/squashfs.html
+
+const (
+	magic           = 0x73717368
+	superblockSize  = 96
+	metadataSize    = 8192
+	uncompressedBit = 1 << 15
+	blockUncompBit  = 1 << 24
+	noFragment      = 0xffffffff
+	fragmentSize    = 16
+)
+
+// Compression ids from the superblock
+const (
+	CompressionGzip = 1
+	CompressionLzma = 2
+	CompressionLzo  = 3
+	CompressionXz   = 4
+	CompressionLz4  = 5
+	CompressionZstd = 6
+)
+
+var compressionNames = map[uint16]string{
+	CompressionGzip: "gzip",
+	CompressionLzma: "lzma",
+	CompressionLzo:  "lzo",
+	CompressionXz:   "xz",
+	CompressionLz4:  "lz4",
+	CompressionZstd: "zstd",
+}
+
+// ErrNotSquashfs is returned when the superblock magic doesn't match
+var ErrNotSquashfs = errors.New("not a squashfs file system")
+
+// superblock is the first 96 bytes of the file system, little endian
+type superblock struct {
+	Magic          uint32
+	InodeCount     uint32
+	ModTime        uint32
+	BlockSize      uint32
+	FragmentCount  uint32
+	Compression    uint16
+	BlockLog       uint16
+	Flags          uint16
+	IDCount        uint16
+	VersionMajor   uint16
+	VersionMinor   uint16
+	RootInode      uint64
+	BytesUsed      uint64
+	IDTable        uint64
+	XattrTable     uint64
+	InodeTable     uint64
+	DirectoryTable uint64
+	FragmentTable  uint64
+	ExportTable    uint64
+}
+
+// fragment is an entry of the fragment table
+type fragment struct {
+	Start  uint64
+	Size   uint32
+	Unused uint32
+}
+
+// metadataBlock is a decompressed metadata block and where the next starts
+type metadataBlock struct {
+	data []byte
+	next int64
+}
+
+// FS is a squashfs file system read from an io.ReaderAt
+type FS struct {
+	reader     io.ReaderAt
+	super      superblock
+	decompress func([]byte) ([]byte, error)
+
+	mutex       sync.Mutex
+	blocks      map[int64]metadataBlock
+	directories map[uint64][]dirent
+	fragments   []fragment
+	root        *inode
+}
+
+// Open reads the superblock and root directory of a squashfs file system,
+// e.g., a file or a SIF partition (image.SIF OpenObject)
+func Open(reader io.ReaderAt) (*FS, error) {
+	f := FS{
+		reader:      reader,
+		blocks:      map[int64]metadataBlock{},
+		directories: map[uint64][]dirent{},
+	}
+	header := io.NewSectionReader(reader, 0, superblockSize)
+	if err := binary.Read(header, binary.LittleEndian, &f.super); err != nil {
+		return nil, err
+	}
+	if f.super.Magic != magic {
+		return nil, ErrNotSquashfs
+	}
+	if f.super.VersionMajor != 4 {
+		return nil, fmt.Errorf("squashfs version %d.%d is not supported", f.super.VersionMajor, f.super.VersionMinor)
+	}
+
+	// Sizes in inodes and tables are checked against the size of the file
+	// system, so make sure it is there, and that blocks have a valid size
+	if _, err := reader.ReadAt(make([]byte, 1), int64(f.super.BytesUsed)-1); err != nil {
+		return nil, fmt.Errorf("squashfs file system of %d bytes is truncated", f.super.BytesUsed)
+	}
+	if f.super.BlockSize < 4096 || f.super.BlockSize > 1<<20 || f.super.BlockSize != 1<<f.super.BlockLog {
+		return nil, fmt.Errorf("squashfs block size %d is not valid", f.super.BlockSize)
+	}
+
+	decompress, err := decompressor(f.super.Compression)
+	if err != nil {
+		return nil, err
+	}
+	f.decompress = decompress
+
+	root, err := f.readInode(f.super.RootInode)
+	if err != nil {
+		return nil, fmt.Errorf("reading root inode: %s", err)
+	}
+	root.name = "."
+	f.root = root
+	return &f, nil
+}
+
+// Compression returns the name of the compression algorithm
+func (f *FS) Compression() string {
+	return compressionNames[f.super.Compression]
+}
+
+// decompressor returns a function to decompress blocks. lzo and lz4 have no
+// pure Go implementation we rely on, so they are not supported.
+func decompressor(id uint16) (func([]byte) ([]byte, error), error) {
+	switch id {
+	case CompressionGzip:
+		return func(data []byte) ([]byte, error) {
+			reader, err := zlib.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			defer reader.Close()
+			return io.ReadAll(reader)
+		}, nil
+	case CompressionXz:
+		return func(data []byte) ([]byte, error) {
+			reader, err := xz.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			return io.ReadAll(reader)
+		}, nil
+	case CompressionLzma:
+		return func(data []byte) ([]byte, error) {
+			reader, err := lzma.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			return io.ReadAll(reader)
+		}, nil
+	case CompressionZstd:
+		decoder, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, err
+		}
+		return func(data []byte) ([]byte, error) {
+			return decoder.DecodeAll(data, nil)
+		}, nil
+	}
+	if name, ok := compressionNames[id]; ok {
+		return nil, fmt.Errorf("squashfs %s compression is not supported", name)
+	}
+	return nil, fmt.Errorf("unknown squashfs compression %d", id)
+}
+
+// readMetadataBlock reads and caches the metadata block at a position
+func (f *FS) readMetadataBlock(position int64) (metadataBlock, error) {
+	f.mutex.Lock()
+	block, ok := f.blocks[position]
+	f.mutex.Unlock()
+	if ok {
+		return block, nil
+	}
+
+	header := make([]byte, 2)
+	if _, err := f.reader.ReadAt(header, position); err != nil {
+		return block, err
+	}
+	size := binary.LittleEndian.Uint16(header)
+	compressed := size&uncompressedBit == 0
+	size &^= uncompressedBit
+
+	data := make([]byte, size)
+	if _, err := f.reader.ReadAt(data, position+2); err != nil {
+		return block, err
+	}
+	if compressed {
+		var err error
+		if data, err = f.decompress(data); err != nil {
+			return block, fmt.Errorf("metadata block at %d: %s", position, err)
+		}
+	}
+	block = metadataBlock{data: data, next: position + 2 + int64(size)}
+
+	f.mutex.Lock()
+	f.blocks[position] = block
+	f.mutex.Unlock()
+	return block, nil
+}
+
+// metadataReader reads metadata that may continue across blocks
+type metadataReader struct {
+	fsys *FS
+	next int64
+	data []byte
+}
+
+// metadata returns a reader starting at an offset in a metadata block
+func (f *FS) metadata(position int64, offset int) (*metadataReader, error) {
+	block, err := f.readMetadataBlock(position)
+	if err != nil {
+		return nil, err
+	}
+	if offset > len(block.data) {
+		return nil, fmt.Errorf("metadata offset %d is past the end of the block at %d", offset, position)
+	}
+	return &metadataReader{fsys: f, next: block.next, data: block.data[offset:]}, nil
+}
+
+func (m *metadataReader) Read(p []byte) (int, error) {
+	read := 0
+	for read < len(p) {
+		if len(m.data) == 0 {
+			block, err := m.fsys.readMetadataBlock(m.next)
+			if err != nil {
+				return read, err
+			}
+			m.next, m.data = block.next, block.data
+			if len(m.data) == 0 {
+				return read, io.ErrUnexpectedEOF
+			}
+		}
+		n := copy(p[read:], m.data)
+		m.data = m.data[n:]
+		read += n
+	}
+	return read, nil
+}
+
+// readFragment returns a fragment table entry, loading the table once
+func (f *FS) readFragment(index uint32) (fragment, error) {
+	f.mutex.Lock()
+	fragments := f.fragments
+	f.mutex.Unlock()
+
+	if fragments == nil {
+		count := int(f.super.FragmentCount)
+		blocks := (count*fragmentSize + metadataSize - 1) / metadataSize
+		if err := f.checkSize("fragment table", uint64(blocks)*8); err != nil {
+			return fragment{}, err
+		}
+		pointers := make([]uint64, blocks)
+		table := io.NewSectionReader(f.reader, int64(f.super.FragmentTable), int64(blocks*8))
+		if err := binary.Read(table, binary.LittleEndian, pointers); err != nil {
+			return fragment{}, fmt.Errorf("reading fragment table: %s", err)
+		}
+		fragments = make([]fragment, 0, count)
+		for _, pointer := range pointers {
+			reader, err := f.metadata(int64(pointer), 0)
+			if err != nil {
+				return fragment{}, err
+			}
+			n := count - len(fragments)
+			if n > metadataSize/fragmentSize {
+				n = metadataSize / fragmentSize
+			}
+			entries := make([]fragment, n)
+			if err := binary.Read(reader, binary.LittleEndian, entries); err != nil {
+				return fragment{}, fmt.Errorf("reading fragment table: %s", err)
+			}
+			fragments = append(fragments, entries...)
+		}
+		f.mutex.Lock()
+		f.fragments = fragments
+		f.mutex.Unlock()
+	}
+	if int(index) >= len(fragments) {
+		return fragment{}, fmt.Errorf("fragment %d is out of range", index)
+	}
+	return fragments[index], nil
+}
+
+// readBlock reads a data or fragment block given its size field
+func (f *FS) readBlock(position int64, sizeField uint32) ([]byte, error) {
+	size := sizeField &^ blockUncompBit
+	if size > f.super.BlockSize {
+		return nil, fmt.Errorf("block at %d of %d bytes is larger than the block size", position, size)
+	}
+	data := make([]byte, size)
+	if _, err := f.reader.ReadAt(data, position); err != nil {
+		return nil, err
+	}
+	if sizeField&blockUncompBit != 0 {
+		return data, nil
+	}
+	return f.decompress(data)
+}
+
+// checkSize fails if something claims to take more space than the file system
+func (f *FS) checkSize(what string, size uint64) error {
+	if size > f.super.BytesUsed {
+		return fmt.Errorf("%s of %d bytes is larger than the file system", what, size)
+	}
+	return nil
+}
+
+// Check that FS implements the file system interfaces detectors use
+var (
+	_ fs.ReadDirFS  = (*FS)(nil)
+	_ fs.StatFS     = (*FS)(nil)
+	_ fs.ReadLinkFS = (*FS)(nil)
+)
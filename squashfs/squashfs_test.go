@@ -0,0 +1,207 @@
+package squashfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testdata/root.sqfs is a gzip image with a 4096 byte block size:
+//
+//	etc/os-release     28 bytes, in a fragment
+//	usr/bin/tool       4096 bytes, one full block (mode 0755)
+//	usr/lib/libc.so.6  8492 bytes, two full blocks and a fragment
+//	usr/share/empty    an empty file
+//	lib                -> usr/lib
+//	usr/lib64          -> /usr/lib
+//	usr/bin/escape     -> ../../../../etc/os-release
+//
+// The content of the tool and the library is the byte pattern of pattern.
+
+// pattern is the content of a file with size bytes in the fixture
+func pattern(size int) []byte {
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	return content
+}
+
+// openFixture opens the fixture image
+func openFixture(t *testing.T) *FS {
+	t.Helper()
+	content, err := os.ReadFile("testdata/root.sqfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := Open(bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestOpen(t *testing.T) {
+	f := openFixture(t)
+	if f.Compression() != "gzip" {
+		t.Errorf("expected gzip compression, got %s", f.Compression())
+	}
+	if _, err := Open(bytes.NewReader(make([]byte, 4096))); !errors.Is(err, ErrNotSquashfs) {
+		t.Errorf("expected ErrNotSquashfs, got %v", err)
+	}
+	if _, err := Open(bytes.NewReader([]byte("hsqs"))); err == nil {
+		t.Error("expected an error for a truncated superblock")
+	}
+}
+
+func TestReadDir(t *testing.T) {
+	f := openFixture(t)
+	tests := []struct {
+		name    string
+		entries []string
+		modes   []fs.FileMode
+	}{
+		{name: ".", entries: []string{"etc", "lib", "usr"}, modes: []fs.FileMode{fs.ModeDir, fs.ModeSymlink, fs.ModeDir}},
+		{name: "usr", entries: []string{"bin", "lib", "lib64", "share"}, modes: []fs.FileMode{fs.ModeDir, fs.ModeDir, fs.ModeSymlink, fs.ModeDir}},
+		{name: "usr/bin", entries: []string{"escape", "tool"}, modes: []fs.FileMode{fs.ModeSymlink, 0}},
+		{name: "lib", entries: []string{"libc.so.6"}, modes: []fs.FileMode{0}},
+	}
+	for _, test := range tests {
+		entries, err := f.ReadDir(test.name)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		names, modes := []string{}, []fs.FileMode{}
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+			modes = append(modes, entry.Type())
+		}
+		if !reflect.DeepEqual(names, test.entries) || !reflect.DeepEqual(modes, test.modes) {
+			t.Errorf("%s: expected %v %v, got %v %v", test.name, test.entries, test.modes, names, modes)
+		}
+	}
+	if _, err := f.ReadDir("etc/os-release"); err == nil {
+		t.Error("expected an error listing a file")
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	f := openFixture(t)
+	tests := []struct {
+		name    string
+		content []byte
+	}{
+		{name: "etc/os-release", content: []byte("ID=alpine\nVERSION_ID=3.19.1\n")},
+		{name: "usr/bin/tool", content: pattern(4096)},
+		{name: "usr/lib/libc.so.6", content: pattern(2*4096 + 300)},
+		{name: "usr/share/empty", content: []byte{}},
+	}
+	for _, test := range tests {
+		content, err := fs.ReadFile(f, test.name)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if !bytes.Equal(content, test.content) {
+			t.Errorf("%s: expected %d bytes of content, got %d that differ", test.name, len(test.content), len(content))
+		}
+	}
+
+	// Reads that cross from a full block to the next one and to the fragment
+	file, err := f.Open("usr/lib/libc.so.6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := pattern(2*4096 + 300)
+	for _, offset := range []int64{4000, 8000, 8400} {
+		buffer := make([]byte, 200)
+		n, err := file.(io.ReaderAt).ReadAt(buffer, offset)
+		if end := min(offset+200, int64(len(want))); int64(n) != end-offset || !bytes.Equal(buffer[:n], want[offset:end]) {
+			t.Errorf("read at %d: expected %d bytes, got %d (%v)", offset, end-offset, n, err)
+		}
+	}
+}
+
+func TestSymlinks(t *testing.T) {
+	f := openFixture(t)
+	tests := []struct {
+		name   string
+		target string
+		dir    bool
+	}{
+		{name: "lib", target: "usr/lib", dir: true},
+		{name: "usr/lib64", target: "/usr/lib", dir: true},
+		{name: "usr/bin/escape", target: "../../../../etc/os-release"},
+	}
+	for _, test := range tests {
+		target, err := f.ReadLink(test.name)
+		if err != nil || target != test.target {
+			t.Errorf("%s: expected target %s, got %s (%v)", test.name, test.target, target, err)
+		}
+		info, err := f.Lstat(test.name)
+		if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+			t.Errorf("%s: expected a symlink, got %v (%v)", test.name, info, err)
+		}
+		info, err = f.Stat(test.name)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if info.Name() != path.Base(test.name) || info.IsDir() != test.dir || !test.dir && info.Size() != 28 {
+			t.Errorf("%s: expected the target, got %s with mode %s and %d bytes", test.name, info.Name(), info.Mode(), info.Size())
+		}
+	}
+
+	// Links resolve within the root, including in the middle of a path
+	for _, name := range []string{"lib/libc.so.6", "usr/lib64/libc.so.6"} {
+		content, err := fs.ReadFile(f, name)
+		if err != nil || len(content) != 2*4096+300 {
+			t.Errorf("%s: expected the library, got %d bytes (%v)", name, len(content), err)
+		}
+	}
+	if _, err := f.ReadLink("etc/os-release"); err == nil {
+		t.Error("expected an error reading a link of a file")
+	}
+	if _, err := f.Stat("usr/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected a missing file not to exist, got %v", err)
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	content, err := os.ReadFile("testdata/root.sqfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(bytes.NewReader(content[:len(content)/2])); err == nil {
+		t.Error("expected an error for a truncated image")
+	}
+
+	// A corrupt image fails, somewhere, without a panic. A corrupt entry can
+	// make a directory its own descendant, so don't walk too deep.
+	for i := 0; i < len(content); i += 7 {
+		for _, value := range []byte{0x00, 0xff} {
+			corrupt := bytes.Clone(content)
+			corrupt[i] = value
+			f, err := Open(bytes.NewReader(corrupt))
+			if err != nil {
+				continue
+			}
+			fs.WalkDir(f, ".", func(name string, entry fs.DirEntry, err error) error {
+				if err == nil && entry.IsDir() && strings.Count(name, "/") > 4 {
+					return fs.SkipDir
+				}
+				if err == nil && entry.Type().IsRegular() {
+					fs.ReadFile(f, name)
+				}
+				return nil
+			})
+		}
+	}
+}